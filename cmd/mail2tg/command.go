@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/oauth"
+	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"go.uber.org/zap"
+)
+
+const dlqUsage = `usage: mail2tg [-config path] dlq <command>
+
+commands:
+  list                 list undelivered messages
+  show <id>            show message details
+  replay <id>...|all   queue messages for sending by the running service
+  purge <id>...|all    delete messages`
+
+const authUsage = `usage: mail2tg [-config path] auth [account]
+
+Signs in to the account's OAuth2 provider with a device code and saves
+the refresh token to oauth_tokens_path. The account may be omitted if
+only one account uses OAuth2.`
+
+// runCommand выполняет подкоманду обслуживания и возвращает код завершения
+func runCommand(conf *config.CachedConfig, args []string, logger *zap.SugaredLogger) int {
+	switch args[0] {
+	case "dlq":
+		return runDLQ(conf, args[1:], logger)
+	case "auth":
+		return runAuth(conf, args[1:], logger)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s\n\n%s\n", args[0], dlqUsage, authUsage)
+		return 2
+	}
+}
+
+// runAuth получает токен обновления OAuth2 учётной записи по коду устройства
+// и проверяет вход на IMAP-сервер с полученным токеном
+func runAuth(conf *config.CachedConfig, args []string, logger *zap.SugaredLogger) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, authUsage)
+		return 2
+	}
+
+	var candidates []config.Account
+	for _, acc := range conf.Current().GetAccounts() {
+		if acc.IMAP.AuthMode() == config.IMAPAuthPassword {
+			continue
+		}
+		if len(args) == 0 || acc.Name == args[0] {
+			candidates = append(candidates, acc)
+		}
+	}
+	switch {
+	case len(candidates) == 0 && len(args) == 1:
+		fmt.Fprintf(os.Stderr, "account %q not found or does not use OAuth2 (imap.auth)\n", args[0])
+		return 1
+	case len(candidates) == 0:
+		fmt.Fprintln(os.Stderr, "no accounts use OAuth2 (imap.auth)")
+		return 1
+	case len(candidates) > 1:
+		fmt.Fprintf(os.Stderr, "several accounts use OAuth2, specify one\n%s\n", authUsage)
+		return 2
+	}
+	acc := candidates[0]
+
+	if err := oauth.Open(conf.Current().OAuthTokensPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := oauth.Authorize(ctx, acc, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("refresh token for account %q saved to %s\n", acc.Name, conf.Current().OAuthTokensPath)
+
+	c, err := email.ConnectToIMAP(acc, logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := c.Logout(); err != nil {
+		logger.Debugw("IMAP logout failed", "error", err)
+	}
+	fmt.Println("IMAP login: ok")
+	return 0
+}
+
+// runDLQ управляет хранилищем недоставленных сообщений
+func runDLQ(conf *config.CachedConfig, args []string, logger *zap.SugaredLogger) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, dlqUsage)
+		return 2
+	}
+
+	dead, err := telegram.OpenDeadLetters(conf.Current().DeadLetterPath, logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch cmd := args[0]; cmd {
+	case "list":
+		letters, err := dead.List()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tFAILED AT\tCHAT\tFAILURES\tREF\tERROR")
+		for _, dl := range letters {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n",
+				dl.ID, dl.FailedAt.Format("2006-01-02 15:04:05"), dl.ChatID, dl.Failures, dl.Ref, dl.LastError)
+		}
+		w.Flush()
+		return 0
+
+	case "show":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, dlqUsage)
+			return 2
+		}
+		dl, err := dead.Get(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("ID:          %s\n", dl.ID)
+		fmt.Printf("Chat:        %d\n", dl.ChatID)
+		fmt.Printf("Thread:      %d\n", dl.ThreadID)
+		fmt.Printf("Ref:         %s\n", dl.Ref)
+		fmt.Printf("Created at:  %s\n", dl.CreatedAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("Failed at:   %s\n", dl.FailedAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("Failures:    %d\n", dl.Failures)
+		fmt.Printf("Last error:  %s\n", dl.LastError)
+		fmt.Printf("Parse mode:  %s\n", dl.ParseMode)
+		fmt.Printf("Attachments: %d\n", len(dl.Attachments))
+		fmt.Printf("\n%s\n", dl.Text)
+		return 0
+
+	case "replay", "purge":
+		ids, err := dlqIDs(dead, args[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		code := 0
+		for _, id := range ids {
+			result := "ok"
+			if cmd == "replay" {
+				// Сообщение отправляет сервис через очередь, чтобы сохранить связь с письмом
+				err = dead.Replay(id, conf.Current().OutboxPath)
+				result = "queued"
+			} else {
+				err = dead.Remove(id)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", id, err)
+				code = 1
+				continue
+			}
+			fmt.Printf("%s: %s\n", id, result)
+		}
+		return code
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown dlq command %q\n%s\n", cmd, dlqUsage)
+		return 2
+	}
+}
+
+// dlqIDs возвращает идентификаторы из аргументов или все идентификаторы для "all"
+func dlqIDs(dead *telegram.DeadLetters, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no message ids given\n%s", dlqUsage)
+	}
+	if len(args) > 1 || args[0] != "all" {
+		return args, nil
+	}
+
+	letters, err := dead.List()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(letters))
+	for _, dl := range letters {
+		ids = append(ids, dl.ID)
+	}
+	return ids, nil
+}
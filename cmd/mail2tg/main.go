@@ -7,8 +7,19 @@ import (
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/actions"
+	"github.com/st-kuptsov/mail2tg/internal/alerts"
+	"github.com/st-kuptsov/mail2tg/internal/bot"
+	"github.com/st-kuptsov/mail2tg/internal/dedup"
+	"github.com/st-kuptsov/mail2tg/internal/digest"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/oauth"
+	"github.com/st-kuptsov/mail2tg/internal/postprocess"
+	"github.com/st-kuptsov/mail2tg/internal/reply"
 	"github.com/st-kuptsov/mail2tg/internal/scheduler"
+	"github.com/st-kuptsov/mail2tg/internal/state"
 	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"github.com/st-kuptsov/mail2tg/internal/threads"
 	logs "github.com/st-kuptsov/mail2tg/pkg/logs"
 	"github.com/st-kuptsov/mail2tg/pkg/metrics"
 	tb "gopkg.in/telebot.v3"
@@ -39,11 +50,16 @@ func main() {
 		log.Fatal(err)
 	}
 
-	logger := logs.DefaultLogger(conf.Config.Logging)
+	logger := logs.DefaultLogger(conf.Current().Logging)
+
+	// Подкоманды обслуживания
+	if args := flag.Args(); len(args) > 0 {
+		os.Exit(runCommand(conf, args, logger))
+	}
 
 	logger.Infow("starting mail2tg",
 		"config", *configPath,
-		"logLevel", conf.Config.Logging.Level,
+		"logLevel", conf.Current().Logging.Level,
 		"pid", os.Getpid(),
 		"version", Version,
 	)
@@ -54,7 +70,7 @@ func main() {
 
 	// Запуск HTTP-сервера для Prometheus в отдельной горутине
 	go func() {
-		servicePort := fmt.Sprintf(":%d", conf.Config.ServicePort)
+		servicePort := fmt.Sprintf(":%d", conf.Current().ServicePort)
 
 		// Регистрируем handler для /metrics
 		http.Handle("/metrics", promhttp.Handler())
@@ -68,7 +84,7 @@ func main() {
 	// Инициализация Telegram-бота
 	logger.Debug("initializing telegram bot")
 	pref := tb.Settings{
-		Token:  conf.Config.Telegram.Token,
+		Token:  conf.Current().Telegram.Token,
 		Poller: &tb.LongPoller{Timeout: 10 * time.Second},
 	}
 
@@ -79,11 +95,100 @@ func main() {
 	}
 	logger.Info("telegram bot initialized")
 
+	// Имена получателей и @username разрешаются, а доступность всех чатов проверяется через
+	// Telegram API при запуске и при каждой перезагрузке конфигурации
+	conf.Resolve = func(cfg *config.Config) error {
+		return cfg.ResolveDestinations(telegram.LookupChat)
+	}
+	if err := conf.Resolve(conf.Current()); err != nil {
+		logger.Errorw("cannot resolve telegram destinations", "error", err)
+		os.Exit(1)
+	}
+
+	// Хранилище недоставленных сообщений
+	dead, err := telegram.OpenDeadLetters(conf.Current().DeadLetterPath, logger)
+	if err != nil {
+		logger.Errorw("dead letter store initialization failed", "path", conf.Current().DeadLetterPath, "error", err)
+		os.Exit(1)
+	}
+	telegram.OnDeadLetter(func(dl telegram.DeadLetter) {
+		alerts.DeadLetter(conf, dl, logger)
+	})
+
+	// Письма, на которые можно ответить из Telegram; связываются с сообщениями
+	// при отправке, поэтому хранилище открывается до очереди
+	if err := reply.Open(conf.Current().RepliesPath, logger); err != nil {
+		logger.Errorw("replies store initialization failed", "path", conf.Current().RepliesPath, "error", err)
+		os.Exit(1)
+	}
+
+	// Первые сообщения переписок для отправки следующих писем ответом на них
+	if err := threads.Open(conf.Current().ThreadsPath, conf.Current().Telegram.Threads, logger); err != nil {
+		logger.Errorw("threads store initialization failed", "path", conf.Current().ThreadsPath, "error", err)
+		os.Exit(1)
+	}
+
+	// post_actions писем, сообщения о которых ещё в очереди: выполняются после их отправки
+	if err := postprocess.Open(conf.Current().PostActionsPath, conf, logger); err != nil {
+		logger.Errorw("post actions store initialization failed", "path", conf.Current().PostActionsPath, "error", err)
+		os.Exit(1)
+	}
+
+	// Очередь исходящих сообщений на диске
+	if err := telegram.InitOutbox(conf.Current().OutboxPath, dead, logger); err != nil {
+		logger.Errorw("telegram outbox initialization failed", "path", conf.Current().OutboxPath, "error", err)
+		os.Exit(1)
+	}
+	logger.Infow("telegram outbox opened", "path", conf.Current().OutboxPath)
+
+	// Хранилище состояния доставки писем
+	store, err := state.Open(conf.Current().StatePath)
+	if err != nil {
+		logger.Errorw("state store initialization failed", "path", conf.Current().StatePath, "error", err)
+		os.Exit(1)
+	}
+	logger.Infow("state store opened", "path", conf.Current().StatePath)
+	// Состояние прежних версий хранилось по username@host, теперь — по имени учётной записи
+	for _, acc := range conf.Current().GetAccounts() {
+		if err := store.Rename(email.LegacyAccountKey(acc), email.AccountKey(acc)); err != nil {
+			logger.Errorw("state migration failed", "account", acc.Name, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Токены обновления OAuth2 для входа на IMAP-серверы
+	if err := oauth.Open(conf.Current().OAuthTokensPath); err != nil {
+		logger.Errorw("oauth token store initialization failed", "path", conf.Current().OAuthTokensPath, "error", err)
+		os.Exit(1)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Отпечатки доставленных писем для подавления повторов
+	if err := dedup.Open(conf.Current().DedupPath); err != nil {
+		logger.Errorw("dedup store initialization failed", "path", conf.Current().DedupPath, "error", err)
+		os.Exit(1)
+	}
+
+	// Письма, ожидающие отправки сводкой
+	if err := digest.Open(conf.Current().DigestPath); err != nil {
+		logger.Errorw("digest store initialization failed", "path", conf.Current().DigestPath, "error", err)
+		os.Exit(1)
+	}
+	go digest.Start(ctx, logger)
+
+	// Ссылки на письма для кнопок действий
+	if err := actions.Open(conf.Current().ButtonsPath); err != nil {
+		logger.Errorw("buttons store initialization failed", "path", conf.Current().ButtonsPath, "error", err)
+		os.Exit(1)
+	}
+
+	// Команды операторов и кнопки действий в Telegram
+	bot.Start(conf, start, logger)
+
 	logger.Debug("starting scheduler")
-	go scheduler.Scheduler(ctx, conf, logger, start, *configPath)
+	go scheduler.Scheduler(ctx, conf, store, logger, start, *configPath)
 
 	// Ожидание сигнала остановки
 	stop := make(chan os.Signal, 1)
@@ -92,5 +197,6 @@ func main() {
 
 	logger.Info("shutting down gracefully...")
 	cancel()
+	bot.Stop()
 	time.Sleep(2 * time.Second)
 }
@@ -4,18 +4,64 @@ import (
 	"fmt"
 	"github.com/ilyakaznacheev/cleanenv"
 	"log"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
 )
 
 // Config хранит основную конфигурацию приложения
 type Config struct {
-	IMAP          IMAPConfig     `yaml:"imap"`
-	Telegram      TelegramConfig `yaml:"telegram"`
-	Route         []RouteConfig  `yaml:"route"`
-	Logging       LogConfig      `yaml:"log_settings"`
-	Alerting      AlertSettings  `yaml:"alert_settings"`
-	CheckInterval int            `yaml:"check_interval"`
-	SecretsPath   string         `yaml:"secrets"`
-	ServicePort   int            `yaml:"service_port" env-default:"9090"`
+	// IMAP и Route описывают единственную учётную запись, если список Accounts пуст
+	IMAP           IMAPConfig     `yaml:"imap"`
+	Mode           string         `yaml:"mode"`
+	Accounts       []Account      `yaml:"accounts"`
+	Telegram       TelegramConfig `yaml:"telegram"`
+	SMTP           SMTPConfig     `yaml:"smtp"`
+	Route          []RouteConfig  `yaml:"route"`
+	Logging        LogConfig      `yaml:"log_settings"`
+	Alerting       AlertSettings  `yaml:"alert_settings"`
+	CheckInterval  int            `yaml:"check_interval"`
+	SecretsPath    string         `yaml:"secrets"`
+	ServicePort    int            `yaml:"service_port" env-default:"9090"`
+	StatePath      string         `yaml:"state_path" env-default:"data/state.json"`
+	OutboxPath     string         `yaml:"outbox_path" env-default:"data/outbox"`
+	DeadLetterPath string         `yaml:"dead_letter_path" env-default:"data/dlq"`
+	// ButtonsPath — файл со ссылками на письма для кнопок действий
+	ButtonsPath string `yaml:"buttons_path" env-default:"data/buttons.json"`
+	// RepliesPath — файл со ссылками на письма, на которые можно ответить из Telegram
+	RepliesPath string `yaml:"replies_path" env-default:"data/replies.json"`
+	// ThreadsPath — файл с первыми сообщениями переписок для telegram.threads
+	ThreadsPath string `yaml:"threads_path" env-default:"data/threads.json"`
+	// Dedup — подавление повторяющихся писем для канала по умолчанию и правил без своих настроек
+	Dedup DedupConfig `yaml:"dedup"`
+	// DedupPath — файл с отпечатками недавно доставленных писем
+	DedupPath string `yaml:"dedup_path" env-default:"data/dedup.json"`
+	// DigestPath — файл с письмами, ожидающими отправки сводкой
+	DigestPath string `yaml:"digest_path" env-default:"data/digest.json"`
+	// PostActionsPath — файл с post_actions писем, ожидающими отправки сообщений о них
+	PostActionsPath string `yaml:"post_actions_path" env-default:"data/post_actions.json"`
+	// OAuthTokensPath — файл с токенами обновления OAuth2 учётных записей IMAP
+	OAuthTokensPath string `yaml:"oauth_tokens_path" env-default:"data/oauth.json"`
+	// Destinations — именованные получатели, на которые можно ссылаться вместо chat_id
+	Destinations map[string]Destination `yaml:"destinations"`
+	// MaxDeliveryAttempts — количество попыток доставки письма, после которых оно помечается как failed
+	MaxDeliveryAttempts int `yaml:"max_delivery_attempts" env-default:"5"`
+}
+
+// Режимы получения почты
+const (
+	ModePoll = "poll" // периодический опрос по check_interval
+	ModeIdle = "idle" // постоянное соединение по каждой папке и IMAP IDLE
+)
+
+// Account описывает почтовый ящик со своими папками, правилами и интервалом проверки
+type Account struct {
+	Name          string        `yaml:"name"`
+	Mode          string        `yaml:"mode"`
+	IMAP          IMAPConfig    `yaml:"imap"`
+	Route         []RouteConfig `yaml:"route"`
+	CheckInterval int           `yaml:"check_interval"`
 }
 
 type IMAPConfig struct {
@@ -23,12 +69,148 @@ type IMAPConfig struct {
 	Port     int    `yaml:"port"`
 	Username string `yaml:"username"`
 	Password string
+	// Auth — password (по умолчанию), xoauth2 или oauthbearer
+	Auth  string      `yaml:"auth"`
+	OAuth OAuthConfig `yaml:"oauth"`
+	// Security — tls (по умолчанию), starttls или none
+	Security string `yaml:"security"`
+	// CAFile — PEM-файл с корневыми сертификатами вместо системных
+	CAFile string `yaml:"ca_file"`
+	// ClientCert и ClientKey — PEM-файлы клиентского сертификата и ключа
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+	// ServerName — имя для проверки сертификата сервера; по умолчанию host
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	// DialTimeout и ReadTimeout — таймауты подключения и ответа на команду в секундах
+	DialTimeout int `yaml:"dial_timeout"`
+	ReadTimeout int `yaml:"read_timeout"`
+	// MaxConnections — постоянные соединения режима poll, по ним папки проверяются параллельно
+	MaxConnections int `yaml:"max_connections"`
+	// Keepalive — интервал NOOP для простаивающих соединений в секундах
+	Keepalive int `yaml:"keepalive"`
+	// MaxBackoff — наибольшая пауза между неудачными подключениями в секундах
+	MaxBackoff int `yaml:"max_backoff"`
 }
 
+// SMTPConfig описывает SMTP-сервер для ответов на письма из Telegram
+type SMTPConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port" env-default:"465"`
+	// Security — tls (неявный TLS, обычно порт 465) или starttls (обычно порт 587)
+	Security string `yaml:"security" env-default:"tls"`
+	Username string `yaml:"username"`
+	Password string
+	// From — адрес отправителя; по умолчанию username
+	From string `yaml:"from"`
+	// AllowedUsers — идентификаторы пользователей Telegram, которым разрешено отвечать на письма
+	AllowedUsers []int64 `yaml:"allowed_users"`
+}
+
+// Режимы защиты SMTP-соединения
+const (
+	SMTPSecurityTLS      = "tls"
+	SMTPSecurityStartTLS = "starttls"
+)
+
 type TelegramConfig struct {
 	Token          string `yaml:"token"`
 	DefaultChannel string `yaml:"default_channel"`
 	ErrorsChannel  string `yaml:"errors_channel"`
+	// AllowedUsers — идентификаторы пользователей Telegram, которым разрешены команды бота
+	AllowedUsers []int64 `yaml:"allowed_users"`
+	// Attachments — настройки вложений для канала по умолчанию и правил без своих настроек
+	Attachments AttachmentConfig `yaml:"attachments"`
+	// Template и ParseMode — оформление сообщений по умолчанию
+	Template  string `yaml:"template"`
+	ParseMode string `yaml:"parse_mode"`
+	// LongMessage и FullBody — обработка сообщений длиннее лимита Telegram по умолчанию
+	LongMessage string `yaml:"long_message"`
+	FullBody    string `yaml:"full_body"`
+	// Buttons — кнопки действий с письмом по умолчанию
+	Buttons ButtonsConfig `yaml:"buttons"`
+	// Threads — группировка писем одной переписки в ответы на первое сообщение
+	Threads ThreadsConfig `yaml:"threads"`
+}
+
+// ThreadsConfig управляет группировкой писем одной переписки
+type ThreadsConfig struct {
+	// Enabled — отправлять письма переписки ответом на первое сообщение о ней
+	Enabled bool `yaml:"enabled"`
+	// BySubject — считать одной перепиской и письма с одинаковой темой без префиксов Re:/Fwd:
+	BySubject bool `yaml:"by_subject"`
+	// RetentionHours — сколько часов после последнего письма переписка продолжается в том же сообщении
+	RetentionHours int `yaml:"retention_hours" env-default:"168"`
+}
+
+// Действия с исходным письмом, доступные кнопками под сообщением
+const (
+	ActionRead    = "read"    // отметить прочитанным
+	ActionFlag    = "flag"    // пометить флагом
+	ActionArchive = "archive" // переместить в папку archive_folder
+	ActionDelete  = "delete"  // удалить
+	ActionBody    = "body"    // показать полный текст письма
+)
+
+// ButtonsConfig управляет кнопками действий с исходным письмом
+type ButtonsConfig struct {
+	// Actions — кнопки в порядке отображения: read, flag, archive, delete, body
+	Actions []string `yaml:"actions"`
+	// ArchiveFolder — папка IMAP для кнопки archive
+	ArchiveFolder string `yaml:"archive_folder"`
+}
+
+// validate проверяет список действий
+func (b ButtonsConfig) validate() error {
+	for _, a := range b.Actions {
+		switch a {
+		case ActionRead, ActionFlag, ActionDelete, ActionBody:
+		case ActionArchive:
+			if b.ArchiveFolder == "" {
+				return fmt.Errorf("buttons: archive_folder is required for archive action")
+			}
+		default:
+			return fmt.Errorf("buttons: unknown action %q", a)
+		}
+	}
+	return nil
+}
+
+// Обработка сообщений длиннее лимита Telegram
+const (
+	LongMessageSplit    = "split"    // разбить на несколько сообщений
+	LongMessageTruncate = "truncate" // обрезать и приложить полный текст файлом
+)
+
+// Формат файла с полным текстом письма при обрезке сообщения
+const (
+	FullBodyTXT  = "txt"
+	FullBodyEML  = "eml"
+	FullBodyNone = "none"
+)
+
+// TemplateFuncs — функции, доступные в шаблонах сообщений
+var TemplateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// Режимы разметки сообщений Telegram
+const (
+	ParseModeNone       = ""
+	ParseModeHTML       = "html"
+	ParseModeMarkdownV2 = "markdownv2"
+)
+
+// AttachmentConfig управляет пересылкой вложений писем
+type AttachmentConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxSizeMB — максимальный размер одного вложения в мегабайтах (0 — лимит по умолчанию)
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// Allow и Deny — списки MIME-типов, допускаются маски вида "image/*"
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
 }
 
 type RouteConfig struct {
@@ -36,13 +218,140 @@ type RouteConfig struct {
 }
 
 type Folder struct {
-	Name  string `yaml:"name"`
-	Rules []Rule `yaml:"rules"`
+	// Name — имя папки или шаблон имён: * — любые символы в пределах уровня иерархии,
+	// ** — любые уровни, ? — один символ. Шаблон раскрывается по списку папок сервера (LIST).
+	Name string `yaml:"name"`
+	// Include — дополнительные шаблоны папок, которые проверяются с теми же правилами
+	Include []string `yaml:"include"`
+	// Exclude — шаблоны папок, которые не проверяются
+	Exclude []string `yaml:"exclude"`
+	// Pattern — имя папки из конфигурации, по шаблону которого найдена папка; пусто для обычных папок
+	Pattern string `yaml:"-"`
+	Rules   []Rule `yaml:"rules"`
+	// Template и ParseMode переопределяют оформление сообщений для папки
+	Template  string `yaml:"template"`
+	ParseMode string `yaml:"parse_mode"`
 }
 
 type Rule struct {
+	Name string `yaml:"name"`
+	// Pattern — регулярное выражение для темы письма (сокращение для match.subject)
 	Pattern string `yaml:"pattern"`
-	Channel string `yaml:"channel"`
+	// Match — условия по отправителю, получателям, заголовкам, телу и вложениям
+	Match   *Condition `yaml:"match"`
+	Channel string     `yaml:"channel"`
+	// Channels — дополнительные каналы, в которые доставляется письмо
+	Channels []string `yaml:"channels"`
+	// Targets — получатели с указанием темы форума (message_thread_id)
+	Targets []Destination `yaml:"destinations"`
+	// DisableNotification, ProtectContent и DisableLinkPreview — параметры отправки сообщений правила
+	DisableNotification bool `yaml:"disable_notification"`
+	ProtectContent      bool `yaml:"protect_content"`
+	DisableLinkPreview  bool `yaml:"disable_link_preview"`
+	// Continue — продолжить проверку следующих правил после совпадения (по умолчанию — остановиться)
+	Continue bool `yaml:"continue"`
+	// Attachments переопределяет telegram.attachments для этого правила
+	Attachments *AttachmentConfig `yaml:"attachments"`
+	// Template и ParseMode переопределяют оформление сообщений для правила
+	Template  string `yaml:"template"`
+	ParseMode string `yaml:"parse_mode"`
+	// LongMessage и FullBody переопределяют обработку длинных сообщений для правила
+	LongMessage string `yaml:"long_message"`
+	FullBody    string `yaml:"full_body"`
+	// Buttons переопределяет telegram.buttons для этого правила
+	Buttons *ButtonsConfig `yaml:"buttons"`
+	// Dedup переопределяет dedup для этого правила
+	Dedup *DedupConfig `yaml:"dedup"`
+	// Mode — instant (по умолчанию) или digest: письма копятся и отправляются сводкой по расписанию Digest
+	Mode   string        `yaml:"mode"`
+	Digest *DigestConfig `yaml:"digest"`
+	// Reply — разрешить ответ на письмо через SMTP ответом на сообщение в Telegram
+	Reply bool `yaml:"reply"`
+	// Schedule — окно времени, вне которого письма правила перенаправляются,
+	// отправляются без звука или откладываются
+	Schedule *RuleSchedule `yaml:"schedule"`
+	// PostActions — действия с письмом в почтовом ящике после его доставки
+	PostActions []PostAction `yaml:"post_actions"`
+}
+
+// Режимы отправки писем правила
+const (
+	RuleModeInstant = "instant" // каждое письмо отправляется сразу
+	RuleModeDigest  = "digest"  // письма отправляются сводкой по расписанию
+)
+
+// DigestConfig задаёт расписание сводки для правил с mode: digest.
+// Указывается одно из полей: Interval или Cron.
+type DigestConfig struct {
+	// Interval — отправлять сводку через N минут после первого письма в ней
+	Interval int `yaml:"interval"`
+	// Cron — отправлять сводку по расписанию cron в локальном времени сервера
+	Cron string `yaml:"cron"`
+}
+
+// validate проверяет, что задано ровно одно расписание и что сводка по нему будет отправлена
+func (d DigestConfig) validate() error {
+	if (d.Interval > 0) == (d.Cron != "") {
+		return fmt.Errorf("digest: exactly one of interval or cron is required")
+	}
+	if d.Interval < 0 {
+		return fmt.Errorf("digest: interval must be positive")
+	}
+	if d.Cron != "" {
+		s, err := ParseSchedule(d.Cron)
+		if err != nil {
+			return fmt.Errorf("digest: %w", err)
+		}
+		if s.Next(time.Now()).IsZero() {
+			return fmt.Errorf("digest: cron %q never fires", d.Cron)
+		}
+	}
+	return nil
+}
+
+// Condition описывает условие правила маршрутизации. Все заданные поля условия
+// должны совпасть одновременно (AND). Строковые поля — регулярные выражения RE2.
+type Condition struct {
+	// All — все вложенные условия должны совпасть (AND)
+	All []Condition `yaml:"all"`
+	// Any — хотя бы одно вложенное условие должно совпасть (OR)
+	Any []Condition `yaml:"any"`
+	// Not — вложенное условие не должно совпасть (NOT)
+	Not *Condition `yaml:"not"`
+
+	Subject string `yaml:"subject"`
+	From    string `yaml:"from"`
+	To      string `yaml:"to"`
+	Cc      string `yaml:"cc"`
+	// Recipients проверяет адреса из To и Cc
+	Recipients string `yaml:"recipients"`
+	ListID     string `yaml:"list_id"`
+	// Folder проверяет имя папки, в которой находится письмо (полезно для папок, заданных шаблоном)
+	Folder string `yaml:"folder"`
+	// Headers — произвольные заголовки: имя заголовка -> регулярное выражение
+	Headers        map[string]string `yaml:"headers"`
+	Body           string            `yaml:"body"`
+	HasAttachments *bool             `yaml:"has_attachments"`
+}
+
+// Patterns возвращает все регулярные выражения условия, включая вложенные
+func (c Condition) Patterns() []string {
+	var result []string
+	for _, p := range []string{c.Subject, c.Folder, c.From, c.To, c.Cc, c.Recipients, c.ListID, c.Body} {
+		if p != "" {
+			result = append(result, p)
+		}
+	}
+	for _, p := range c.Headers {
+		result = append(result, p)
+	}
+	for _, sub := range append(c.All, c.Any...) {
+		result = append(result, sub.Patterns()...)
+	}
+	if c.Not != nil {
+		result = append(result, c.Not.Patterns()...)
+	}
+	return result
 }
 
 // LogConfig для логирования
@@ -61,6 +370,216 @@ type AlertSettings struct {
 	AlertEmailDelay int `yaml:"alert_email_delay" env-default:"60"`
 }
 
+// DefaultAccountName — имя учётной записи, описанной блоками imap и route верхнего уровня
+const DefaultAccountName = "default"
+
+// GetAccounts возвращает список учётных записей. Если список accounts не задан,
+// возвращается одна учётная запись из блоков imap и route верхнего уровня.
+// Для учётных записей без check_interval используется общий интервал.
+func (c *Config) GetAccounts() []Account {
+	if len(c.Accounts) == 0 {
+		return []Account{{
+			Name:          DefaultAccountName,
+			Mode:          c.Mode,
+			IMAP:          c.IMAP,
+			Route:         c.Route,
+			CheckInterval: c.CheckInterval,
+		}}
+	}
+
+	accounts := make([]Account, len(c.Accounts))
+	for i, a := range c.Accounts {
+		if a.CheckInterval <= 0 {
+			a.CheckInterval = c.CheckInterval
+		}
+		accounts[i] = a
+	}
+	return accounts
+}
+
+// Validate проверяет корректность списка учётных записей, правил и шаблонов
+func (c *Config) Validate() error {
+	if err := validateFormat(c.Telegram.Template, c.Telegram.ParseMode); err != nil {
+		return fmt.Errorf("telegram: %w", err)
+	}
+	if err := validateLongMessage(c.Telegram.LongMessage, c.Telegram.FullBody); err != nil {
+		return fmt.Errorf("telegram: %w", err)
+	}
+	if err := c.Telegram.Buttons.validate(); err != nil {
+		return fmt.Errorf("telegram: %w", err)
+	}
+	if err := c.Dedup.validate(); err != nil {
+		return err
+	}
+	if c.Telegram.Threads.RetentionHours <= 0 {
+		return fmt.Errorf("telegram: threads: retention_hours must be positive")
+	}
+	if s := c.SMTP.Security; s != SMTPSecurityTLS && s != SMTPSecurityStartTLS {
+		return fmt.Errorf("smtp: unknown security %q", s)
+	}
+
+	names := make(map[string]bool)
+	for i, a := range c.Accounts {
+		if a.Name == "" {
+			return fmt.Errorf("account #%d: name is required", i+1)
+		}
+		if names[a.Name] {
+			return fmt.Errorf("account %q: duplicate name", a.Name)
+		}
+		names[a.Name] = true
+	}
+	if err := c.validateDestinations(); err != nil {
+		return err
+	}
+
+	for _, a := range c.GetAccounts() {
+		if a.CheckInterval <= 0 {
+			return fmt.Errorf("account %q: check_interval must be positive", a.Name)
+		}
+		if a.Mode != "" && a.Mode != ModePoll && a.Mode != ModeIdle {
+			return fmt.Errorf("account %q: unknown mode %q", a.Name, a.Mode)
+		}
+		if err := a.IMAP.validate(); err != nil {
+			return fmt.Errorf("account %q: imap: %w", a.Name, err)
+		}
+		for _, r := range a.Route {
+			for _, f := range r.Folders {
+				if err := validateFormat(f.Template, f.ParseMode); err != nil {
+					return fmt.Errorf("account %q, folder %q: %w", a.Name, f.Name, err)
+				}
+				for i, rule := range f.Rules {
+					if err := rule.validate(); err != nil {
+						return fmt.Errorf("account %q, folder %q, rule #%d: %w", a.Name, f.Name, i+1, err)
+					}
+					if rule.Reply && c.SMTP.Host == "" {
+						return fmt.Errorf("account %q, folder %q, rule #%d: reply requires smtp.host", a.Name, f.Name, i+1)
+					}
+					for _, ref := range rule.references() {
+						if err := c.checkDestination(ref); err != nil {
+							return fmt.Errorf("account %q, folder %q, rule #%d: %w", a.Name, f.Name, i+1, err)
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Destinations возвращает всех получателей правила без повторов
+func (r Rule) Destinations() []Destination {
+	all := []Destination{ParseDestination(r.Channel)}
+	for _, ch := range r.Channels {
+		all = append(all, ParseDestination(ch))
+	}
+	all = append(all, r.Targets...)
+
+	var result []Destination
+	seen := make(map[string]bool)
+	for _, d := range all {
+		if d.ChatID != "" && !seen[d.String()] {
+			seen[d.String()] = true
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// validate проверяет, что все регулярные выражения правила корректны
+func (r Rule) validate() error {
+	if len(r.Destinations()) == 0 {
+		return fmt.Errorf("channel is required")
+	}
+	for _, d := range r.Targets {
+		if d.ChatID == "" {
+			return fmt.Errorf("destination chat_id is required")
+		}
+		if d.ThreadID < 0 {
+			return fmt.Errorf("destination %s: message_thread_id must be positive", d.ChatID)
+		}
+	}
+	if err := validateFormat(r.Template, r.ParseMode); err != nil {
+		return err
+	}
+	if err := validateLongMessage(r.LongMessage, r.FullBody); err != nil {
+		return err
+	}
+	if r.Buttons != nil {
+		if err := r.Buttons.validate(); err != nil {
+			return err
+		}
+	}
+	if r.Dedup != nil {
+		if err := r.Dedup.validate(); err != nil {
+			return err
+		}
+	}
+	switch r.Mode {
+	case "", RuleModeInstant:
+	case RuleModeDigest:
+		if r.Digest == nil {
+			return fmt.Errorf("digest schedule is required for mode digest")
+		}
+		if err := r.Digest.validate(); err != nil {
+			return err
+		}
+		if r.Schedule != nil {
+			return fmt.Errorf("schedule is not supported for mode digest")
+		}
+	default:
+		return fmt.Errorf("unknown mode %q", r.Mode)
+	}
+	if r.Schedule != nil {
+		if err := r.Schedule.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validatePostActions(r.PostActions); err != nil {
+		return err
+	}
+
+	patterns := []string{r.Pattern}
+	if r.Match != nil {
+		patterns = append(patterns, r.Match.Patterns()...)
+	}
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// validateFormat проверяет синтаксис шаблона и режим разметки
+func validateFormat(tmpl, parseMode string) error {
+	switch strings.ToLower(parseMode) {
+	case ParseModeNone, ParseModeHTML, ParseModeMarkdownV2:
+	default:
+		return fmt.Errorf("unknown parse_mode %q", parseMode)
+	}
+	if tmpl != "" {
+		if _, err := template.New("message").Funcs(TemplateFuncs).Parse(tmpl); err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateLongMessage проверяет настройки обработки длинных сообщений
+func validateLongMessage(mode, fullBody string) error {
+	switch mode {
+	case "", LongMessageSplit, LongMessageTruncate:
+	default:
+		return fmt.Errorf("unknown long_message %q", mode)
+	}
+	switch fullBody {
+	case "", FullBodyTXT, FullBodyEML, FullBodyNone:
+	default:
+		return fmt.Errorf("unknown full_body %q", fullBody)
+	}
+	return nil
+}
+
 // GetConfig загружает конфигурацию из файла, возвращает указатель и ошибку
 func GetConfig(configPath string) (*Config, error) {
 
@@ -96,13 +615,23 @@ func (c *Config) LoadSecrets() error {
 		return nil
 	}
 
+	// imapSecrets — пароль или учётные данные OAuth2 учётной записи
+	type imapSecrets struct {
+		Password     string `yaml:"password"`
+		ClientID     string `yaml:"client_id"`
+		ClientSecret string `yaml:"client_secret"`
+		RefreshToken string `yaml:"refresh_token"`
+	}
 	type Secrets struct {
-		IMAP struct {
-			Password string `yaml:"password"`
-		} `yaml:"imap"`
+		IMAP imapSecrets `yaml:"imap"`
+		// Accounts — секреты учётных записей по их имени
+		Accounts map[string]imapSecrets `yaml:"accounts"`
 		Telegram struct {
 			Token string `yaml:"token"`
 		} `yaml:"telegram"`
+		SMTP struct {
+			Password string `yaml:"password"`
+		} `yaml:"smtp"`
 	}
 
 	var sec Secrets
@@ -110,8 +639,18 @@ func (c *Config) LoadSecrets() error {
 		return err
 	}
 
-	c.IMAP.Password = sec.IMAP.Password
+	apply := func(imap *IMAPConfig, s imapSecrets) {
+		imap.Password = s.Password
+		imap.OAuth.ClientID, imap.OAuth.ClientSecret, imap.OAuth.RefreshToken = s.ClientID, s.ClientSecret, s.RefreshToken
+	}
+	apply(&c.IMAP, sec.IMAP)
+	for i := range c.Accounts {
+		if a, ok := sec.Accounts[c.Accounts[i].Name]; ok {
+			apply(&c.Accounts[i].IMAP, a)
+		}
+	}
 	c.Telegram.Token = sec.Telegram.Token
+	c.SMTP.Password = sec.SMTP.Password
 
 	return nil
 }
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+		wantErr  bool
+	}{
+		{field: "*", min: 0, max: 6, want: []int{0, 1, 2, 3, 4, 5, 6}},
+		{field: "5", min: 0, max: 59, want: []int{5}},
+		{field: "*/15", min: 0, max: 59, want: []int{0, 15, 30, 45}},
+		{field: "1-5", min: 0, max: 7, want: []int{1, 2, 3, 4, 5}},
+		{field: "10-20/5", min: 0, max: 59, want: []int{10, 15, 20}},
+		{field: "50/5", min: 0, max: 59, want: []int{50, 55}},
+		{field: "1,3,5", min: 1, max: 31, want: []int{1, 3, 5}},
+		{field: "9,18", min: 0, max: 23, want: []int{9, 18}},
+		{field: "1-3,20-22/2,31", min: 1, max: 31, want: []int{1, 2, 3, 20, 22, 31}},
+		{field: "60", min: 0, max: 59, wantErr: true},
+		{field: "0", min: 1, max: 31, wantErr: true},
+		{field: "5-1", min: 0, max: 59, wantErr: true},
+		{field: "*/0", min: 0, max: 59, wantErr: true},
+		{field: "*/x", min: 0, max: 59, wantErr: true},
+		{field: "1-", min: 0, max: 59, wantErr: true},
+		{field: "mon", min: 0, max: 7, wantErr: true},
+		{field: "", min: 0, max: 59, wantErr: true},
+		{field: "1,,2", min: 0, max: 59, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			bits, err := parseCronField(tt.field, tt.min, tt.max)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCronField(%q) error = %v, wantErr %v", tt.field, err, tt.wantErr)
+			}
+			var want uint64
+			for _, v := range tt.want {
+				want |= 1 << v
+			}
+			if bits != want {
+				t.Errorf("parseCronField(%q) = %b, want %b", tt.field, bits, want)
+			}
+		})
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "0 9,18 * * 1-5"},
+		{spec: "@daily"},
+		{spec: " @hourly "},
+		{spec: "0 0 30 2 *"},
+		{spec: "0 9 * *", wantErr: true},
+		{spec: "0 9 * * * *", wantErr: true},
+		{spec: "61 * * * *", wantErr: true},
+		{spec: "0 24 * * *", wantErr: true},
+		{spec: "0 0 * 13 *", wantErr: true},
+		{spec: "0 0 * * 8", wantErr: true},
+		{spec: "@yearly", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			if _, err := ParseSchedule(tt.spec); (err != nil) != tt.wantErr {
+				t.Errorf("ParseSchedule(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	berlin := loadLocation(t, "Europe/Berlin")
+	moscow := loadLocation(t, "Europe/Moscow")
+	utc := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{name: "strictly after", spec: "* * * * *", from: utc(1, 1, 10, 0), want: utc(1, 1, 10, 1)},
+		{name: "seconds ignored", spec: "* * * * *", from: utc(1, 1, 10, 0).Add(59 * time.Second), want: utc(1, 1, 10, 1)},
+		{name: "step", spec: "*/15 * * * *", from: utc(1, 1, 10, 7), want: utc(1, 1, 10, 15)},
+		{name: "step wraps hour", spec: "*/15 * * * *", from: utc(1, 1, 10, 45), want: utc(1, 1, 11, 0)},
+		{name: "range of hours", spec: "0 9-11 * * *", from: utc(1, 1, 11, 0), want: utc(1, 2, 9, 0)},
+		{name: "list of hours", spec: "0 9,18 * * *", from: utc(1, 1, 9, 0), want: utc(1, 1, 18, 0)},
+		{name: "workdays evening", spec: "0 9,18 * * 1-5", from: utc(10, 16, 12, 0), want: utc(10, 16, 18, 0)},
+		{name: "workdays skip weekend", spec: "0 9,18 * * 1-5", from: utc(10, 16, 18, 0), want: utc(10, 19, 9, 0)},
+		{name: "sunday as 7", spec: "0 0 * * 7", from: utc(10, 16, 0, 0), want: utc(10, 18, 0, 0)},
+		{name: "day of month only", spec: "0 0 13 * *", from: utc(10, 16, 0, 0), want: utc(11, 13, 0, 0)},
+		// Ограничены и день месяца, и день недели: подходит любой из них
+		{name: "dom or dow: friday first", spec: "0 0 13 * 5", from: utc(10, 14, 0, 0), want: utc(10, 16, 0, 0)},
+		{name: "dom or dow: 13th first", spec: "0 0 13 * 1", from: utc(11, 10, 0, 0), want: utc(11, 13, 0, 0)},
+		{name: "dow with dom wildcard", spec: "0 0 * * 5", from: utc(11, 10, 0, 0), want: utc(11, 13, 0, 0)},
+		{name: "month and year wrap", spec: "0 0 1 1 *", from: utc(10, 16, 0, 0), want: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "31st skips short months", spec: "0 0 31 * *", from: utc(3, 31, 0, 0), want: utc(5, 31, 0, 0)},
+		{name: "leap day", spec: "0 0 29 2 *", from: utc(1, 1, 0, 0), want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "feb 30 never fires", spec: "0 0 30 2 *", from: utc(1, 1, 0, 0), want: time.Time{}},
+		{name: "weekly alias", spec: "@weekly", from: utc(10, 16, 0, 0), want: utc(10, 18, 0, 0)},
+		{name: "monthly alias", spec: "@monthly", from: utc(10, 16, 0, 0), want: utc(11, 1, 0, 0)},
+		{name: "local time", spec: "0 9 * * *", from: time.Date(2026, 3, 29, 10, 0, 0, 0, moscow), want: time.Date(2026, 3, 30, 9, 0, 0, 0, moscow)},
+		// 29.03.2026 в Берлине часы переводятся с 02:00 на 03:00
+		{name: "spring gap moved to end of gap", spec: "30 2 * * *", from: time.Date(2026, 3, 28, 3, 0, 0, 0, berlin), want: time.Date(2026, 3, 29, 3, 0, 0, 0, berlin)},
+		{name: "after spring gap", spec: "30 2 * * *", from: time.Date(2026, 3, 29, 3, 0, 0, 0, berlin), want: time.Date(2026, 3, 30, 2, 30, 0, 0, berlin)},
+		{name: "hourly across spring gap", spec: "0 * * * *", from: time.Date(2026, 3, 29, 1, 30, 0, 0, berlin), want: time.Date(2026, 3, 29, 3, 0, 0, 0, berlin)},
+		{name: "minutes after spring gap", spec: "15 * * * *", from: time.Date(2026, 3, 29, 1, 30, 0, 0, berlin), want: time.Date(2026, 3, 29, 3, 0, 0, 0, berlin)},
+		// 25.10.2026 в Берлине час с 02:00 до 03:00 повторяется
+		{name: "autumn repeated hour", spec: "30 2 * * *", from: time.Date(2026, 10, 25, 1, 0, 0, 0, berlin), want: time.Date(2026, 10, 25, 1, 30, 0, 0, time.UTC)},
+		// Первое 02:30 (летнее время) уже прошло: второе 02:30 (зимнее) пропускается
+		{name: "autumn fires once", spec: "30 2 * * *", from: time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC).In(berlin), want: time.Date(2026, 10, 26, 2, 30, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q): %v", tt.spec, err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextMonotonic(t *testing.T) {
+	berlin := loadLocation(t, "Europe/Berlin")
+	specs := []string{"* * * * *", "*/15 * * * *", "30 2 * * *", "0 * * * *", "0 9,18 * * 1-5"}
+	for _, spec := range specs {
+		t.Run(spec, func(t *testing.T) {
+			s, _ := ParseSchedule(spec)
+			// Срабатывания в дни перехода идут строго по возрастанию и без повторов показаний часов
+			for _, start := range []time.Time{
+				time.Date(2026, 3, 28, 0, 0, 0, 0, berlin),
+				time.Date(2026, 10, 24, 0, 0, 0, 0, berlin),
+			} {
+				seen := make(map[string]bool)
+				for at := start; at.Before(start.AddDate(0, 0, 3)); {
+					next := s.Next(at)
+					if !next.After(at) {
+						t.Fatalf("Next(%v) = %v is not after it", at, next)
+					}
+					wall := next.Format("2006-01-02 15:04")
+					if seen[wall] {
+						t.Fatalf("Next fired twice at %s", wall)
+					}
+					seen[wall] = true
+					at = next
+				}
+			}
+		})
+	}
+}
+
+func TestDigestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     DigestConfig
+		wantErr bool
+	}{
+		{name: "interval", cfg: DigestConfig{Interval: 60}},
+		{name: "cron", cfg: DigestConfig{Cron: "0 9,18 * * 1-5"}},
+		{name: "none", cfg: DigestConfig{}, wantErr: true},
+		{name: "both", cfg: DigestConfig{Interval: 60, Cron: "@daily"}, wantErr: true},
+		{name: "invalid cron", cfg: DigestConfig{Cron: "0 25 * * *"}, wantErr: true},
+		{name: "feb 30", cfg: DigestConfig{Cron: "0 0 30 2 *"}, wantErr: true},
+		{name: "feb 29", cfg: DigestConfig{Cron: "0 0 29 2 *"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s is not available: %v", name, err)
+	}
+	return loc
+}
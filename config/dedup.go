@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Поля письма, из которых строится отпечаток для поиска повторов
+const (
+	FingerprintMessageID = "message_id"
+	FingerprintSubject   = "subject"
+	FingerprintFrom      = "from"
+	FingerprintBody      = "body"
+)
+
+// MaxDedupWindow — наибольшее окно подавления повторов в секундах (30 дней)
+const MaxDedupWindow = 30 * 24 * 60 * 60
+
+// DedupConfig управляет подавлением одинаковых писем, пришедших в течение окна
+type DedupConfig struct {
+	// Window — окно подавления повторов в секундах; 0 — повторы не подавляются
+	Window int `yaml:"window"`
+	// Fingerprint — поля, по которым письма считаются одинаковыми: message_id или
+	// набор из subject, from и body. По умолчанию message_id.
+	Fingerprint []string `yaml:"fingerprint"`
+	// Normalize — регулярные выражения, совпадения с которыми удаляются из темы,
+	// отправителя и текста перед сравнением (время, идентификаторы и т.п.)
+	Normalize []string `yaml:"normalize"`
+}
+
+// validate проверяет окно, поля отпечатка и регулярные выражения
+func (d DedupConfig) validate() error {
+	if d.Window < 0 || d.Window > MaxDedupWindow {
+		return fmt.Errorf("dedup: window must be between 0 and %d seconds", MaxDedupWindow)
+	}
+	for _, f := range d.Fingerprint {
+		switch f {
+		case FingerprintMessageID, FingerprintSubject, FingerprintFrom, FingerprintBody:
+		default:
+			return fmt.Errorf("dedup: unknown fingerprint field %q", f)
+		}
+	}
+	for _, p := range d.Normalize {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("dedup: invalid normalize pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
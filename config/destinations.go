@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Destination — чат Telegram и, для супергрупп с темами, тема форума
+type Destination struct {
+	// ChatID — числовой идентификатор чата или @username публичного канала
+	ChatID string `yaml:"chat_id"`
+	// ThreadID — идентификатор темы (message_thread_id); 0 — основной чат
+	ThreadID int `yaml:"message_thread_id"`
+}
+
+// String возвращает идентификатор получателя: chat_id или chat_id/message_thread_id
+func (d Destination) String() string {
+	if d.ThreadID == 0 {
+		return d.ChatID
+	}
+	return fmt.Sprintf("%s/%d", d.ChatID, d.ThreadID)
+}
+
+// ParseDestination разбирает идентификатор получателя вида chat_id или chat_id/message_thread_id
+func ParseDestination(s string) Destination {
+	if i := strings.LastIndex(s, "/"); i > 0 {
+		if thread, err := strconv.Atoi(s[i+1:]); err == nil {
+			return Destination{ChatID: s[:i], ThreadID: thread}
+		}
+	}
+	return Destination{ChatID: s}
+}
+
+// ResolveDestinations заменяет имена из destinations и @username на числовые chat_id
+// во всех каналах конфигурации и проверяет, что каждый чат доступен боту. lookup возвращает
+// chat_id по числовому идентификатору или @username (getChat) и вызывается один раз для каждого чата.
+func (c *Config) ResolveDestinations(lookup func(chat string) (int64, error)) error {
+	ids := make(map[string]string)
+	resolve := func(ref string) (Destination, error) {
+		d, named := c.Destinations[ref]
+		if !named {
+			d = ParseDestination(ref)
+		}
+		id, ok := ids[d.ChatID]
+		if !ok {
+			n, err := lookup(d.ChatID)
+			if err != nil {
+				if named {
+					return d, fmt.Errorf("destination %q: cannot resolve %s: %w", ref, d.ChatID, err)
+				}
+				return d, fmt.Errorf("cannot resolve %s: %w", d.ChatID, err)
+			}
+			id = strconv.FormatInt(n, 10)
+			ids[d.ChatID] = id
+		}
+		d.ChatID = id
+		return d, nil
+	}
+	resolveRef := func(ref *string) error {
+		if *ref == "" {
+			return nil
+		}
+		d, err := resolve(*ref)
+		if err != nil {
+			return err
+		}
+		*ref = d.String()
+		return nil
+	}
+
+	// Именованные получатели проверяются, даже если ни одно правило их не использует
+	names := make([]string, 0, len(c.Destinations))
+	for name := range c.Destinations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := resolve(name); err != nil {
+			return err
+		}
+	}
+
+	if err := resolveRef(&c.Telegram.DefaultChannel); err != nil {
+		return fmt.Errorf("telegram.default_channel: %w", err)
+	}
+	if err := resolveRef(&c.Telegram.ErrorsChannel); err != nil {
+		return fmt.Errorf("telegram.errors_channel: %w", err)
+	}
+
+	resolveRule := func(r *Rule) error {
+		if err := resolveRef(&r.Channel); err != nil {
+			return err
+		}
+		for i := range r.Channels {
+			if err := resolveRef(&r.Channels[i]); err != nil {
+				return err
+			}
+		}
+		for i, t := range r.Targets {
+			d, err := resolve(t.ChatID)
+			if err != nil {
+				return err
+			}
+			if t.ThreadID != 0 {
+				d.ThreadID = t.ThreadID
+			}
+			r.Targets[i] = d
+		}
+		if r.Schedule != nil {
+			if err := resolveRef(&r.Schedule.Redirect); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return c.eachRule(func(r *Rule) error {
+		if err := resolveRule(r); err != nil {
+			if r.Name != "" {
+				return fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// validateDestinations проверяет именованных получателей и каналы блока telegram
+func (c *Config) validateDestinations() error {
+	for name, d := range c.Destinations {
+		if err := checkChatID(d.ChatID); err != nil {
+			return fmt.Errorf("destination %q: %w", name, err)
+		}
+	}
+	if err := c.checkDestination(c.Telegram.DefaultChannel); err != nil {
+		return fmt.Errorf("telegram.default_channel: %w", err)
+	}
+	if err := c.checkDestination(c.Telegram.ErrorsChannel); err != nil {
+		return fmt.Errorf("telegram.errors_channel: %w", err)
+	}
+	return nil
+}
+
+// checkDestination проверяет, что канал — числовой chat_id, @username или имя из destinations
+func (c *Config) checkDestination(ref string) error {
+	if _, ok := c.Destinations[ref]; ok || ref == "" {
+		return nil
+	}
+	if checkChatID(ParseDestination(ref).ChatID) != nil {
+		return fmt.Errorf("unknown destination %q", ref)
+	}
+	return nil
+}
+
+// references возвращает все каналы правила в том виде, в котором они указаны в конфигурации
+func (r Rule) references() []string {
+	refs := append([]string{r.Channel}, r.Channels...)
+	for _, t := range r.Targets {
+		refs = append(refs, t.ChatID)
+	}
+	if r.Schedule != nil {
+		refs = append(refs, r.Schedule.Redirect)
+	}
+	return refs
+}
+
+// checkChatID проверяет, что chat_id — число или @username
+func checkChatID(id string) error {
+	if strings.HasPrefix(id, "@") && len(id) > 1 {
+		return nil
+	}
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return fmt.Errorf("invalid chat_id %q", id)
+	}
+	return nil
+}
+
+// eachRule вызывает f для каждого правила всех учётных записей
+func (c *Config) eachRule(f func(r *Rule) error) error {
+	routes := [][]RouteConfig{c.Route}
+	for _, a := range c.Accounts {
+		routes = append(routes, a.Route)
+	}
+
+	for _, route := range routes {
+		for i := range route {
+			for j := range route[i].Folders {
+				rules := route[i].Folders[j].Rules
+				for k := range rules {
+					if err := f(&rules[k]); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestResolveDestinations(t *testing.T) {
+	chats := map[string]int64{
+		"-100111":  -100111,
+		"-100222":  -100222,
+		"-100333":  -100333,
+		"@news":    -100444,
+		"12345":    12345,
+		"-1009999": -1009999,
+	}
+	newConfig := func() *Config {
+		return &Config{
+			Telegram: TelegramConfig{DefaultChannel: "ops", ErrorsChannel: "-100222"},
+			Destinations: map[string]Destination{
+				"ops":    {ChatID: "-100111"},
+				"topic":  {ChatID: "@news", ThreadID: 7},
+				"unused": {ChatID: "12345"},
+			},
+			Route: []RouteConfig{{Folders: []Folder{{Name: "INBOX", Rules: []Rule{{
+				Name:     "alerts",
+				Channel:  "topic",
+				Channels: []string{"-100333/5", "@news"},
+				Targets:  []Destination{{ChatID: "ops", ThreadID: 3}},
+				Schedule: &RuleSchedule{Redirect: "-1009999"},
+			}}}}}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		missing string // chat_id, который getChat не находит
+		wantErr []string
+	}{
+		{name: "all chats reachable"},
+		{name: "numeric chat of alias", missing: "-100111", wantErr: []string{`destination "ops"`, "-100111"}},
+		{name: "unused alias", missing: "12345", wantErr: []string{`destination "unused"`}},
+		{name: "numeric errors channel", missing: "-100222", wantErr: []string{"telegram.errors_channel", "-100222"}},
+		{name: "numeric rule channel", missing: "-100333", wantErr: []string{`rule "alerts"`, "-100333"}},
+		{name: "redirect", missing: "-1009999", wantErr: []string{`rule "alerts"`, "-1009999"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig()
+			calls := make(map[string]int)
+			err := cfg.ResolveDestinations(func(chat string) (int64, error) {
+				calls[chat]++
+				if chat == tt.missing {
+					return 0, errors.New("telegram: Bad Request: chat not found (400)")
+				}
+				id, ok := chats[chat]
+				if !ok {
+					t.Fatalf("unexpected lookup of %q", chat)
+				}
+				return id, nil
+			})
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatal("ResolveDestinations succeeded, want error")
+				}
+				for _, s := range tt.wantErr {
+					if !strings.Contains(err.Error(), s) {
+						t.Errorf("error %q does not mention %q", err, s)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveDestinations: %v", err)
+			}
+
+			for chat := range chats {
+				if calls[chat] != 1 {
+					t.Errorf("chat %s looked up %d times, want once", chat, calls[chat])
+				}
+			}
+			r := cfg.Route[0].Folders[0].Rules[0]
+			got := []string{cfg.Telegram.DefaultChannel, cfg.Telegram.ErrorsChannel, r.Channel, r.Channels[0], r.Channels[1], r.Targets[0].String(), r.Schedule.Redirect}
+			want := []string{"-100111", "-100222", "-100444/7", "-100333/5", "-100444", "-100111/3", "-1009999"}
+			if strings.Join(got, " ") != strings.Join(want, " ") {
+				t.Errorf("resolved = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseDestination(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Destination
+	}{
+		{in: "-100123", want: Destination{ChatID: "-100123"}},
+		{in: "-100123/42", want: Destination{ChatID: "-100123", ThreadID: 42}},
+		{in: "@news/7", want: Destination{ChatID: "@news", ThreadID: 7}},
+		{in: "ops", want: Destination{ChatID: "ops"}},
+		{in: "a/b", want: Destination{ChatID: "a/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got := ParseDestination(tt.in)
+			if got != tt.want {
+				t.Errorf("ParseDestination(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+			if got.String() != tt.in {
+				t.Errorf("String() = %q, want %q", got.String(), tt.in)
+			}
+		})
+	}
+}
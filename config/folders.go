@@ -0,0 +1,66 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+)
+
+// IsPattern сообщает, задаёт ли папка шаблон имён, который раскрывается по списку папок сервера
+func (f Folder) IsPattern() bool {
+	return len(f.Include) > 0 || strings.ContainsAny(f.Name, "*?")
+}
+
+// Matches проверяет, подходит ли папка name под шаблоны name и include и не исключена
+// шаблонами exclude. delim — разделитель уровней иерархии папок на сервере.
+func (f Folder) Matches(name, delim string) bool {
+	included := false
+	for _, p := range append([]string{f.Name}, f.Include...) {
+		if p != "" && globMatch(p, name, delim) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, p := range f.Exclude {
+		if globMatch(p, name, delim) {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatch сопоставляет имя папки с шаблоном: * — любые символы в пределах одного
+// уровня иерархии, ** — любые символы, включая разделитель, ? — один символ.
+// Шаблон INBOX совпадает с папкой INBOX в любом регистре, как требует IMAP.
+func globMatch(pattern, name, delim string) bool {
+	if strings.EqualFold(pattern, "INBOX") {
+		return strings.EqualFold(name, "INBOX")
+	}
+
+	notDelim := "."
+	if delim != "" {
+		notDelim = "[^" + regexp.QuoteMeta(delim) + "]"
+	}
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			re.WriteString(notDelim + "*")
+		case pattern[i] == '?':
+			re.WriteString(notDelim)
+		default:
+			// Байты многобайтового символа экранируются по отдельности, что не меняет их смысл
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+
+	matched, err := regexp.MatchString(re.String(), name)
+	return err == nil && matched
+}
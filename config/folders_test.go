@@ -0,0 +1,67 @@
+package config
+
+import "testing"
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, name, delim string
+		want                 bool
+	}{
+		{pattern: "Alerts/*", name: "Alerts/db", delim: "/", want: true},
+		{pattern: "Alerts/*", name: "Alerts/db/replica", delim: "/"},
+		{pattern: "Alerts/*", name: "Alerts", delim: "/"},
+		{pattern: "Alerts/**", name: "Alerts/db/replica", delim: "/", want: true},
+		{pattern: "**", name: "Archive/2026/10", delim: "/", want: true},
+		{pattern: "*", name: "Archive/2026", delim: "/"},
+		{pattern: "Alerts.*", name: "Alerts.db", delim: ".", want: true},
+		{pattern: "Alerts.*", name: "Alerts.db.replica", delim: "."},
+		{pattern: "Alerts.**", name: "Alerts.db.replica", delim: ".", want: true},
+		{pattern: "Alerts/*", name: "Alerts/db.replica", delim: "/", want: true},
+		{pattern: "Archive/202?", name: "Archive/2026", delim: "/", want: true},
+		{pattern: "Archive/202?", name: "Archive/20261", delim: "/"},
+		{pattern: "Archive?2026", name: "Archive/2026", delim: "/"},
+		{pattern: "Отчёты/*", name: "Отчёты/Январь", delim: "/", want: true},
+		{pattern: "Отч?ты", name: "Отчёты", delim: "/", want: true},
+		{pattern: "Alerts", name: "alerts", delim: "/"},
+		{pattern: "inbox", name: "INBOX", delim: "/", want: true},
+		{pattern: "INBOX", name: "Inbox", delim: "/", want: true},
+		{pattern: "INBOX", name: "INBOX/Sub", delim: "/"},
+		{pattern: "INBOX/*", name: "INBOX/Sub", delim: "/", want: true},
+		// Метасимволы регулярных выражений в шаблоне совпадают только сами с собой
+		{pattern: "Jobs (prod)+", name: "Jobs (prod)+", delim: "/", want: true},
+		{pattern: "Jobs (prod)+", name: "Jobs prodd", delim: "/"},
+		{pattern: "a.b", name: "axb", delim: "/"},
+		// Без разделителя * совпадает с любыми символами
+		{pattern: "Alerts*", name: "Alerts/db", want: true},
+		{pattern: "Alerts?db", name: "Alerts/db", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			if got := globMatch(tt.pattern, tt.name, tt.delim); got != tt.want {
+				t.Errorf("globMatch(%q, %q, %q) = %v, want %v", tt.pattern, tt.name, tt.delim, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFolderMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		folder Folder
+		match  string
+		want   bool
+	}{
+		{name: "name pattern", folder: Folder{Name: "Alerts/*"}, match: "Alerts/db", want: true},
+		{name: "include", folder: Folder{Include: []string{"Alerts/*", "Jobs/*"}}, match: "Jobs/nightly", want: true},
+		{name: "not included", folder: Folder{Include: []string{"Alerts/*"}}, match: "Jobs/nightly"},
+		{name: "excluded", folder: Folder{Name: "Alerts/**", Exclude: []string{"Alerts/test/**"}}, match: "Alerts/test/db"},
+		{name: "not excluded", folder: Folder{Name: "Alerts/**", Exclude: []string{"Alerts/test/**"}}, match: "Alerts/prod/db", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.folder.Matches(tt.match, "/"); got != tt.want {
+				t.Errorf("Matches(%q) = %v, want %v", tt.match, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Режимы защиты соединения с IMAP-сервером
+const (
+	IMAPSecurityTLS      = "tls"      // неявный TLS, обычно порт 993
+	IMAPSecurityStartTLS = "starttls" // STARTTLS поверх открытого соединения, обычно порт 143
+	IMAPSecurityNone     = "none"     // без шифрования, только для доверенных сетей
+)
+
+// Значения по умолчанию для подключения к IMAP-серверу
+const (
+	DefaultIMAPDialTimeout = 10 * time.Second // dial_timeout
+	DefaultIMAPKeepalive   = time.Minute      // keepalive
+	DefaultIMAPMaxBackoff  = 5 * time.Minute  // max_backoff
+	// DefaultIMAPIdleConnections — max_connections в режиме idle
+	DefaultIMAPIdleConnections = 5
+)
+
+// SecurityMode возвращает режим защиты соединения; по умолчанию — tls
+func (c IMAPConfig) SecurityMode() string {
+	if c.Security == "" {
+		return IMAPSecurityTLS
+	}
+	return c.Security
+}
+
+// DialTimeoutDuration возвращает время на установку соединения, включая TLS и приветствие сервера
+func (c IMAPConfig) DialTimeoutDuration() time.Duration {
+	if c.DialTimeout <= 0 {
+		return DefaultIMAPDialTimeout
+	}
+	return time.Duration(c.DialTimeout) * time.Second
+}
+
+// ReadTimeoutDuration возвращает время ожидания ответа на команду; 0 — без ограничения
+func (c IMAPConfig) ReadTimeoutDuration() time.Duration {
+	return time.Duration(c.ReadTimeout) * time.Second
+}
+
+// PoolSize возвращает наибольшее число постоянных соединений; по умолчанию одно
+func (c IMAPConfig) PoolSize() int {
+	return max(c.MaxConnections, 1)
+}
+
+// IdleConnections возвращает наибольшее число постоянных соединений в режиме idle;
+// по умолчанию DefaultIMAPIdleConnections
+func (c IMAPConfig) IdleConnections() int {
+	if c.MaxConnections <= 0 {
+		return DefaultIMAPIdleConnections
+	}
+	return c.MaxConnections
+}
+
+// KeepaliveInterval возвращает интервал проверки простаивающих соединений командой NOOP
+func (c IMAPConfig) KeepaliveInterval() time.Duration {
+	if c.Keepalive <= 0 {
+		return DefaultIMAPKeepalive
+	}
+	return time.Duration(c.Keepalive) * time.Second
+}
+
+// MaxBackoffDuration возвращает наибольшую паузу между неудачными подключениями
+func (c IMAPConfig) MaxBackoffDuration() time.Duration {
+	if c.MaxBackoff <= 0 {
+		return DefaultIMAPMaxBackoff
+	}
+	return time.Duration(c.MaxBackoff) * time.Second
+}
+
+// TLSConfig собирает настройки TLS соединения: корневые сертификаты из ca_file,
+// клиентский сертификат, имя сервера для проверки сертификата.
+func (c IMAPConfig) TLSConfig() (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if tc.ServerName == "" {
+		tc.ServerName = c.Host
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file %s contains no PEM certificates", c.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	if c.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// validate проверяет способ входа, режим защиты, таймауты и файлы сертификатов
+func (c IMAPConfig) validate() error {
+	if err := c.validateAuth(); err != nil {
+		return err
+	}
+	if c.DialTimeout < 0 || c.ReadTimeout < 0 {
+		return fmt.Errorf("dial_timeout and read_timeout must not be negative")
+	}
+	if c.MaxConnections < 0 || c.Keepalive < 0 || c.MaxBackoff < 0 {
+		return fmt.Errorf("max_connections, keepalive and max_backoff must not be negative")
+	}
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return fmt.Errorf("client_cert and client_key must be set together")
+	}
+
+	switch c.SecurityMode() {
+	case IMAPSecurityTLS, IMAPSecurityStartTLS:
+		_, err := c.TLSConfig()
+		return err
+	case IMAPSecurityNone:
+		if c.CAFile != "" || c.ClientCert != "" || c.ServerName != "" || c.InsecureSkipVerify {
+			return fmt.Errorf("TLS options cannot be used with security %q", IMAPSecurityNone)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown security %q", c.Security)
+	}
+}
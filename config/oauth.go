@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Способы входа на IMAP-сервер
+const (
+	IMAPAuthPassword    = "password"    // логин и пароль (LOGIN)
+	IMAPAuthXOAuth2     = "xoauth2"     // токен OAuth2, SASL XOAUTH2 (Gmail, Microsoft 365)
+	IMAPAuthOAuthBearer = "oauthbearer" // токен OAuth2, SASL OAUTHBEARER (RFC 7628)
+)
+
+// Провайдеры OAuth2 с известными адресами и областями доступа
+const (
+	OAuthProviderMicrosoft = "microsoft"
+	OAuthProviderGoogle    = "google"
+)
+
+// OAuthConfig описывает получение токенов OAuth2 для входа на IMAP-сервер.
+// Адреса и области доступа берутся из provider, если не заданы явно.
+type OAuthConfig struct {
+	// Provider — microsoft или google; пусто, если заданы token_url и scopes
+	Provider string `yaml:"provider"`
+	// Tenant — клиент Microsoft Entra ID; по умолчанию common
+	Tenant        string   `yaml:"tenant"`
+	TokenURL      string   `yaml:"token_url"`
+	DeviceAuthURL string   `yaml:"device_auth_url"`
+	Scopes        []string `yaml:"scopes"`
+	// ClientID, ClientSecret и RefreshToken задаются в secrets.yaml
+	ClientID     string `yaml:"-"`
+	ClientSecret string `yaml:"-"`
+	RefreshToken string `yaml:"-"`
+}
+
+// AuthMode возвращает способ входа на сервер; по умолчанию — пароль
+func (c IMAPConfig) AuthMode() string {
+	if c.Auth == "" {
+		return IMAPAuthPassword
+	}
+	return c.Auth
+}
+
+// Endpoints возвращает адрес выдачи токенов, адрес авторизации устройства
+// и области доступа с учётом provider
+func (o OAuthConfig) Endpoints() (tokenURL, deviceAuthURL string, scopes []string) {
+	switch o.Provider {
+	case OAuthProviderMicrosoft:
+		tenant := o.Tenant
+		if tenant == "" {
+			tenant = "common"
+		}
+		base := "https://login.microsoftonline.com/" + tenant + "/oauth2/v2.0/"
+		tokenURL, deviceAuthURL = base+"token", base+"devicecode"
+		scopes = []string{"https://outlook.office.com/IMAP.AccessAsUser.All", "offline_access"}
+	case OAuthProviderGoogle:
+		tokenURL, deviceAuthURL = "https://oauth2.googleapis.com/token", "https://oauth2.googleapis.com/device/code"
+		scopes = []string{"https://mail.google.com/"}
+	}
+
+	if o.TokenURL != "" {
+		tokenURL = o.TokenURL
+	}
+	if o.DeviceAuthURL != "" {
+		deviceAuthURL = o.DeviceAuthURL
+	}
+	if len(o.Scopes) > 0 {
+		scopes = o.Scopes
+	}
+	return tokenURL, deviceAuthURL, scopes
+}
+
+// validateAuth проверяет способ входа и настройки OAuth2
+func (c IMAPConfig) validateAuth() error {
+	switch c.AuthMode() {
+	case IMAPAuthPassword:
+		return nil
+	case IMAPAuthXOAuth2, IMAPAuthOAuthBearer:
+	default:
+		return fmt.Errorf("unknown auth %q", c.Auth)
+	}
+
+	o := c.OAuth
+	if o.Provider != "" && !slices.Contains([]string{OAuthProviderMicrosoft, OAuthProviderGoogle}, o.Provider) {
+		return fmt.Errorf("oauth: unknown provider %q", o.Provider)
+	}
+	if tokenURL, _, _ := o.Endpoints(); tokenURL == "" {
+		return fmt.Errorf("oauth: provider or token_url is required")
+	}
+	if o.ClientID == "" {
+		return fmt.Errorf("oauth: client_id is required in secrets")
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import "fmt"
+
+// Действия с письмом в почтовом ящике после подтверждённой доставки
+const (
+	PostMove         = "move"          // переместить в папку folder (MOVE или COPY+UID EXPUNGE)
+	PostCopy         = "copy"          // скопировать в папку folder
+	PostAddFlags     = "add_flags"     // установить флаги flags
+	PostRemoveFlags  = "remove_flags"  // снять флаги flags
+	PostAddLabels    = "add_labels"    // добавить метки Gmail labels
+	PostRemoveLabels = "remove_labels" // снять метки Gmail labels
+	PostDelete       = "delete"        // пометить флагом \Deleted
+	PostExpunge      = "expunge"       // удалить письмо с флагом \Deleted из папки (UID EXPUNGE)
+)
+
+// PostAction — действие с письмом, которое выполняется после его доставки
+type PostAction struct {
+	Action string `yaml:"action"`
+	// Folder — папка для move и copy
+	Folder string `yaml:"folder"`
+	// Flags — флаги для add_flags и remove_flags, например \Seen, \Flagged или $Processed
+	Flags []string `yaml:"flags"`
+	// Labels — метки Gmail для add_labels и remove_labels
+	Labels []string `yaml:"labels"`
+}
+
+// validatePostActions проверяет параметры действий. После move и expunge письма
+// в папке уже нет, поэтому они могут быть только последними.
+func validatePostActions(actions []PostAction) error {
+	for i, a := range actions {
+		switch a.Action {
+		case PostMove, PostCopy:
+			if a.Folder == "" {
+				return fmt.Errorf("post_actions: folder is required for %s", a.Action)
+			}
+		case PostAddFlags, PostRemoveFlags:
+			if len(a.Flags) == 0 {
+				return fmt.Errorf("post_actions: flags are required for %s", a.Action)
+			}
+		case PostAddLabels, PostRemoveLabels:
+			if len(a.Labels) == 0 {
+				return fmt.Errorf("post_actions: labels are required for %s", a.Action)
+			}
+		case PostDelete, PostExpunge:
+		default:
+			return fmt.Errorf("post_actions: unknown action %q", a.Action)
+		}
+		if (a.Action == PostMove || a.Action == PostExpunge) && i != len(actions)-1 {
+			return fmt.Errorf("post_actions: %s must be the last action", a.Action)
+		}
+	}
+	return nil
+}
@@ -4,13 +4,24 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"os"
+	"sync/atomic"
 )
 
-// CachedConfig хранит конфигурацию и её хеши
+// CachedConfig хранит текущую конфигурацию и хеши файлов, из которых она загружена.
+// Конфигурация заменяется целиком, поэтому читать её можно из любых горутин через Current.
 type CachedConfig struct {
-	Config      *Config
+	current     atomic.Pointer[Config]
 	ConfigHash  string
 	SecretsHash string
+	// Resolve, если задан, вызывается для новой конфигурации перед её применением
+	// (например, чтобы разрешить имена каналов через Telegram API)
+	Resolve func(*Config) error
+}
+
+// Current возвращает текущую конфигурацию. Возвращённое значение не изменяется:
+// при перезагрузке создаётся новая конфигурация.
+func (c *CachedConfig) Current() *Config {
+	return c.current.Load()
 }
 
 // LoadConfigWithHash загружает конфиг и считает хеши
@@ -20,8 +31,6 @@ func LoadConfigWithHash(path string) (*CachedConfig, error) {
 		return nil, fmt.Errorf("cannot read config file: %w", err)
 	}
 
-	hash := fmt.Sprintf("%x", sha256.Sum256(data))
-
 	cfg, err := GetConfig(path)
 	if err != nil {
 		return nil, err
@@ -30,18 +39,21 @@ func LoadConfigWithHash(path string) (*CachedConfig, error) {
 	secretsHash := ""
 	if cfg.SecretsPath != "" {
 		if sData, err := os.ReadFile(cfg.SecretsPath); err == nil {
-			secretsHash = fmt.Sprintf("%x", sha256.Sum256(sData))
+			secretsHash = hash(sData)
 		}
 	}
 
-	return &CachedConfig{
-		Config:      cfg,
-		ConfigHash:  hash,
+	c := &CachedConfig{
+		ConfigHash:  hash(data),
 		SecretsHash: secretsHash,
-	}, nil
+	}
+	c.current.Store(cfg)
+	return c, nil
 }
 
-// ReloadIfChanged перечитывает конфиг и секреты, если хеш изменился
+// ReloadIfChanged перечитывает конфиг и секреты, если хеш одного из файлов изменился.
+// Новая конфигурация полностью собирается (вместе с секретами и Resolve) и только
+// после этого заменяет текущую. Вызывается из одной горутины.
 func (c *CachedConfig) ReloadIfChanged(path string) (bool, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -50,37 +62,49 @@ func (c *CachedConfig) ReloadIfChanged(path string) (bool, error) {
 		}
 		return false, fmt.Errorf("cannot read config file: %w", err)
 	}
+	newHash := hash(data)
+
+	if newHash == c.ConfigHash {
+		secretsHash, err := secretsFileHash(c.Current().SecretsPath)
+		if err != nil {
+			return false, err
+		}
+		if secretsHash == c.SecretsHash {
+			return false, nil
+		}
+	}
 
-	newHash := fmt.Sprintf("%x", sha256.Sum256(data))
 	cfg, err := GetConfig(path)
 	if err != nil {
 		return false, err
 	}
+	secretsHash, err := secretsFileHash(cfg.SecretsPath)
+	if err != nil {
+		return false, err
+	}
+	if c.Resolve != nil {
+		if err := c.Resolve(cfg); err != nil {
+			return false, err
+		}
+	}
 
-	changed := false
+	c.current.Store(cfg)
+	c.ConfigHash, c.SecretsHash = newHash, secretsHash
+	return true, nil
+}
 
-	if newHash != c.ConfigHash {
-		c.Config = cfg
-		c.ConfigHash = newHash
-		changed = true
+// secretsFileHash возвращает хеш файла секретов; пусто, если файл не задан
+func secretsFileHash(path string) (string, error) {
+	if path == "" {
+		return "", nil
 	}
-
-	// Проверяем secrets
-	if cfg.SecretsPath != "" {
-		sData, err := os.ReadFile(cfg.SecretsPath)
-		if err != nil {
-			return changed, fmt.Errorf("cannot read secrets file: %w", err)
-		}
-		newSecretsHash := fmt.Sprintf("%x", sha256.Sum256(sData))
-		if newSecretsHash != c.SecretsHash {
-			// Перечитываем секреты
-			if err := c.Config.LoadSecrets(); err != nil {
-				return changed, err
-			}
-			c.SecretsHash = newSecretsHash
-			changed = true
-		}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read secrets file: %w", err)
 	}
+	return hash(data), nil
+}
 
-	return changed, nil
+func hash(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
 }
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const reloadTestConfig = `imap:
+  host: imap.example.com
+  port: 993
+  username: user
+telegram:
+  default_channel: "-100123"
+  errors_channel: "-100456"
+secrets: %s
+check_interval: 60
+`
+
+// writeReloadFiles записывает конфигурацию и секреты с токеном token во временный каталог
+func writeReloadFiles(t *testing.T, dir, token string) string {
+	t.Helper()
+	secrets := filepath.Join(dir, "secrets.yaml")
+	if err := os.WriteFile(secrets, []byte("telegram:\n  token: \""+token+"\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	data := []byte(fmt.Sprintf(reloadTestConfig, secrets))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReloadIfChanged(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(t *testing.T, dir, path string)
+		resolve func(*Config) error
+		changed bool
+		wantErr bool
+		token   string
+	}{
+		{
+			name:   "unchanged",
+			change: func(t *testing.T, dir, path string) {},
+			token:  "old",
+		},
+		{
+			name: "secrets changed",
+			change: func(t *testing.T, dir, path string) {
+				writeReloadFiles(t, dir, "new")
+			},
+			changed: true,
+			token:   "new",
+		},
+		{
+			name: "invalid config keeps current",
+			change: func(t *testing.T, dir, path string) {
+				if err := os.WriteFile(path, []byte("check_interval: [\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+			token:   "old",
+		},
+		{
+			name: "resolve failure keeps current",
+			change: func(t *testing.T, dir, path string) {
+				writeReloadFiles(t, dir, "new")
+			},
+			resolve: func(*Config) error { return errors.New("chat not found") },
+			wantErr: true,
+			token:   "old",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeReloadFiles(t, dir, "old")
+			c, err := LoadConfigWithHash(path)
+			if err != nil {
+				t.Fatalf("LoadConfigWithHash: %v", err)
+			}
+			c.Resolve = tt.resolve
+			before := c.Current()
+			hashes := [2]string{c.ConfigHash, c.SecretsHash}
+
+			tt.change(t, dir, path)
+			changed, err := c.ReloadIfChanged(path)
+			if changed != tt.changed || (err != nil) != tt.wantErr {
+				t.Fatalf("ReloadIfChanged = %v, %v; want %v, error %v", changed, err, tt.changed, tt.wantErr)
+			}
+			if got := c.Current().Telegram.Token; got != tt.token {
+				t.Errorf("token = %q, want %q", got, tt.token)
+			}
+			// Прежняя конфигурация не изменяется на месте
+			if before.Telegram.Token != "old" {
+				t.Errorf("previous config was modified: token = %q", before.Telegram.Token)
+			}
+			if !tt.changed {
+				if c.Current() != before {
+					t.Error("config replaced without a successful reload")
+				}
+				// Неудачная перезагрузка повторяется на следующей итерации
+				if [2]string{c.ConfigHash, c.SecretsHash} != hashes {
+					t.Error("hashes updated without a successful reload")
+				}
+			}
+		})
+	}
+}
+
+func TestReloadIfChangedConcurrentReaders(t *testing.T) {
+	dir := t.TempDir()
+	path := writeReloadFiles(t, dir, "token-0")
+	c, err := LoadConfigWithHash(path)
+	if err != nil {
+		t.Fatalf("LoadConfigWithHash: %v", err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cfg := c.Current()
+				if cfg.Telegram.Token == "" || len(cfg.GetAccounts()) != 1 {
+					t.Error("reader observed a partially loaded config")
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 20; i++ {
+		writeReloadFiles(t, dir, fmt.Sprintf("token-%d", i))
+		if _, err := c.ReloadIfChanged(path); err != nil {
+			t.Errorf("ReloadIfChanged: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Действия с письмами правила вне окна расписания
+const (
+	OutsideRedirect = "redirect" // отправить получателю redirect
+	OutsideSilent   = "silent"   // отправить без звука
+	OutsideHold     = "hold"     // отложить до открытия окна
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// RuleSchedule — окно времени, в которое правило отправляет письма как обычно
+// (например, рабочие часы), и действие с письмами вне окна
+type RuleSchedule struct {
+	// Timezone — часовой пояс окна (например, Europe/Moscow); по умолчанию часовой пояс сервера
+	Timezone string `yaml:"timezone"`
+	// Days — дни недели окна: mon, tue, ..., sun или диапазоны вида mon-fri; по умолчанию все дни
+	Days []string `yaml:"days"`
+	// Hours — часы окна вида 09:00-18:00; окно может переходить через полночь (22:00-06:00).
+	// По умолчанию весь день.
+	Hours string `yaml:"hours"`
+	// Outside — что делать с письмами вне окна: redirect, silent или hold
+	Outside string `yaml:"outside"`
+	// Redirect — получатель писем вне окна для outside: redirect
+	Redirect string `yaml:"redirect"`
+
+	// window — окно, разобранное при проверке конфигурации
+	window *window
+}
+
+// window — разобранное окно расписания
+type window struct {
+	loc  *time.Location
+	days [7]bool
+	// from, to — начало и конец окна в минутах от полуночи; from == to — весь день
+	from, to int
+}
+
+// validate проверяет и запоминает часовой пояс, дни и часы окна, а также действие вне окна
+func (s *RuleSchedule) validate() error {
+	w, err := s.parse()
+	if err != nil {
+		return fmt.Errorf("schedule: %w", err)
+	}
+	s.window = w
+	switch s.Outside {
+	case OutsideRedirect:
+		if s.Redirect == "" {
+			return fmt.Errorf("schedule: redirect is required for outside: redirect")
+		}
+	case OutsideSilent, OutsideHold:
+	default:
+		return fmt.Errorf("schedule: unknown outside action %q", s.Outside)
+	}
+	return nil
+}
+
+// Contains проверяет, попадает ли момент t в окно. День недели определяется по дню
+// начала окна, поэтому ночь с пятницы на субботу для окна 22:00-06:00 и days: [fri]
+// целиком относится к пятнице.
+func (s RuleSchedule) Contains(t time.Time) bool {
+	w := s.resolved()
+	if w == nil {
+		return true
+	}
+	t = t.In(w.loc)
+
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	switch {
+	case w.from == w.to:
+		// окно на весь день
+	case w.from < w.to:
+		if minute < w.from || minute >= w.to {
+			return false
+		}
+	default:
+		if minute >= w.to && minute < w.from {
+			return false
+		}
+		if minute < w.to {
+			day = (day + 6) % 7
+		}
+	}
+	return w.days[day]
+}
+
+// NextOpen возвращает ближайший момент после t, когда окно открыто:
+// сам t, если окно открыто, или начало ближайшего окна в один из дней days
+func (s RuleSchedule) NextOpen(t time.Time) time.Time {
+	t = t.Truncate(time.Minute)
+	w := s.resolved()
+	if w == nil || s.Contains(t) {
+		return t
+	}
+
+	local := t.In(w.loc)
+	for i := 0; i <= 14; i++ {
+		from := w.from
+		if w.from == w.to {
+			from = 0
+		}
+		start := time.Date(local.Year(), local.Month(), local.Day()+i, from/60, from%60, 0, 0, w.loc)
+		if start.Hour()*60+start.Minute() != from {
+			// Начала окна нет из-за перевода часов вперёд: окно открывается сразу после перевода
+			day := time.Date(local.Year(), local.Month(), local.Day()+i, 0, 0, 0, 0, w.loc)
+			n := sort.Search(int(start.Sub(day)/time.Minute), func(m int) bool {
+				l := day.Add(time.Duration(m) * time.Minute).In(w.loc)
+				return l.Hour()*60+l.Minute() >= from
+			})
+			start = day.Add(time.Duration(n) * time.Minute)
+		} else if earlier := start.Add(-time.Hour); earlier.In(w.loc).Hour() == start.Hour() {
+			// При переводе часов назад начало окна наступает дважды — берётся первое,
+			// если оно ещё не прошло
+			if earlier.After(t) && s.Contains(earlier) {
+				return earlier
+			}
+		}
+		if start.After(t) && s.Contains(start) {
+			return start
+		}
+	}
+	return t
+}
+
+// resolved возвращает окно, разобранное при проверке конфигурации, или разбирает его заново,
+// если расписание не проверялось; nil, если расписание некорректно
+func (s RuleSchedule) resolved() *window {
+	if s.window != nil {
+		return s.window
+	}
+	w, err := s.parse()
+	if err != nil {
+		return nil
+	}
+	return w
+}
+
+// parse разбирает часовой пояс, дни и часы окна
+func (s RuleSchedule) parse() (*window, error) {
+	loc, err := s.location()
+	if err != nil {
+		return nil, err
+	}
+	days, err := s.days()
+	if err != nil {
+		return nil, err
+	}
+	from, to, err := s.hours()
+	if err != nil {
+		return nil, err
+	}
+	return &window{loc: loc, days: days, from: from, to: to}, nil
+}
+
+func (s RuleSchedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", s.Timezone, err)
+	}
+	return loc, nil
+}
+
+// days возвращает дни недели окна
+func (s RuleSchedule) days() ([7]bool, error) {
+	var days [7]bool
+	if len(s.Days) == 0 {
+		return [7]bool{true, true, true, true, true, true, true}, nil
+	}
+	for _, d := range s.Days {
+		a, b, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(d)), "-")
+		if !isRange {
+			b = a
+		}
+		from, ok1 := weekdays[a]
+		to, ok2 := weekdays[b]
+		if !ok1 || !ok2 {
+			return days, fmt.Errorf("invalid day %q", d)
+		}
+		for w := from; ; w = (w + 1) % 7 {
+			days[w] = true
+			if w == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// hours возвращает начало и конец окна в минутах от полуночи
+func (s RuleSchedule) hours() (from, to int, err error) {
+	if s.Hours == "" {
+		return 0, 0, nil
+	}
+	a, b, ok := strings.Cut(s.Hours, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid hours %q: expected HH:MM-HH:MM", s.Hours)
+	}
+	parse := func(v string) (int, error) {
+		t, err := time.Parse("15:04", strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("invalid hours %q: expected HH:MM-HH:MM", s.Hours)
+		}
+		return t.Hour()*60 + t.Minute(), nil
+	}
+	if from, err = parse(a); err != nil {
+		return 0, 0, err
+	}
+	if to, err = parse(b); err != nil {
+		return 0, 0, err
+	}
+	return from, to, nil
+}
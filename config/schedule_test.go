@@ -0,0 +1,162 @@
+package config
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// ruleSchedule возвращает проверенное расписание с окном days и hours в часовом поясе tz
+func ruleSchedule(t *testing.T, tz string, hours string, days ...string) RuleSchedule {
+	t.Helper()
+	loadLocation(t, tz)
+	s := RuleSchedule{Timezone: tz, Days: days, Hours: hours, Outside: OutsideHold}
+	if err := s.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	return s
+}
+
+func TestRuleScheduleContains(t *testing.T) {
+	berlin := loadLocation(t, "Europe/Berlin")
+	moscow := loadLocation(t, "Europe/Moscow")
+	// 16.10.2026 — пятница
+	msk := func(day, hour, min int) time.Time { return time.Date(2026, 10, day, hour, min, 0, 0, moscow) }
+	ber := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, berlin)
+	}
+	tests := []struct {
+		name   string
+		tz     string
+		hours  string
+		days   []string
+		at     time.Time
+		inside bool
+	}{
+		{name: "at open", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: msk(16, 9, 0), inside: true},
+		{name: "before open", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: msk(16, 8, 59)},
+		{name: "before close", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: msk(16, 17, 59), inside: true},
+		{name: "at close", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: msk(16, 18, 0)},
+		{name: "weekend", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: msk(17, 12, 0)},
+		{name: "other time zone", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC), inside: true},
+		{name: "whole day", tz: "Europe/Moscow", days: []string{"fri"}, at: msk(16, 0, 0), inside: true},
+		{name: "whole day ends at midnight", tz: "Europe/Moscow", days: []string{"fri"}, at: msk(17, 0, 0)},
+		{name: "night at open", tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"fri"}, at: ber(10, 16, 22, 0), inside: true},
+		{name: "night before open", tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"fri"}, at: ber(10, 16, 21, 59)},
+		{name: "night after midnight", tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"fri"}, at: ber(10, 17, 5, 59), inside: true},
+		{name: "night at close", tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"fri"}, at: ber(10, 17, 6, 0)},
+		// Утро пятницы относится к ночи с четверга
+		{name: "night of previous day", tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"fri"}, at: ber(10, 16, 5, 0)},
+		{name: "night of next day", tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"fri"}, at: ber(10, 17, 22, 0)},
+		{name: "fri-mon friday", tz: "Europe/Moscow", days: []string{"fri-mon"}, at: msk(16, 12, 0), inside: true},
+		{name: "fri-mon sunday", tz: "Europe/Moscow", days: []string{"fri-mon"}, at: msk(18, 12, 0), inside: true},
+		{name: "fri-mon monday", tz: "Europe/Moscow", days: []string{"fri-mon"}, at: msk(19, 23, 59), inside: true},
+		{name: "fri-mon tuesday", tz: "Europe/Moscow", days: []string{"fri-mon"}, at: msk(20, 0, 0)},
+		{name: "fri-mon thursday", tz: "Europe/Moscow", days: []string{"fri-mon"}, at: msk(15, 23, 59)},
+		{name: "fri-mon night into tuesday", tz: "Europe/Moscow", hours: "22:00-06:00", days: []string{"fri-mon"}, at: msk(20, 5, 0), inside: true},
+		{name: "fri-mon night of tuesday", tz: "Europe/Moscow", hours: "22:00-06:00", days: []string{"fri-mon"}, at: msk(20, 22, 0)},
+		{name: "list of days", tz: "Europe/Moscow", days: []string{"mon", "Wed"}, at: msk(14, 12, 0), inside: true},
+		// 29.03.2026 в Берлине часы переводятся с 02:00 на 03:00, 25.10.2026 — с 03:00 на 02:00
+		{name: "spring after gap", tz: "Europe/Berlin", hours: "02:30-03:30", at: ber(3, 29, 3, 0), inside: true},
+		{name: "spring at close", tz: "Europe/Berlin", hours: "02:30-03:30", at: ber(3, 29, 3, 30)},
+		{name: "spring night across gap", tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"sat"}, at: ber(3, 29, 5, 59), inside: true},
+		{name: "autumn first pass", tz: "Europe/Berlin", hours: "02:30-03:30", at: time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC), inside: true},
+		{name: "autumn second pass", tz: "Europe/Berlin", hours: "02:30-03:30", at: time.Date(2026, 10, 25, 1, 30, 0, 0, time.UTC), inside: true},
+		{name: "autumn between passes", tz: "Europe/Berlin", hours: "02:30-03:30", at: time.Date(2026, 10, 25, 1, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := ruleSchedule(t, tt.tz, tt.hours, tt.days...)
+			if got := s.Contains(tt.at); got != tt.inside {
+				t.Errorf("Contains(%v) = %v, want %v", tt.at, got, tt.inside)
+			}
+		})
+	}
+}
+
+func TestRuleScheduleNextOpen(t *testing.T) {
+	berlin := loadLocation(t, "Europe/Berlin")
+	moscow := loadLocation(t, "Europe/Moscow")
+	msk := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, moscow)
+	}
+	ber := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, berlin)
+	}
+	tests := []struct {
+		name  string
+		tz    string
+		hours string
+		days  []string
+		at    time.Time
+		want  time.Time
+	}{
+		{name: "open now", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: msk(10, 16, 9, 0), want: msk(10, 16, 9, 0)},
+		{name: "truncated to minute", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: msk(10, 15, 8, 59).Add(30 * time.Second), want: msk(10, 15, 9, 0)},
+		{name: "at close", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: msk(10, 16, 18, 0), want: msk(10, 19, 9, 0)},
+		{name: "weekend", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: msk(10, 17, 12, 34).Add(56 * time.Second), want: msk(10, 19, 9, 0)},
+		// В Москве нет перевода часов: окно открывается в 09:00 MSK
+		{name: "moscow march", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: msk(3, 27, 18, 0), want: msk(3, 30, 9, 0)},
+		{name: "moscow october", tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}, at: msk(10, 23, 18, 0), want: msk(10, 26, 9, 0)},
+		{name: "berlin march", tz: "Europe/Berlin", hours: "09:00-18:00", days: []string{"mon-fri"}, at: ber(3, 27, 18, 0), want: time.Date(2026, 3, 30, 7, 0, 0, 0, time.UTC)},
+		{name: "berlin october", tz: "Europe/Berlin", hours: "09:00-18:00", days: []string{"mon-fri"}, at: ber(10, 23, 18, 0), want: time.Date(2026, 10, 26, 8, 0, 0, 0, time.UTC)},
+		{name: "night same day", tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"fri"}, at: ber(10, 16, 5, 0), want: ber(10, 16, 22, 0)},
+		{name: "night at close", tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"fri"}, at: ber(10, 17, 6, 0), want: ber(10, 23, 22, 0)},
+		{name: "night open after midnight", tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"fri"}, at: ber(10, 17, 1, 0), want: ber(10, 17, 1, 0)},
+		{name: "fri-mon after monday night", tz: "Europe/Moscow", hours: "22:00-06:00", days: []string{"fri-mon"}, at: msk(10, 20, 6, 0), want: msk(10, 23, 22, 0)},
+		{name: "fri-mon whole days", tz: "Europe/Moscow", days: []string{"fri-mon"}, at: msk(10, 20, 0, 0), want: msk(10, 23, 0, 0)},
+		// Начало окна 02:30 попадает в пропущенный час: окно открывается в 03:00 летнего времени
+		{name: "spring gap", tz: "Europe/Berlin", hours: "02:30-03:30", at: ber(3, 29, 1, 0), want: ber(3, 29, 3, 0)},
+		{name: "spring night across gap", tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"sat"}, at: ber(3, 28, 12, 0), want: ber(3, 28, 22, 0)},
+		{name: "after spring gap", tz: "Europe/Berlin", hours: "02:30-03:30", at: ber(3, 29, 3, 30), want: ber(3, 30, 2, 30)},
+		// Начало окна 02:30 наступает дважды: берётся первое, по летнему времени
+		{name: "autumn repeated hour", tz: "Europe/Berlin", hours: "02:30-03:30", at: ber(10, 25, 1, 0), want: time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC)},
+		{name: "autumn between passes", tz: "Europe/Berlin", hours: "02:30-03:30", at: time.Date(2026, 10, 25, 1, 0, 0, 0, time.UTC), want: time.Date(2026, 10, 25, 1, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := ruleSchedule(t, tt.tz, tt.hours, tt.days...)
+			if got := s.NextOpen(tt.at); !got.Equal(tt.want) {
+				t.Errorf("NextOpen(%v) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRuleScheduleNextOpenExhaustive сверяет NextOpen с поминутным перебором
+func TestRuleScheduleNextOpenExhaustive(t *testing.T) {
+	schedules := []struct {
+		tz    string
+		hours string
+		days  []string
+	}{
+		{tz: "Europe/Moscow", hours: "09:00-18:00", days: []string{"mon-fri"}},
+		{tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"fri"}},
+		{tz: "Europe/Berlin", hours: "22:00-06:00", days: []string{"fri-mon"}},
+		{tz: "Europe/Berlin", hours: "02:30-03:30"},
+		{tz: "Europe/Berlin", hours: "02:30-02:10"},
+		{tz: "Europe/Berlin", hours: "02:00-02:45", days: []string{"sun"}},
+		{tz: "America/Santiago", hours: "00:00-00:30", days: []string{"sun"}},
+	}
+	brute := func(s RuleSchedule, at time.Time) time.Time {
+		at = at.Truncate(time.Minute)
+		for !s.Contains(at) {
+			at = at.Add(time.Minute)
+		}
+		return at
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, sc := range schedules {
+		s := ruleSchedule(t, sc.tz, sc.hours, sc.days...)
+		for i := 0; i < 300; i++ {
+			at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(r.Int63n(int64(365 * 24 * time.Hour))))
+			if i%2 == 0 {
+				// Около переводов часов в Европе
+				at = time.Date(2026, 3+7*time.Month(i%4/2), 24+r.Intn(3), 0, 0, 0, 0, time.UTC).Add(time.Duration(r.Int63n(int64(48 * time.Hour))))
+			}
+			if got, want := s.NextOpen(at), brute(s, at); !got.Equal(want) {
+				t.Fatalf("%s %s %v: NextOpen(%v) = %v, want %v", sc.tz, sc.hours, sc.days, at, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package actions
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/state"
+	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"go.uber.org/zap"
+)
+
+// retention — срок, в течение которого кнопки под сообщением остаются рабочими
+const retention = 30 * 24 * time.Hour
+
+// labels — подписи кнопок действий
+var labels = map[string]string{
+	config.ActionRead:    "✉️ Прочитано",
+	config.ActionFlag:    "🚩 Флаг",
+	config.ActionArchive: "🗄 В архив",
+	config.ActionDelete:  "🗑 Удалить",
+	config.ActionBody:    "📄 Полный текст",
+}
+
+// target — письмо, к которому относятся кнопки сообщения
+type target struct {
+	Ref           string `json:"ref"`
+	ArchiveFolder string `json:"archive_folder,omitempty"`
+}
+
+// targets связывает короткий идентификатор из данных кнопки с письмом:
+// данные кнопки Telegram ограничены 64 байтами
+var targets *state.Index
+
+// Open открывает хранилище ссылок на письма для кнопок действий
+func Open(path string) error {
+	idx, err := state.OpenIndex(path, retention)
+	if err != nil {
+		return err
+	}
+	targets = idx
+	return nil
+}
+
+// Label возвращает подпись кнопки действия
+func Label(action string) string {
+	return labels[action]
+}
+
+// Buttons возвращает кнопки действий с письмом ref и запоминает, к какому письму они относятся.
+// Если хранилище недоступно, сообщение отправляется без кнопок.
+func Buttons(bc config.ButtonsConfig, ref string, logger *zap.SugaredLogger) []telegram.Button {
+	if len(bc.Actions) == 0 || ref == "" || targets == nil {
+		return nil
+	}
+
+	t := target{Ref: ref, ArchiveFolder: bc.ArchiveFolder}
+	data, err := json.Marshal(t)
+	if err != nil {
+		logger.Warnw("cannot encode message reference, sending without buttons", "error", err)
+		return nil
+	}
+	token := fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+	if err := targets.Put(token, string(data)); err != nil {
+		logger.Warnw("cannot save message reference, sending without buttons", "error", err)
+		return nil
+	}
+
+	buttons := make([]telegram.Button, 0, len(bc.Actions))
+	for _, a := range bc.Actions {
+		buttons = append(buttons, telegram.Button{Text: Label(a), Data: a + "|" + token})
+	}
+	return buttons
+}
+
+// Do выполняет действие кнопки над исходным письмом через IMAP
+func Do(cfg *config.Config, action, token string, logger *zap.SugaredLogger) error {
+	t, acc, key, uid, err := resolve(cfg, token)
+	if err != nil {
+		return err
+	}
+	logger.Infow("applying email action", "action", action, "account", acc.Name, "folder", key.Folder, "uid", uid)
+	return email.ApplyAction(acc, key, uid, action, t.ArchiveFolder, logger)
+}
+
+// Body возвращает тему и полный текст исходного письма
+func Body(cfg *config.Config, token string, logger *zap.SugaredLogger) (string, error) {
+	_, acc, key, uid, err := resolve(cfg, token)
+	if err != nil {
+		return "", err
+	}
+	m, err := email.FetchMessage(acc, key, uid, logger)
+	if err != nil {
+		return "", err
+	}
+	msg := email.Decode(m, logger)
+	return msg.Subject + "\n\n" + msg.Body, nil
+}
+
+// resolve находит письмо и учётную запись по идентификатору из данных кнопки
+func resolve(cfg *config.Config, token string) (target, config.Account, state.Key, uint32, error) {
+	var t target
+	if targets == nil {
+		return t, config.Account{}, state.Key{}, 0, errors.New("message references are not available")
+	}
+	data, ok := targets.Get(token)
+	if !ok {
+		return t, config.Account{}, state.Key{}, 0, errors.New("message reference expired")
+	}
+	if err := json.Unmarshal([]byte(data), &t); err != nil {
+		return t, config.Account{}, state.Key{}, 0, fmt.Errorf("cannot parse message reference: %w", err)
+	}
+
+	key, uid, err := state.ParseRef(t.Ref)
+	if err != nil {
+		return t, config.Account{}, state.Key{}, 0, err
+	}
+	acc, ok := email.FindAccount(cfg, key.Account)
+	if !ok {
+		return t, config.Account{}, state.Key{}, 0, fmt.Errorf("account %s is not configured", key.Account)
+	}
+	return t, acc, key, uid, nil
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 )
 
+// Status хранит состояние одной проверяемой операции для алертинга
 type Status struct {
 	lastSuccess time.Time
 	healthy     bool
@@ -16,7 +17,9 @@ type Status struct {
 	initialized bool
 }
 
-func ConnectToIMAPError(err error, logger *zap.SugaredLogger, conf *config.CachedConfig, status *Status) {
+// ConnectToIMAPError отслеживает состояние подключения к IMAP учётной записи account
+// и уведомляет errors_channel о длительной ошибке и о восстановлении.
+func ConnectToIMAPError(err error, logger *zap.SugaredLogger, conf *config.CachedConfig, account string, status *Status) {
 	if status.lastSuccess.IsZero() {
 		status.lastSuccess = time.Now()
 	}
@@ -24,9 +27,9 @@ func ConnectToIMAPError(err error, logger *zap.SugaredLogger, conf *config.Cache
 		logger.Errorf("IMAP connection error: %v", err)
 		metrics.MailErrors.Inc()
 		status.healthy = false
-		if time.Since(status.lastSuccess) > time.Duration(conf.Config.Alerting.AlertEmailDelay)*time.Second && !status.alertSent {
-			telegram.SendToTelegram(fmt.Sprintf("Ошибка подключения: %v. Последняя успешная проверка в %v", err, status.lastSuccess.Format("2006-01-02 15:04:05")),
-				conf.Config.Telegram.ErrorsChannel, logger)
+		if time.Since(status.lastSuccess) > time.Duration(conf.Current().Alerting.AlertEmailDelay)*time.Second && !status.alertSent {
+			telegram.SendToTelegram(fmt.Sprintf("Ошибка подключения [%s]: %v. Последняя успешная проверка в %v", account, err, status.lastSuccess.Format("2006-01-02 15:04:05")),
+				conf.Current().Telegram.ErrorsChannel, logger)
 			status.alertSent = true
 			status.initialized = true
 		}
@@ -35,15 +38,17 @@ func ConnectToIMAPError(err error, logger *zap.SugaredLogger, conf *config.Cache
 
 	status.lastSuccess = time.Now()
 	if !status.healthy && status.initialized {
-		telegram.SendToTelegram(fmt.Sprintf("Подключение восстановлено в %v", status.lastSuccess.Format("2006-01-02 15:04:05")),
-			conf.Config.Telegram.ErrorsChannel, logger)
+		telegram.SendToTelegram(fmt.Sprintf("Подключение [%s] восстановлено в %v", account, status.lastSuccess.Format("2006-01-02 15:04:05")),
+			conf.Current().Telegram.ErrorsChannel, logger)
 		status.healthy = true
 		status.alertSent = false
 		status.initialized = true
 	}
 }
 
-func FetchUnreadEmailsError(err error, logger *zap.SugaredLogger, conf *config.CachedConfig, status *Status) {
+// FetchUnreadEmailsError отслеживает состояние получения писем учётной записи account
+// и уведомляет errors_channel о длительной ошибке и о восстановлении.
+func FetchUnreadEmailsError(err error, logger *zap.SugaredLogger, conf *config.CachedConfig, account string, status *Status) {
 	if status.lastSuccess.IsZero() {
 		status.lastSuccess = time.Now()
 	}
@@ -51,9 +56,9 @@ func FetchUnreadEmailsError(err error, logger *zap.SugaredLogger, conf *config.C
 		logger.Errorf("fetch unread emails error: %v", err)
 		metrics.MailErrors.Inc()
 		status.healthy = false
-		if time.Since(status.lastSuccess) > time.Duration(conf.Config.Alerting.AlertEmailDelay)*time.Second && !status.alertSent {
-			telegram.SendToTelegram(fmt.Sprintf("Ошибка получения писем: %v. Последняя успешная проверка в %v", err, status.lastSuccess.Format("2006-01-02 15:04:05")),
-				conf.Config.Telegram.ErrorsChannel, logger)
+		if time.Since(status.lastSuccess) > time.Duration(conf.Current().Alerting.AlertEmailDelay)*time.Second && !status.alertSent {
+			telegram.SendToTelegram(fmt.Sprintf("Ошибка получения писем [%s]: %v. Последняя успешная проверка в %v", account, err, status.lastSuccess.Format("2006-01-02 15:04:05")),
+				conf.Current().Telegram.ErrorsChannel, logger)
 			status.alertSent = true
 			status.initialized = true
 		}
@@ -62,8 +67,8 @@ func FetchUnreadEmailsError(err error, logger *zap.SugaredLogger, conf *config.C
 
 	status.lastSuccess = time.Now()
 	if !status.healthy && status.initialized {
-		telegram.SendToTelegram(fmt.Sprintf("Получение писем восстановлено в %v", status.lastSuccess.Format("2006-01-02 15:04:05")),
-			conf.Config.Telegram.ErrorsChannel, logger)
+		telegram.SendToTelegram(fmt.Sprintf("Получение писем [%s] восстановлено в %v", account, status.lastSuccess.Format("2006-01-02 15:04:05")),
+			conf.Current().Telegram.ErrorsChannel, logger)
 		status.healthy = true
 		status.alertSent = false
 		status.initialized = true
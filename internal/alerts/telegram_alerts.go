@@ -0,0 +1,27 @@
+package alerts
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"go.uber.org/zap"
+)
+
+// DeadLetter уведомляет errors_channel о сообщении, которое не удалось доставить в Telegram.
+// Сообщения, не доставленные в сам errors_channel, не вызывают уведомления,
+// чтобы не зациклить отправку.
+func DeadLetter(conf *config.CachedConfig, dl telegram.DeadLetter, logger *zap.SugaredLogger) {
+	channel := conf.Current().Telegram.ErrorsChannel
+	if channel == "" || config.ParseDestination(channel).ChatID == strconv.FormatInt(dl.ChatID, 10) {
+		return
+	}
+
+	ref := dl.Ref
+	if ref == "" {
+		ref = "-"
+	}
+	telegram.SendToTelegram(fmt.Sprintf("Сообщение не доставлено в чат %d: %s. Письмо: %s. Повторить: mail2tg dlq replay %s",
+		dl.ChatID, dl.LastError, ref, dl.ID), channel, logger)
+}
@@ -0,0 +1,159 @@
+package bot
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/scheduler"
+	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"go.uber.org/zap"
+	tb "gopkg.in/telebot.v3"
+)
+
+// commands — команды операторов, доступные пользователям из telegram.allowed_users
+var commands = []tb.Command{
+	{Text: "status", Description: "Состояние проверки почты и очереди"},
+	{Text: "check", Description: "Проверить почту сейчас"},
+	{Text: "pause", Description: "Приостановить маршрутизацию"},
+	{Text: "resume", Description: "Возобновить маршрутизацию"},
+	{Text: "rules", Description: "Показать правила маршрутизации"},
+}
+
+// Start регистрирует команды операторов, обработчики кнопок и ответов на письма
+// и запускает получение обновлений бота.
+// Команды принимаются только от пользователей из telegram.allowed_users.
+func Start(conf *config.CachedConfig, start time.Time, logger *zap.SugaredLogger) {
+	b := telegram.Bot
+	restrict := func(h tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) error {
+			if !allowed(conf.Current(), c) {
+				logger.Warnw("bot command from unauthorized user ignored", "user", senderID(c), "command", c.Text())
+				return nil
+			}
+			logger.Infow("bot command received", "user", c.Sender().ID, "command", c.Text())
+			return h(c)
+		}
+	}
+
+	b.Handle("/status", restrict(func(c tb.Context) error {
+		return c.Send(statusText(conf.Current(), start))
+	}))
+	b.Handle("/check", restrict(func(c tb.Context) error {
+		scheduler.CheckNow()
+		return c.Send("Внеочередная проверка почты запущена")
+	}))
+	b.Handle("/pause", restrict(func(c tb.Context) error {
+		scheduler.Pause()
+		logger.Warnw("routing paused", "user", c.Sender().ID)
+		return c.Send("Маршрутизация приостановлена. Новые письма будут доставлены после /resume")
+	}))
+	b.Handle("/resume", restrict(func(c tb.Context) error {
+		scheduler.Resume()
+		logger.Infow("routing resumed", "user", c.Sender().ID)
+		return c.Send("Маршрутизация возобновлена")
+	}))
+	b.Handle("/rules", restrict(func(c tb.Context) error {
+		return c.Send(rulesText(conf.Current()))
+	}))
+	b.Handle(&tb.InlineButton{Unique: telegram.ButtonUnique}, func(c tb.Context) error {
+		return onButton(conf.Current(), c, logger)
+	})
+	b.Handle(tb.OnText, func(c tb.Context) error {
+		return onText(conf.Current(), c, logger)
+	})
+
+	if len(conf.Current().Telegram.AllowedUsers) == 0 {
+		logger.Infow("telegram.allowed_users is empty, bot commands are disabled")
+	}
+	if err := b.SetCommands(commands); err != nil {
+		logger.Warnw("failed to register bot commands", "error", err)
+	}
+
+	go b.Start()
+	logger.Infow("bot commands enabled", "allowed_users", conf.Current().Telegram.AllowedUsers)
+}
+
+// Stop останавливает получение обновлений бота
+func Stop() {
+	telegram.Bot.Stop()
+}
+
+// statusText формирует ответ на /status
+func statusText(cfg *config.Config, start time.Time) string {
+	var b strings.Builder
+
+	state := "работает"
+	if scheduler.Paused() {
+		state = "маршрутизация приостановлена"
+	}
+	fmt.Fprintf(&b, "Состояние: %s\n", state)
+	fmt.Fprintf(&b, "Время работы: %s\n", time.Since(start).Round(time.Second))
+	fmt.Fprintf(&b, "Очередь Telegram: %d\n", telegram.QueueDepth())
+
+	b.WriteString("\nПоследняя успешная проверка:\n")
+	for _, f := range scheduler.Status(cfg) {
+		last := "ещё не было"
+		if !f.LastCheck.IsZero() {
+			last = f.LastCheck.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(&b, "[%s] %s — %s\n", f.Account, f.Folder, last)
+	}
+	return b.String()
+}
+
+// rulesText формирует ответ на /rules: действующие правила маршрутизации по папкам
+func rulesText(cfg *config.Config) string {
+	var b strings.Builder
+	for _, acc := range cfg.GetAccounts() {
+		for _, r := range acc.Route {
+			for _, f := range r.Folders {
+				fmt.Fprintf(&b, "[%s] %s\n", acc.Name, f.Name)
+				for i, rule := range f.Rules {
+					var dests []string
+					for _, d := range rule.Destinations() {
+						dests = append(dests, d.String())
+					}
+					fmt.Fprintf(&b, "%d. %s → %s", i+1, ruleTitle(rule), strings.Join(dests, ", "))
+					if rule.Mode == config.RuleModeDigest {
+						b.WriteString(" (digest)")
+					}
+					if rule.Continue {
+						b.WriteString(" (continue)")
+					}
+					b.WriteString("\n")
+				}
+				fmt.Fprintf(&b, "по умолчанию → %s\n\n", cfg.Telegram.DefaultChannel)
+			}
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// ruleTitle возвращает краткое описание правила: имя, шаблон темы или признак условий match
+func ruleTitle(rule config.Rule) string {
+	switch {
+	case rule.Name != "":
+		return rule.Name
+	case rule.Pattern != "" && rule.Match != nil:
+		return rule.Pattern + " + match"
+	case rule.Pattern != "":
+		return rule.Pattern
+	default:
+		return "match"
+	}
+}
+
+// allowed проверяет, что отправитель входит в telegram.allowed_users
+func allowed(cfg *config.Config, c tb.Context) bool {
+	return c.Sender() != nil && slices.Contains(cfg.Telegram.AllowedUsers, c.Sender().ID)
+}
+
+func senderID(c tb.Context) int64 {
+	if c.Sender() == nil {
+		return 0
+	}
+	return c.Sender().ID
+}
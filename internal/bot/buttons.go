@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/actions"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"go.uber.org/zap"
+	tb "gopkg.in/telebot.v3"
+)
+
+// onButton обрабатывает нажатие кнопки действия с письмом: выполняет действие через IMAP
+// и отмечает под сообщением, кто его выполнил
+func onButton(cfg *config.Config, c tb.Context, logger *zap.SugaredLogger) error {
+	if !allowed(cfg, c) {
+		logger.Warnw("button press from unauthorized user ignored", "user", senderID(c), "data", c.Data())
+		return c.Respond(&tb.CallbackResponse{Text: "Нет доступа"})
+	}
+
+	action, token, _ := strings.Cut(c.Data(), "|")
+	if action == telegram.ActionDone {
+		return c.Respond()
+	}
+	logger.Infow("button pressed", "user", c.Sender().ID, "action", action)
+
+	if action == config.ActionBody {
+		text, err := actions.Body(cfg, token, logger)
+		if err != nil {
+			logger.Errorw("failed to fetch email body", "error", err)
+			return c.Respond(&tb.CallbackResponse{Text: fmt.Sprintf("Ошибка: %v", err), ShowAlert: true})
+		}
+		if err := telegram.Reply(c.Message(), text, logger); err != nil {
+			logger.Errorw("failed to send email body", "error", err)
+			return c.Respond(&tb.CallbackResponse{Text: "Не удалось отправить текст письма", ShowAlert: true})
+		}
+		return c.Respond()
+	}
+
+	response := &tb.CallbackResponse{Text: "Готово"}
+	status := fmt.Sprintf("✔ %s — %s", actions.Label(action), senderName(c.Sender()))
+	err := actions.Do(cfg, action, token, logger)
+	switch {
+	case errors.Is(err, email.ErrNotExpunged):
+		// Письмо помечено \Deleted, но осталось в папке: EXPUNGE всей папки удалил бы чужие письма
+		logger.Warnw("email action incomplete", "action", action, "error", err)
+		status += " (помечено \\Deleted)"
+		response = &tb.CallbackResponse{
+			Text:      "Письмо помечено \\Deleted, но не удалено из папки: сервер не поддерживает UID EXPUNGE",
+			ShowAlert: true,
+		}
+	case errors.Is(err, email.ErrMessageNotFound):
+		logger.Warnw("email action failed", "action", action, "error", err)
+		return c.Respond(&tb.CallbackResponse{Text: "Ошибка: письмо не найдено в папке — оно перемещено или удалено", ShowAlert: true})
+	case err != nil:
+		logger.Errorw("email action failed", "action", action, "error", err)
+		return c.Respond(&tb.CallbackResponse{Text: fmt.Sprintf("Ошибка: %v", err), ShowAlert: true})
+	}
+
+	// После архивации и удаления UID письма в папке больше недействителен
+	keep := action != config.ActionArchive && action != config.ActionDelete
+	markup := telegram.ActedKeyboard(c.Message().ReplyMarkup, action, status, keep)
+	if _, err := telegram.Bot.EditReplyMarkup(c.Message(), markup); err != nil {
+		logger.Warnw("failed to update message buttons", "error", err)
+	}
+	return c.Respond(response)
+}
+
+// senderName возвращает имя пользователя Telegram для отметки о действии
+func senderName(u *tb.User) string {
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
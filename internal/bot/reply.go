@@ -0,0 +1,36 @@
+package bot
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/reply"
+	"go.uber.org/zap"
+	tb "gopkg.in/telebot.v3"
+)
+
+// onText отправляет ответ на письмо через SMTP, если сообщение — ответ на пересланное письмо
+// из правила с reply: true. Отвечать могут только пользователи из smtp.allowed_users.
+func onText(cfg *config.Config, c tb.Context, logger *zap.SugaredLogger) error {
+	msg := c.Message()
+	if msg == nil || msg.ReplyTo == nil {
+		return nil
+	}
+	orig, ok := reply.Lookup(msg.Chat.ID, msg.ReplyTo.ID)
+	if !ok {
+		return nil
+	}
+	if c.Sender() == nil || !slices.Contains(cfg.SMTP.AllowedUsers, c.Sender().ID) {
+		logger.Warnw("email reply from unauthorized user ignored", "user", senderID(c), "ref", orig.Ref)
+		return nil
+	}
+
+	text := fmt.Sprintf("%s\n\n-- \n%s (Telegram)", msg.Text, senderName(c.Sender()))
+	if err := reply.Send(cfg.SMTP, orig, text); err != nil {
+		logger.Errorw("failed to send email reply", "user", c.Sender().ID, "ref", orig.Ref, "error", err)
+		return c.Reply(fmt.Sprintf("Не удалось отправить ответ: %v", err))
+	}
+	logger.Infow("email reply sent", "user", c.Sender().ID, "ref", orig.Ref, "to", orig.To)
+	return c.Reply(fmt.Sprintf("Ответ отправлен на %s", orig.To))
+}
@@ -0,0 +1,197 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/state"
+	"github.com/st-kuptsov/mail2tg/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// retention — срок хранения отпечатков, не меньше наибольшего окна
+const retention = config.MaxDedupWindow * time.Second
+
+// entry — последнее доставленное письмо с данным отпечатком
+type entry struct {
+	Ref         string    `json:"ref"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	// Suppressed — повторов подавлено после доставки Ref
+	Suppressed int `json:"suppressed,omitempty"`
+	// Reported — повторов, подавленных до доставки Ref; указывается в сообщении о нём
+	Reported int `json:"reported,omitempty"`
+	// Counted — ссылки на подавленные повторы, чтобы повторная попытка доставки
+	// того же письма не увеличивала Suppressed
+	Counted []string `json:"counted,omitempty"`
+}
+
+var (
+	mu       sync.Mutex
+	index    *state.Index
+	patterns = make(map[string]*regexp.Regexp)
+)
+
+// Open открывает хранилище отпечатков доставленных писем
+func Open(path string) error {
+	idx, err := state.OpenIndex(path, retention)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	index = idx
+	mu.Unlock()
+	return nil
+}
+
+// RuleKey возвращает идентификатор правила для отпечатков: имя правила, если оно задано,
+// иначе его номер в папке. По имени окно подавления не сбрасывается при добавлении
+// или перестановке правил.
+func RuleKey(rule config.Rule, index int) string {
+	if rule.Name != "" {
+		return "name:" + rule.Name
+	}
+	return strconv.Itoa(index)
+}
+
+// Check проверяет, повторяет ли письмо msg письмо, доставленное по правилу rule той же
+// папки в течение окна cfg.Window. Повтор подсчитывается один раз и должен быть подавлен.
+// Для остальных писем возвращает количество повторов, подавленных с прошлой доставки:
+// оно указывается в сообщении. Письмо считается доставленным только после вызова Record,
+// поэтому копии письма, доставка которого не удалась, не подавляются.
+// Повторная попытка доставки того же письма повтором не считается.
+func Check(cfg config.DedupConfig, msg email.Decoded, rule string, logger *zap.SugaredLogger) (duplicate bool, suppressed int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	id, ok := entryID(cfg, msg, rule)
+	if !ok {
+		return false, 0
+	}
+	e := load(id)
+
+	switch {
+	case e.Ref == msg.Ref:
+		return false, e.Reported
+	case e.Ref != "" && time.Since(e.DeliveredAt) < time.Duration(cfg.Window)*time.Second:
+		if !slices.Contains(e.Counted, msg.Ref) {
+			e.Suppressed++
+			e.Counted = append(e.Counted, msg.Ref)
+			metrics.MailSuppressed.Inc()
+			save(id, e, logger)
+		}
+		return true, e.Reported
+	default:
+		return false, e.Suppressed
+	}
+}
+
+// Record запоминает письмо msg доставленным по правилу rule: его копии в течение окна
+// cfg.Window будут подавлены. Вызывается после подтверждённой доставки во все каналы правила.
+func Record(cfg config.DedupConfig, msg email.Decoded, rule string, logger *zap.SugaredLogger) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	id, ok := entryID(cfg, msg, rule)
+	if !ok {
+		return
+	}
+	e := load(id)
+	if e.Ref == msg.Ref {
+		return
+	}
+	save(id, entry{Ref: msg.Ref, DeliveredAt: time.Now(), Reported: e.Suppressed}, logger)
+}
+
+// entryID возвращает идентификатор отпечатка письма в хранилище; false, если подавление
+// повторов выключено или хранилище недоступно. Вызывается под блокировкой.
+func entryID(cfg config.DedupConfig, msg email.Decoded, rule string) (string, bool) {
+	if cfg.Window <= 0 || msg.Ref == "" || index == nil {
+		return "", false
+	}
+	key, _, err := state.ParseRef(msg.Ref)
+	if err != nil {
+		return "", false
+	}
+	return strings.Join([]string{key.Account, key.Folder, rule, fingerprint(cfg, msg)}, "|"), true
+}
+
+// load читает запись об отпечатке. Вызывается под блокировкой.
+func load(id string) entry {
+	var e entry
+	if data, ok := index.Get(id); ok {
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return entry{}
+		}
+	}
+	return e
+}
+
+// save сохраняет запись об отпечатке. Вызывается под блокировкой.
+func save(id string, e entry, logger *zap.SugaredLogger) {
+	data, err := json.Marshal(e)
+	if err == nil {
+		err = index.Put(id, string(data))
+	}
+	if err != nil {
+		logger.Warnw("cannot save email fingerprint", "error", err)
+	}
+}
+
+// fingerprint вычисляет отпечаток письма по полям cfg.Fingerprint.
+// Если Message-ID отсутствует, письма сравниваются по теме, отправителю и тексту.
+func fingerprint(cfg config.DedupConfig, msg email.Decoded) string {
+	fields := cfg.Fingerprint
+	if len(fields) == 0 {
+		fields = []string{config.FingerprintMessageID}
+	}
+	messageID := strings.TrimSpace(msg.Header.Get("Message-ID"))
+	for _, f := range fields {
+		if f == config.FingerprintMessageID && messageID == "" {
+			fields = []string{config.FingerprintSubject, config.FingerprintFrom, config.FingerprintBody}
+			break
+		}
+	}
+
+	h := sha256.New()
+	for _, f := range fields {
+		var v string
+		switch f {
+		case config.FingerprintMessageID:
+			v = messageID
+		case config.FingerprintSubject:
+			v = normalize(cfg.Normalize, msg.Subject)
+		case config.FingerprintFrom:
+			v = normalize(cfg.Normalize, msg.From)
+		case config.FingerprintBody:
+			v = normalize(cfg.Normalize, msg.Body)
+		}
+		h.Write([]byte(f + "=" + v + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// normalize удаляет из значения совпадения с регулярными выражениями и лишние пробелы.
+// Вызывается под блокировкой.
+func normalize(exprs []string, v string) string {
+	for _, expr := range exprs {
+		re, ok := patterns[expr]
+		if !ok {
+			var err error
+			if re, err = regexp.Compile(expr); err != nil {
+				continue
+			}
+			patterns[expr] = re
+		}
+		v = re.ReplaceAllString(v, "")
+	}
+	return strings.Join(strings.Fields(v), " ")
+}
@@ -0,0 +1,183 @@
+package dedup
+
+import (
+	"net/mail"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"go.uber.org/zap"
+)
+
+func openStore(t *testing.T) {
+	t.Helper()
+	if err := Open(filepath.Join(t.TempDir(), "dedup.json")); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+}
+
+// alert возвращает копию алерта с UID uid
+func alert(uid, messageID, subject string) email.Decoded {
+	h := mail.Header{}
+	if messageID != "" {
+		h["Message-Id"] = []string{messageID}
+	}
+	return email.Decoded{
+		Ref:     "work|INBOX|1|" + uid,
+		Header:  h,
+		Subject: subject,
+		From:    "monitor@example.com",
+		Body:    "disk is full",
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	byContent := config.DedupConfig{Fingerprint: []string{config.FingerprintSubject, config.FingerprintFrom}}
+	normalized := config.DedupConfig{
+		Fingerprint: []string{config.FingerprintSubject},
+		Normalize:   []string{`\d{2}:\d{2}`, `#\d+`},
+	}
+	tests := []struct {
+		name string
+		cfg  config.DedupConfig
+		a, b email.Decoded
+		same bool
+	}{
+		{name: "same message-id", a: alert("1", "<a@x>", "one"), b: alert("2", "<a@x>", "two"), same: true},
+		{name: "different message-id", a: alert("1", "<a@x>", "one"), b: alert("2", "<b@x>", "one")},
+		{name: "no message-id falls back to content", a: alert("1", "", "one"), b: alert("2", "", "one"), same: true},
+		{name: "no message-id different subject", a: alert("1", "", "one"), b: alert("2", "", "two")},
+		{name: "content ignores message-id", cfg: byContent, a: alert("1", "<a@x>", "one"), b: alert("2", "<b@x>", "one"), same: true},
+		{name: "whitespace collapsed", cfg: byContent, a: alert("1", "", "disk  full"), b: alert("2", "", " disk full "), same: true},
+		{name: "normalized time and id", cfg: normalized, a: alert("1", "", "Alert #12 at 10:15"), b: alert("2", "", "Alert #98 at 23:40"), same: true},
+		{name: "normalized still differs", cfg: normalized, a: alert("1", "", "Alert at 10:15 on db1"), b: alert("2", "", "Alert at 10:15 on db2")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := fingerprint(tt.cfg, tt.a), fingerprint(tt.cfg, tt.b)
+			if (a == b) != tt.same {
+				t.Errorf("fingerprints equal = %v, want %v", a == b, tt.same)
+			}
+		})
+	}
+}
+
+func TestCheckAndRecord(t *testing.T) {
+	cfg := config.DedupConfig{Window: 3600}
+	logger := zap.NewNop().Sugar()
+	type step struct {
+		op        string // check, record или expire
+		uid       string
+		rule      string
+		duplicate bool
+		// suppressed — счётчик, который возвращает check
+		suppressed int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "copy after delivery suppressed",
+			steps: []step{
+				{op: "check", uid: "1", rule: "r"},
+				{op: "record", uid: "1", rule: "r"},
+				{op: "check", uid: "2", rule: "r", duplicate: true},
+			},
+		},
+		{
+			// Копия письма, доставка которого не подтверждена, не подавляется
+			name: "not recorded without delivery",
+			steps: []step{
+				{op: "check", uid: "1", rule: "r"},
+				{op: "check", uid: "2", rule: "r"},
+			},
+		},
+		{
+			name: "retry of delivered message is not a duplicate",
+			steps: []step{
+				{op: "record", uid: "1", rule: "r"},
+				{op: "check", uid: "1", rule: "r"},
+			},
+		},
+		{
+			name: "retries of a suppressed copy counted once",
+			steps: []step{
+				{op: "record", uid: "1", rule: "r"},
+				{op: "check", uid: "2", rule: "r", duplicate: true},
+				{op: "check", uid: "2", rule: "r", duplicate: true},
+				{op: "check", uid: "2", rule: "r", duplicate: true},
+				{op: "check", uid: "3", rule: "r", duplicate: true},
+				// Окно истекло: следующее письмо сообщает о двух подавленных копиях
+				{op: "expire", uid: "1", rule: "r"},
+				{op: "check", uid: "4", rule: "r", suppressed: 2},
+				{op: "record", uid: "4", rule: "r"},
+				{op: "check", uid: "4", rule: "r", suppressed: 2},
+				{op: "check", uid: "5", rule: "r", duplicate: true, suppressed: 2},
+			},
+		},
+		{
+			name: "rules counted separately",
+			steps: []step{
+				{op: "record", uid: "1", rule: "a"},
+				{op: "check", uid: "2", rule: "b"},
+				{op: "check", uid: "2", rule: "a", duplicate: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openStore(t)
+			for i, s := range tt.steps {
+				msg := alert(s.uid, "<same@x>", "disk full")
+				switch s.op {
+				case "record":
+					Record(cfg, msg, s.rule, logger)
+				case "expire":
+					id, _ := entryID(cfg, msg, s.rule)
+					e := load(id)
+					e.DeliveredAt = e.DeliveredAt.Add(-2 * time.Hour)
+					save(id, e, logger)
+				case "check":
+					duplicate, suppressed := Check(cfg, msg, s.rule, logger)
+					if duplicate != s.duplicate || suppressed != s.suppressed {
+						t.Fatalf("step %d: Check(uid %s) = %v, %d; want %v, %d", i, s.uid, duplicate, suppressed, s.duplicate, s.suppressed)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCheckDisabled(t *testing.T) {
+	openStore(t)
+	logger := zap.NewNop().Sugar()
+	cfg := config.DedupConfig{}
+	Record(cfg, alert("1", "<a@x>", "x"), "r", logger)
+	if duplicate, _ := Check(cfg, alert("2", "<a@x>", "x"), "r", logger); duplicate {
+		t.Error("copy suppressed with dedup disabled")
+	}
+}
+
+func TestRuleKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  config.Rule
+		index int
+		want  string
+	}{
+		{name: "named rule", rule: config.Rule{Name: "alerts"}, index: 3, want: "name:alerts"},
+		{name: "named rule moved", rule: config.Rule{Name: "alerts"}, index: 1, want: "name:alerts"},
+		{name: "unnamed rule", index: 2, want: "2"},
+		{name: "numeric name does not clash with index", rule: config.Rule{Name: "2"}, index: 5, want: "name:2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RuleKey(tt.rule, tt.index); got != tt.want {
+				t.Errorf("RuleKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package digest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/state"
+	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"go.uber.org/zap"
+)
+
+const (
+	// flushInterval — как часто проверяются сводки, которым пора отправляться
+	flushInterval = 15 * time.Second
+	// retryInterval — через сколько повторяется отправка сводки после ошибки
+	retryInterval = time.Minute
+	// maxSenders — сколько отправителей показывать для одной темы
+	maxSenders = 3
+)
+
+// item — письмо в сводке
+type item struct {
+	Ref     string `json:"ref"`
+	Subject string `json:"subject"`
+	From    string `json:"from"`
+}
+
+// bucket — сводка писем одного правила, ожидающая отправки
+type bucket struct {
+	Title        string               `json:"title"`
+	Destinations []config.Destination `json:"destinations"`
+	Silent       bool                 `json:"silent,omitempty"`
+	Protected    bool                 `json:"protected,omitempty"`
+	Schedule     config.DigestConfig  `json:"schedule"`
+	Since        time.Time            `json:"since"`
+	Due          time.Time            `json:"due"`
+	Items        []item               `json:"items"`
+	// Sent — получатели, которым сводка уже отправлена; при повторе они пропускаются
+	Sent []string `json:"sent,omitempty"`
+
+	// sending — сводка отправляется и не выбирается повторно
+	sending bool
+}
+
+// store — сводки на диске по ключу правила
+type store struct {
+	mu      sync.Mutex
+	path    string
+	buckets map[string]*bucket
+}
+
+var digests *store
+
+// Open загружает сводки, ожидающие отправки
+func Open(path string) error {
+	s := &store{path: path, buckets: make(map[string]*bucket)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot read digest file: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.buckets); err != nil {
+			return fmt.Errorf("cannot parse digest file: %w", err)
+		}
+	}
+	digests = s
+	return nil
+}
+
+// Add добавляет письмо msg в сводку правила rule (номер n в папке folder).
+// Расписание и получатели берутся из текущей конфигурации правила.
+func Add(rule config.Rule, n int, folder string, msg email.Decoded) error {
+	if digests == nil {
+		return fmt.Errorf("digest store is not opened")
+	}
+	key, _, err := state.ParseRef(msg.Ref)
+	if err != nil {
+		return err
+	}
+	id := fmt.Sprintf("%s|%s|%d", key.Account, key.Folder, n)
+
+	title := rule.Name
+	if title == "" {
+		title = rule.Pattern
+	}
+	if title == "" {
+		title = fmt.Sprintf("правило #%d", n)
+	}
+
+	digests.mu.Lock()
+	defer digests.mu.Unlock()
+
+	b, ok := digests.buckets[id]
+	if !ok {
+		b = &bucket{Since: time.Now()}
+		digests.buckets[id] = b
+	}
+	if !ok || b.Schedule != *rule.Digest {
+		b.Schedule = *rule.Digest
+		b.Due = due(b.Schedule, b.Since)
+	}
+	b.Title = fmt.Sprintf("[%s] %s — %s", key.Account, folder, title)
+	b.Destinations = rule.Destinations()
+	b.Silent, b.Protected = rule.DisableNotification, rule.ProtectContent
+
+	if !slices.ContainsFunc(b.Items, func(it item) bool { return it.Ref == msg.Ref }) {
+		b.Items = append(b.Items, item{Ref: msg.Ref, Subject: msg.Subject, From: msg.From})
+	}
+	return digests.save()
+}
+
+// Start периодически отправляет сводки, время которых наступило
+func Start(ctx context.Context, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for id, b := range digests.takeDue(now) {
+				go send(id, b, logger)
+			}
+		}
+	}
+}
+
+// takeDue возвращает копии сводок, время отправки которых наступило, и отмечает их отправляемыми.
+// Сводки остаются в хранилище до подтверждённой отправки, чтобы не потеряться при сбое.
+func (s *store) takeDue(now time.Time) map[string]bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taken := make(map[string]bucket)
+	for id, b := range s.buckets {
+		if b.sending || b.Due.IsZero() || now.Before(b.Due) {
+			continue
+		}
+		b.sending = true
+		c := *b
+		c.Items, c.Sent = slices.Clone(b.Items), slices.Clone(b.Sent)
+		taken[id] = c
+	}
+	return taken
+}
+
+// finish фиксирует результат отправки сводки sent получателям delivered.
+// После отправки всем получателям отправленные письма удаляются из сводки; письма,
+// добавленные во время отправки, остаются в следующей сводке. После ошибки отправка
+// повторяется через retryInterval только для оставшихся получателей.
+func (s *store) finish(id string, sent bucket, delivered []string, complete bool, logger *zap.SugaredLogger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[id]
+	if !ok {
+		return
+	}
+	b.sending = false
+	if complete {
+		b.Items = slices.DeleteFunc(b.Items, func(it item) bool {
+			return slices.ContainsFunc(sent.Items, func(s item) bool { return s.Ref == it.Ref })
+		})
+		if len(b.Items) == 0 {
+			delete(s.buckets, id)
+		} else {
+			b.Since, b.Sent = time.Now(), nil
+			b.Due = due(b.Schedule, b.Since)
+		}
+	} else {
+		b.Sent = delivered
+		b.Due = time.Now().Add(retryInterval)
+	}
+	if err := s.save(); err != nil {
+		logger.Errorw("failed to save digest store", "error", err)
+	}
+}
+
+// send отправляет сводку всем получателям правила через очередь Telegram
+func send(id string, b bucket, logger *zap.SugaredLogger) {
+	text := summary(&b, time.Now())
+	delivered, complete := b.Sent, true
+	for _, d := range b.Destinations {
+		if slices.Contains(delivered, d.String()) {
+			continue
+		}
+		_, err := telegram.Deliver(telegram.Message{
+			Channel:             d.ChatID,
+			ThreadID:            d.ThreadID,
+			Text:                text,
+			DisableNotification: b.Silent,
+			Protected:           b.Protected,
+			Ref:                 fmt.Sprintf("digest|%s|%d|%s", id, b.Since.Unix(), d.String()),
+		}, logger)
+		if err != nil {
+			logger.Errorw("failed to send digest", "digest", id, "channel", d.String(), "error", err)
+			complete = false
+			continue
+		}
+		delivered = append(delivered, d.String())
+		logger.Infow("digest sent", "digest", id, "channel", d.String(), "emails", len(b.Items))
+	}
+	digests.finish(id, b, delivered, complete, logger)
+}
+
+// summary формирует текст сводки: темы писем с количеством и отправителями
+func summary(b *bucket, now time.Time) string {
+	type group struct {
+		subject string
+		count   int
+		senders []string
+	}
+	var groups []*group
+	bySubject := make(map[string]*group)
+	for _, it := range b.Items {
+		g, ok := bySubject[it.Subject]
+		if !ok {
+			g = &group{subject: it.Subject}
+			bySubject[it.Subject] = g
+			groups = append(groups, g)
+		}
+		g.count++
+		if !slices.Contains(g.senders, it.From) {
+			g.senders = append(g.senders, it.From)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].count > groups[j].count })
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📬 Сводка: %s\n", b.Title)
+	fmt.Fprintf(&sb, "Писем: %d с %s по %s\n\n", len(b.Items), b.Since.Format("02.01 15:04"), now.Format("02.01 15:04"))
+	for _, g := range groups {
+		subject := g.subject
+		if subject == "" {
+			subject = "(без темы)"
+		}
+		senders := g.senders
+		more := ""
+		if len(senders) > maxSenders {
+			more = fmt.Sprintf(" и ещё %d", len(senders)-maxSenders)
+			senders = senders[:maxSenders]
+		}
+		fmt.Fprintf(&sb, "• %s — %d (%s%s)\n", subject, g.count, strings.Join(senders, ", "), more)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// due возвращает время отправки сводки, начатой в since
+func due(cfg config.DigestConfig, since time.Time) time.Time {
+	if cfg.Cron != "" {
+		if s, err := config.ParseSchedule(cfg.Cron); err == nil {
+			return s.Next(since)
+		}
+	}
+	return since.Add(time.Duration(cfg.Interval) * time.Minute)
+}
+
+// save атомарно записывает сводки на диск. Вызывается под блокировкой.
+func (s *store) save() error {
+	data, err := json.Marshal(s.buckets)
+	if err != nil {
+		return fmt.Errorf("cannot encode digest store: %w", err)
+	}
+
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("cannot create digest directory: %w", err)
+		}
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("cannot write digest file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("cannot replace digest file: %w", err)
+	}
+	return nil
+}
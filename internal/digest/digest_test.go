@@ -0,0 +1,177 @@
+package digest
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"go.uber.org/zap"
+)
+
+func openStore(t *testing.T) *store {
+	t.Helper()
+	if err := Open(filepath.Join(t.TempDir(), "digest.json")); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return digests
+}
+
+func items(refs ...string) []item {
+	var its []item
+	for _, ref := range refs {
+		its = append(its, item{Ref: ref, Subject: "s " + ref})
+	}
+	return its
+}
+
+func refs(its []item) []string {
+	var r []string
+	for _, it := range its {
+		r = append(r, it.Ref)
+	}
+	return r
+}
+
+func keys(m map[string]bucket) []string {
+	var ids []string
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestTakeDue(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	s := openStore(t)
+	s.buckets = map[string]*bucket{
+		"due":     {Due: now.Add(-time.Minute), Items: items("a"), Sent: []string{"1"}},
+		"exact":   {Due: now, Items: items("b")},
+		"later":   {Due: now.Add(time.Minute), Items: items("c")},
+		"no due":  {Items: items("d")},
+		"sending": {Due: now.Add(-time.Hour), Items: items("e"), sending: true},
+	}
+
+	taken := s.takeDue(now)
+	if got := keys(taken); !reflect.DeepEqual(got, []string{"due", "exact"}) {
+		t.Fatalf("takeDue = %v, want [due exact]", got)
+	}
+	if !s.buckets["due"].sending || !s.buckets["exact"].sending || s.buckets["later"].sending {
+		t.Error("taken buckets are not marked as sending")
+	}
+	// Отправляемые сводки не выбираются повторно
+	if got := keys(s.takeDue(now.Add(time.Hour))); !reflect.DeepEqual(got, []string{"later"}) {
+		t.Errorf("second takeDue = %v, want [later]", got)
+	}
+
+	// Копия не делит срезы со сводкой в хранилище
+	c := taken["due"]
+	c.Items[0].Ref = "changed"
+	c.Sent[0] = "changed"
+	if b := s.buckets["due"]; b.Items[0].Ref != "a" || b.Sent[0] != "1" {
+		t.Errorf("copy shares slices with the stored bucket: %+v", b)
+	}
+}
+
+func TestFinish(t *testing.T) {
+	schedule := config.DigestConfig{Interval: 60}
+	tests := []struct {
+		name string
+		// added — письма, добавленные в сводку во время отправки
+		added     []string
+		delivered []string
+		complete  bool
+		removed   bool
+		wantItems []string
+		wantSent  []string
+		// wantDue — через сколько после завершения назначена следующая отправка
+		wantDue time.Duration
+	}{
+		{name: "complete", delivered: []string{"1", "2"}, complete: true, removed: true},
+		{
+			name:      "complete with new mail",
+			added:     []string{"c"},
+			delivered: []string{"1", "2"},
+			complete:  true,
+			wantItems: []string{"c"},
+			wantDue:   time.Hour,
+		},
+		{
+			name:      "partial",
+			delivered: []string{"1"},
+			wantItems: []string{"a", "b"},
+			wantSent:  []string{"1"},
+			wantDue:   retryInterval,
+		},
+		{
+			name:      "failed with new mail",
+			added:     []string{"c"},
+			wantItems: []string{"a", "b", "c"},
+			wantDue:   retryInterval,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openStore(t)
+			s.buckets["r"] = &bucket{Schedule: schedule, Since: time.Now().Add(-2 * time.Hour), Due: time.Now().Add(-time.Hour), Items: items("a", "b")}
+			sent := s.takeDue(time.Now())["r"]
+			b := s.buckets["r"]
+			b.Items = append(b.Items, items(tt.added...)...)
+
+			before := time.Now()
+			s.finish("r", sent, tt.delivered, tt.complete, zap.NewNop().Sugar())
+
+			b, ok := s.buckets["r"]
+			if ok == tt.removed {
+				t.Fatalf("bucket kept = %v, want %v", ok, !tt.removed)
+			}
+			if tt.removed {
+				return
+			}
+			if b.sending {
+				t.Error("bucket is still marked as sending")
+			}
+			if got := refs(b.Items); !reflect.DeepEqual(got, tt.wantItems) {
+				t.Errorf("items = %v, want %v", got, tt.wantItems)
+			}
+			if !reflect.DeepEqual(b.Sent, tt.wantSent) {
+				t.Errorf("sent = %v, want %v", b.Sent, tt.wantSent)
+			}
+			if d := b.Due.Sub(before); d < tt.wantDue || d > tt.wantDue+time.Second {
+				t.Errorf("next send in %v, want %v", d, tt.wantDue)
+			}
+
+			// Повторная отправка выбирает сводку снова только после назначенного времени
+			if len(s.takeDue(time.Now())) != 0 || len(s.takeDue(b.Due)) != 1 {
+				t.Error("bucket is not taken again at its due time")
+			}
+		})
+	}
+}
+
+func TestFinishPersists(t *testing.T) {
+	s := openStore(t)
+	s.buckets["r"] = &bucket{Schedule: config.DigestConfig{Interval: 60}, Due: time.Now(), Items: items("a", "b")}
+	sent := s.takeDue(time.Now())["r"]
+	s.finish("r", sent, []string{"1"}, false, zap.NewNop().Sugar())
+
+	path := s.path
+	if err := Open(path); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	b := digests.buckets["r"]
+	if b == nil || b.sending || !reflect.DeepEqual(b.Sent, []string{"1"}) || len(b.Items) != 2 {
+		t.Errorf("reopened bucket = %+v, want 2 items sent to 1", b)
+	}
+}
+
+func TestFinishRemovedBucket(t *testing.T) {
+	s := openStore(t)
+	s.finish("missing", bucket{Items: items("a")}, nil, true, zap.NewNop().Sugar())
+	if len(s.buckets) != 0 {
+		t.Errorf("finish created bucket: %+v", s.buckets)
+	}
+}
@@ -0,0 +1,142 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"slices"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/state"
+	"go.uber.org/zap"
+)
+
+// AccountKey возвращает идентификатор почтового ящика, используемый в состоянии доставки.
+// Имя учётной записи не меняется при смене логина или сервера.
+func AccountKey(acc config.Account) string {
+	return acc.Name
+}
+
+// LegacyAccountKey возвращает идентификатор username@host, которым учётные записи
+// обозначались в состоянии и ссылках на письма прежних версий
+func LegacyAccountKey(acc config.Account) string {
+	return fmt.Sprintf("%s@%s", acc.IMAP.Username, acc.IMAP.Host)
+}
+
+// FindAccount ищет учётную запись по идентификатору почтового ящика из ссылки на письмо.
+// Ссылки, сохранённые прежними версиями, сопоставляются по username@host.
+func FindAccount(cfg *config.Config, key string) (config.Account, bool) {
+	accounts := cfg.GetAccounts()
+	for _, acc := range accounts {
+		if AccountKey(acc) == key {
+			return acc, true
+		}
+	}
+	for _, acc := range accounts {
+		if LegacyAccountKey(acc) == key {
+			return acc, true
+		}
+	}
+	return config.Account{}, false
+}
+
+// ApplyAction выполняет действие read, flag, archive или delete над письмом с указанным UID.
+// Удаление и архивация без поддержки MOVE удаляют из папки только это письмо командой UID EXPUNGE;
+// если сервер её не поддерживает, письмо остаётся помеченным \Deleted и возвращается ErrNotExpunged.
+func ApplyAction(acc config.Account, key state.Key, uid uint32, action, archiveFolder string, logger *zap.SugaredLogger) error {
+	return withMessage(acc, key, uid, logger, func(c *client.Client, seqset *imap.SeqSet) error {
+		addFlags := imap.FormatFlagsOp(imap.AddFlags, true)
+		switch action {
+		case config.ActionRead:
+			return c.UidStore(seqset, addFlags, []interface{}{imap.SeenFlag}, nil)
+		case config.ActionFlag:
+			return c.UidStore(seqset, addFlags, []interface{}{imap.FlaggedFlag}, nil)
+		case config.ActionArchive:
+			return moveMessages(c, seqset, archiveFolder)
+		case config.ActionDelete:
+			return deleteMessages(c, seqset)
+		default:
+			return fmt.Errorf("unknown action %q", action)
+		}
+	})
+}
+
+// FetchMessage заново получает письмо с указанным UID без изменения флага \Seen
+func FetchMessage(acc config.Account, key state.Key, uid uint32, logger *zap.SugaredLogger) (Message, error) {
+	var result Message
+	err := withMessage(acc, key, uid, logger, func(c *client.Client, seqset *imap.SeqSet) error {
+		section := &imap.BodySectionName{Peek: true}
+		messages := make(chan *imap.Message, 1)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.UidFetch(seqset, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messages)
+		}()
+
+		var raw []byte
+		for msg := range messages {
+			if r := msg.GetBody(section); r != nil {
+				data, err := io.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				raw = data
+			}
+		}
+		if err := <-done; err != nil {
+			return err
+		}
+		if raw == nil {
+			return fmt.Errorf("message %d not found in %s", uid, key.Folder)
+		}
+
+		m, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+		result = Message{UID: uid, Message: m, Raw: raw}
+		return nil
+	})
+	return result, err
+}
+
+// ErrMessageNotFound возвращается, если письма с сохранённым UID больше нет в папке:
+// оно перемещено или удалено
+var ErrMessageNotFound = errors.New("message is no longer in the folder")
+
+// withMessage подключается к почтовому ящику, выбирает папку письма и вызывает f.
+// Если UIDVALIDITY папки изменился или письма с таким UID в папке нет, f не вызывается:
+// команды IMAP с отсутствующим UID завершаются успешно, ничего не сделав.
+func withMessage(acc config.Account, key state.Key, uid uint32, logger *zap.SugaredLogger, f func(c *client.Client, seqset *imap.SeqSet) error) error {
+	c, err := ConnectToIMAP(acc, logger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := c.Logout(); err != nil {
+			logger.Debugw("IMAP logout failed", "error", err)
+		}
+	}()
+
+	mbox, err := c.Select(key.Folder, false)
+	if err != nil {
+		return fmt.Errorf("failed to select folder: %w", err)
+	}
+	if mbox.UidValidity != key.UIDValidity {
+		return fmt.Errorf("folder %s was recreated, message %d is no longer available", key.Folder, uid)
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+	found, err := c.UidSearch(&imap.SearchCriteria{Uid: seqset})
+	if err != nil {
+		return fmt.Errorf("failed to find message: %w", err)
+	}
+	if !slices.Contains(found, uid) {
+		return fmt.Errorf("message %d in %s: %w", uid, key.Folder, ErrMessageNotFound)
+	}
+	return f(c, seqset)
+}
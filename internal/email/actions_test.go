@@ -0,0 +1,34 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/st-kuptsov/mail2tg/config"
+)
+
+func TestFindAccount(t *testing.T) {
+	cfg := &config.Config{Accounts: []config.Account{
+		{Name: "work", IMAP: config.IMAPConfig{Username: "user", Host: "imap.example.com"}},
+		{Name: "user@imap.other.com", IMAP: config.IMAPConfig{Username: "user", Host: "imap.other.com"}},
+		{Name: "home", IMAP: config.IMAPConfig{Username: "user", Host: "imap.other.com"}},
+	}}
+	tests := []struct {
+		name string
+		key  string
+		want string
+		ok   bool
+	}{
+		{name: "by name", key: "work", want: "work", ok: true},
+		{name: "legacy username@host", key: "user@imap.example.com", want: "work", ok: true},
+		{name: "name wins over legacy key", key: "user@imap.other.com", want: "user@imap.other.com", ok: true},
+		{name: "unknown", key: "nobody", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc, ok := FindAccount(cfg, tt.key)
+			if ok != tt.ok || acc.Name != tt.want {
+				t.Errorf("FindAccount(%q) = %q, %v; want %q, %v", tt.key, acc.Name, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -10,37 +10,72 @@ import (
 	"time"
 )
 
-// ConnectToIMAP подключается к IMAP-серверу по TLS с таймаутами и возвращает клиента
-func ConnectToIMAP(cfg *config.Config, logger *zap.SugaredLogger) (*client.Client, error) {
-	addr := fmt.Sprintf("%s:%d", cfg.IMAP.Host, cfg.IMAP.Port)
-	logger.Infow("connecting to IMAP server", "address", addr)
+// ConnectToIMAP подключается к IMAP-серверу с таймаутами и возвращает клиента.
+// Соединение защищается неявным TLS, командой STARTTLS или не защищается — по imap.security.
+func ConnectToIMAP(acc config.Account, logger *zap.SugaredLogger) (*client.Client, error) {
+	addr := fmt.Sprintf("%s:%d", acc.IMAP.Host, acc.IMAP.Port)
+	security := acc.IMAP.SecurityMode()
+	logger.Infow("connecting to IMAP server", "account", acc.Name, "address", addr, "security", security)
 
-	// создаем Dialer с таймаутом
+	tlsConfig, err := acc.IMAP.TLSConfig()
+	if err != nil {
+		logger.Errorw("invalid IMAP TLS settings", "error", err)
+		return nil, fmt.Errorf("invalid IMAP TLS settings: %w", err)
+	}
+
+	// Таймаут действует на установку соединения, TLS и приветствие сервера
 	dialer := &net.Dialer{
-		Timeout: 10 * time.Second, // время на установку соединения
+		Timeout: acc.IMAP.DialTimeoutDuration(),
 	}
 
-	// создаем TLS-коннект с таймаутом
-	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{})
+	var c *client.Client
+	if security == config.IMAPSecurityTLS {
+		c, err = client.DialWithDialerTLS(dialer, addr, tlsConfig)
+	} else {
+		c, err = client.DialWithDialer(dialer, addr)
+	}
 	if err != nil {
 		logger.Errorw("failed to connect to IMAP server", "error", err)
 		return nil, fmt.Errorf("failed to connect to IMAP: %w", err)
 	}
 
-	// создаем IMAP-клиент поверх уже установленного соединения
-	c, err := client.New(conn)
-	if err != nil {
-		logger.Errorw("failed to create IMAP client", "error", err)
-		return nil, fmt.Errorf("failed to create IMAP client: %w", err)
+	switch security {
+	case config.IMAPSecurityStartTLS:
+		if err := startTLS(c, tlsConfig, dialer.Timeout); err != nil {
+			logger.Errorw("IMAP STARTTLS failed", "error", err)
+			return nil, err
+		}
+	case config.IMAPSecurityNone:
+		logger.Warnw("IMAP connection is not encrypted", "account", acc.Name)
 	}
+	c.Timeout = acc.IMAP.ReadTimeoutDuration()
 
 	logger.Info("IMAP connection established")
 
-	if err := c.Login(cfg.IMAP.Username, cfg.IMAP.Password); err != nil {
+	if err := login(c, acc, logger); err != nil {
 		logger.Errorw("IMAP login failed", "error", err)
+		c.Terminate()
 		return nil, fmt.Errorf("IMAP login failed: %w", err)
 	}
 
-	logger.Infow("IMAP login successful", "username", cfg.IMAP.Username)
+	logger.Infow("IMAP login successful", "account", acc.Name, "username", acc.IMAP.Username)
 	return c, nil
 }
+
+// startTLS переводит открытое соединение на TLS командой STARTTLS.
+// На проверку поддержки и рукопожатие отводится timeout. При ошибке соединение закрывается.
+func startTLS(c *client.Client, tlsConfig *tls.Config, timeout time.Duration) error {
+	c.Timeout = timeout
+	ok, err := c.SupportStartTLS()
+	if err == nil && !ok {
+		err = fmt.Errorf("server does not support STARTTLS")
+	}
+	if err == nil {
+		err = c.StartTLS(tlsConfig)
+	}
+	if err != nil {
+		c.Terminate()
+		return fmt.Errorf("IMAP STARTTLS failed: %w", err)
+	}
+	return nil
+}
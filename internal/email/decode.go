@@ -1,6 +1,7 @@
 package email
 
 import (
+	"encoding/base64"
 	"fmt"
 	"go.uber.org/zap"
 	"golang.org/x/net/html"
@@ -12,12 +13,47 @@ import (
 	"mime/quotedprintable"
 	"net/mail"
 	"strings"
+	"time"
 )
 
-// DecodeMessage декодирует заголовки и тело письма.
-// Возвращает subject и body письма как строки.
+// Attachment — вложение письма
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Decoded — декодированное письмо
+type Decoded struct {
+	Subject string
+	// From, To и Cc — адреса в виде "Имя <address>"
+	From        string
+	To          []string
+	Cc          []string
+	Date        time.Time
+	Header      mail.Header
+	Body        string
+	Attachments []Attachment
+	// Raw — исходный текст письма, если он доступен
+	Raw []byte
+	// Ref — ссылка на письмо в почтовом ящике (учётная запись, папка, UIDVALIDITY, UID)
+	Ref string
+	// Folder — папка IMAP, в которой найдено письмо
+	Folder string
+}
+
+// Decode декодирует письмо, полученное из IMAP, сохраняя его исходный текст
+func Decode(m Message, logger *zap.SugaredLogger) Decoded {
+	d := DecodeMessage(m.Message, logger)
+	d.Raw = m.Raw
+	return d
+}
+
+// DecodeMessage декодирует заголовки, тело и вложения письма.
+// В качестве тела используется первая текстовая часть, вложения собираются
+// со всех уровней вложенности multipart (mixed, related, alternative).
 // Логирует все предупреждения и ошибки при декодировании.
-func DecodeMessage(msg *mail.Message, logger *zap.SugaredLogger) (string, string) {
+func DecodeMessage(msg *mail.Message, logger *zap.SugaredLogger) Decoded {
 	// Декодируем тему письма
 	subject, err := decodeHeader(msg.Header.Get("Subject"))
 	if err != nil {
@@ -25,68 +61,189 @@ func DecodeMessage(msg *mail.Message, logger *zap.SugaredLogger) (string, string
 		logger.Warnw("failed to decode email subject", "error", err)
 	}
 
+	d := Decoded{
+		Subject: subject,
+		From:    strings.Join(decodeAddresses(msg.Header, "From", logger), ", "),
+		To:      decodeAddresses(msg.Header, "To", logger),
+		Cc:      decodeAddresses(msg.Header, "Cc", logger),
+		Header:  msg.Header,
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		d.Date = date
+	}
+
 	contentType := msg.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
 	mediatype, params, err := mime.ParseMediaType(contentType)
 	if err != nil {
 		logger.Warnw("failed to parse content-type", "error", err)
-		return subject, "error: cannot parse content-type"
+		d.Body = "error: cannot parse content-type"
+		return d
 	}
 
 	// Обработка multipart сообщений
 	if strings.HasPrefix(mediatype, "multipart/") {
-		boundary, ok := params["boundary"]
-		if !ok {
-			logger.Warn("no boundary found in multipart message")
-			return subject, "error: no boundary in multipart message"
+		found, err := walkMultipart(msg.Body, params, &d, logger)
+		if err != nil {
+			d.Body = err.Error()
+			return d
 		}
+		if !found {
+			d.Body = "no suitable part found"
+		}
+		return d
+	}
 
-		mr := multipart.NewReader(msg.Body, boundary)
-		for {
-			part, err := mr.NextPart()
-			if err == io.EOF {
-				break
-			}
+	// Одночастное сообщение
+	body, err := decodePart(msg.Body, msg.Header.Get("Content-Transfer-Encoding"), params["charset"], logger)
+	if err != nil {
+		logger.Warnw("failed to read email body", "error", err)
+		d.Body = "error reading body"
+		return d
+	}
+
+	if mediatype == "text/html" {
+		body = htmlToText(body)
+	}
+	d.Body = strings.TrimSpace(html.UnescapeString(body))
+	return d
+}
+
+// walkMultipart рекурсивно обходит части multipart-сообщения, заполняя тело и вложения.
+// Возвращает true, если найдена текстовая часть для тела письма.
+func walkMultipart(r io.Reader, params map[string]string, d *Decoded, logger *zap.SugaredLogger) (bool, error) {
+	boundary, ok := params["boundary"]
+	if !ok {
+		logger.Warn("no boundary found in multipart message")
+		return false, fmt.Errorf("error: no boundary in multipart message")
+	}
+
+	found := d.Body != ""
+	mr := multipart.NewReader(r, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Warnw("failed to read multipart part", "error", err)
+			break
+		}
+
+		partType, partParams, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			partType, partParams = "text/plain", map[string]string{}
+		}
+		encoding := part.Header.Get("Content-Transfer-Encoding")
+
+		// Вложенный multipart/mixed, related или alternative
+		if strings.HasPrefix(partType, "multipart/") {
+			sub, err := walkMultipart(part, partParams, d, logger)
 			if err != nil {
-				logger.Warnw("failed to read multipart part", "error", err)
-				continue
+				logger.Warnw("failed to decode nested multipart", "error", err)
 			}
+			found = found || sub
+			continue
+		}
+
+		disposition, dispParams, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		filename := attachmentName(dispParams["filename"], partParams["name"], logger)
 
-			partType, partParams, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
-			body, err := decodePart(part, part.Header.Get("Content-Transfer-Encoding"), partParams["charset"], logger)
+		isText := partType == "text/plain" || partType == "text/html"
+		if isText && disposition != "attachment" && !found {
+			body, err := decodePart(part, encoding, partParams["charset"], logger)
 			if err != nil {
 				logger.Warnw("failed to decode email part", "error", err)
 				continue
 			}
-
-			switch partType {
-			case "text/html":
-				return subject, strings.TrimSpace(html.UnescapeString(htmlToText(body)))
-			case "text/plain":
-				return subject, strings.TrimSpace(html.UnescapeString(body))
+			if partType == "text/html" {
+				body = htmlToText(body)
 			}
+			d.Body = strings.TrimSpace(html.UnescapeString(body))
+			found = true
+			continue
 		}
-		return subject, "no suitable part found"
+
+		// Остальные текстовые части без имени файла — альтернативные представления тела
+		if isText && filename == "" {
+			continue
+		}
+
+		data, err := io.ReadAll(transferDecoder(part, encoding))
+		if err != nil {
+			logger.Warnw("failed to read attachment", "filename", filename, "error", err)
+			continue
+		}
+		if filename == "" {
+			filename = defaultAttachmentName(partType, len(d.Attachments)+1)
+		}
+		d.Attachments = append(d.Attachments, Attachment{
+			Filename:    filename,
+			ContentType: partType,
+			Data:        data,
+		})
 	}
+	return found, nil
+}
 
-	// Одночастное сообщение
-	body, err := decodePart(msg.Body, msg.Header.Get("Content-Transfer-Encoding"), params["charset"], logger)
+// attachmentName декодирует имя вложения из Content-Disposition или параметра name
+func attachmentName(filename, name string, logger *zap.SugaredLogger) string {
+	if filename == "" {
+		filename = name
+	}
+	if filename == "" {
+		return ""
+	}
+	decoded, err := decodeHeader(filename)
 	if err != nil {
-		logger.Warnw("failed to read email body", "error", err)
-		return subject, "error reading body"
+		logger.Warnw("failed to decode attachment filename", "filename", filename, "error", err)
+		return filename
 	}
+	return decoded
+}
 
-	if mediatype == "text/html" {
-		body = htmlToText(body)
+// defaultAttachmentName подбирает имя для вложения без имени файла
+func defaultAttachmentName(contentType string, n int) string {
+	ext := ""
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		ext = exts[0]
+	}
+	return fmt.Sprintf("attachment-%d%s", n, ext)
+}
+
+// transferDecoder снимает Content-Transfer-Encoding (base64, quoted-printable)
+func transferDecoder(reader io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(reader)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineFilter{r: reader})
+	}
+	return reader
+}
+
+// newlineFilter удаляет переводы строк из base64-потока
+type newlineFilter struct {
+	r io.Reader
+}
+
+func (f *newlineFilter) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	j := 0
+	for i := 0; i < n; i++ {
+		if p[i] != '\r' && p[i] != '\n' && p[i] != ' ' && p[i] != '\t' {
+			p[j] = p[i]
+			j++
+		}
 	}
-	return subject, strings.TrimSpace(html.UnescapeString(body))
+	return j, err
 }
 
 // decodePart декодирует отдельную часть письма с учётом кодировки и charset.
 func decodePart(reader io.Reader, encoding string, charsetStr string, logger *zap.SugaredLogger) (string, error) {
-	r := reader
-	if strings.ToLower(encoding) == "quoted-printable" {
-		r = quotedprintable.NewReader(r)
-	}
+	r := transferDecoder(reader, encoding)
 
 	if charsetStr != "" && strings.ToLower(charsetStr) != "utf-8" {
 		enc, _ := charset.Lookup(strings.ToLower(charsetStr))
@@ -124,6 +281,40 @@ func htmlToText(input string) string {
 
 // decodeHeader декодирует MIME-заголовок с учётом кодировки.
 func decodeHeader(hdr string) (string, error) {
+	return wordDecoder().DecodeHeader(hdr)
+}
+
+// decodeAddresses разбирает список адресов из заголовка.
+// Если заголовок не разбирается, возвращается его декодированное значение целиком.
+func decodeAddresses(h mail.Header, name string, logger *zap.SugaredLogger) []string {
+	raw := h.Get(name)
+	if raw == "" {
+		return nil
+	}
+
+	parser := mail.AddressParser{WordDecoder: wordDecoder()}
+	list, err := parser.ParseList(raw)
+	if err != nil {
+		logger.Debugw("failed to parse address list", "header", name, "error", err)
+		if decoded, err := decodeHeader(raw); err == nil {
+			raw = decoded
+		}
+		return []string{raw}
+	}
+
+	result := make([]string, 0, len(list))
+	for _, a := range list {
+		if a.Name != "" {
+			result = append(result, fmt.Sprintf("%s <%s>", a.Name, a.Address))
+		} else {
+			result = append(result, a.Address)
+		}
+	}
+	return result
+}
+
+// wordDecoder создаёт декодер MIME-слов с поддержкой национальных кодировок
+func wordDecoder() *mime.WordDecoder {
 	dec := new(mime.WordDecoder)
 	dec.CharsetReader = func(charsetName string, input io.Reader) (io.Reader, error) {
 		e, _ := charset.Lookup(strings.ToLower(charsetName))
@@ -132,5 +323,5 @@ func decodeHeader(hdr string) (string, error) {
 		}
 		return input, nil
 	}
-	return dec.DecodeHeader(hdr)
+	return dec
 }
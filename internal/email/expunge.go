@@ -0,0 +1,58 @@
+package email
+
+import (
+	"errors"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+)
+
+// uidPlusExtension — расширение IMAP с командой UID EXPUNGE (RFC 4315)
+const uidPlusExtension = "UIDPLUS"
+
+// ErrNotExpunged возвращается, если письмо помечено флагом \Deleted, но не удалено из папки:
+// сервер не поддерживает UIDPLUS, а EXPUNGE всей папки удалил бы и другие помеченные письма
+var ErrNotExpunged = errors.New("message is marked \\Deleted but not expunged: server does not support UIDPLUS")
+
+// expungeMessages удаляет из выбранной папки только письма seqset командой UID EXPUNGE
+func expungeMessages(c *client.Client, seqset *imap.SeqSet) error {
+	ok, err := c.Support(uidPlusExtension)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotExpunged
+	}
+
+	cmd := &commands.Uid{Cmd: &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{seqset}}}
+	status, err := c.Execute(cmd, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// deleteMessages помечает письма seqset флагом \Deleted и удаляет их из папки
+func deleteMessages(c *client.Client, seqset *imap.SeqSet) error {
+	if err := c.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return err
+	}
+	return expungeMessages(c, seqset)
+}
+
+// moveMessages перемещает письма seqset в папку folder командой MOVE, а без её поддержки —
+// копированием с последующим удалением только этих писем из исходной папки
+func moveMessages(c *client.Client, seqset *imap.SeqSet, folder string) error {
+	ok, err := c.Support("MOVE")
+	if err != nil {
+		return err
+	}
+	if ok {
+		return c.UidMove(seqset, folder)
+	}
+	if err := c.UidCopy(seqset, folder); err != nil {
+		return err
+	}
+	return deleteMessages(c, seqset)
+}
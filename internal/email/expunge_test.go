@@ -0,0 +1,79 @@
+package email
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestDeleteMessages(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []server.Extension
+		wantErr    error
+		// want — письма, оставшиеся в папке, и их флаги
+		want map[uint32][]string
+	}{
+		{
+			name:       "uid expunge removes only the message",
+			extensions: []server.Extension{uidPlus{}},
+			want: map[uint32][]string{
+				6: {imap.SeenFlag},
+				8: {imap.DeletedFlag},
+				9: nil,
+			},
+		},
+		{
+			name:    "without uidplus the message stays marked",
+			wantErr: ErrNotExpunged,
+			want: map[uint32][]string{
+				6: {imap.SeenFlag},
+				7: {imap.DeletedFlag},
+				8: {imap.DeletedFlag},
+				9: nil,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialMemory(t, tt.extensions...)
+			// Письмо 8 помечено \Deleted другим клиентом и не должно быть удалено
+			appendMessages(t, c, "INBOX", nil, []string{imap.DeletedFlag}, nil)
+			if _, err := c.Select("INBOX", false); err != nil {
+				t.Fatalf("select: %v", err)
+			}
+
+			seqset := new(imap.SeqSet)
+			seqset.AddNum(7)
+			err := deleteMessages(c, seqset)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("deleteMessages error = %v, want %v", err, tt.wantErr)
+			}
+
+			got := folderFlags(t, c)
+			for uid, flags := range got {
+				if len(flags) == 0 {
+					got[uid] = nil
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("folder after delete = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpungeMessagesRequiresUIDPlus(t *testing.T) {
+	c := dialMemory(t)
+	if _, err := c.Select("INBOX", false); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(6)
+	if err := expungeMessages(c, seqset); !errors.Is(err, ErrNotExpunged) {
+		t.Errorf("expungeMessages error = %v, want ErrNotExpunged", err)
+	}
+}
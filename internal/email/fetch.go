@@ -1,91 +1,223 @@
 package email
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
 	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/state"
 	"github.com/st-kuptsov/mail2tg/pkg/metrics"
 	"go.uber.org/zap"
+	"io"
 	"net/mail"
+	"sort"
 )
 
-// FetchUnreadEmails получает все новые непрочитанные письма из указанной папки IMAP.
-// Возвращает слайс сообщений mail.Message и ошибку при неудаче.
+// Message — письмо, полученное из IMAP, вместе с его UID
+type Message struct {
+	UID     uint32
+	Message *mail.Message
+	// Raw — исходный текст письма в формате RFC 822
+	Raw []byte
+}
+
+// FetchNewEmails получает из указанной папки IMAP письма, которые ещё не были доставлены.
+// Письма выбираются по диапазону UID из локального состояния и читаются через BODY.PEEK,
+// поэтому флаг \Seen на сервере не меняется. При первом запуске обрабатываются
+// только непрочитанные письма.
 // Логирует все ключевые шаги и обновляет метрики.
-func FetchUnreadEmails(cfg *config.Config, f config.Folder, c *client.Client, logger *zap.SugaredLogger) ([]*mail.Message, error) {
+func FetchNewEmails(cfg *config.Config, acc config.Account, f config.Folder, c *client.Client, store *state.Store, logger *zap.SugaredLogger) (state.Key, []Message, error) {
 	logger.Infow("selecting IMAP folder", "folder", f.Name)
 
 	// Выбираем папку
 	mbox, err := c.Select(f.Name, false)
 	if err != nil {
 		logger.Errorw("failed to select folder", "folder", f.Name, "error", err)
-		return nil, fmt.Errorf("failed to select folder: %w", err)
+		return state.Key{}, nil, fmt.Errorf("failed to select folder: %w", err)
 	}
 
-	logger.Infow("folder selected", "folder", f.Name, "messages_total", mbox.Messages)
-	if mbox.Messages == 0 {
-		return nil, nil
+	key := state.Key{
+		Account:     AccountKey(acc),
+		Folder:      f.Name,
+		UIDValidity: mbox.UidValidity,
 	}
 
-	// Создаем критерий поиска непрочитанных писем
-	criteria := imap.NewSearchCriteria()
-	criteria.WithoutFlags = []string{imap.SeenFlag}
+	lastUID, retry, fresh, err := store.Sync(key, cfg.MaxDeliveryAttempts)
+	if err != nil {
+		logger.Errorw("failed to load folder state", "folder", f.Name, "error", err)
+		return key, nil, fmt.Errorf("failed to load folder state: %w", err)
+	}
 
-	ids, err := c.Search(criteria)
+	logger.Infow("folder selected", "folder", f.Name, "messages_total", mbox.Messages, "last_uid", lastUID)
+	if mbox.Messages == 0 {
+		metrics.MailChecks.Inc()
+		return key, nil, nil
+	}
+
+	var uids []uint32
+	if fresh {
+		// Первый запуск: берём только непрочитанные письма, остальные считаем обработанными
+		uids, lastUID, err = bootstrapUIDs(c, mbox)
+	} else {
+		uids, err = newUIDs(c, lastUID)
+		for _, uid := range uids {
+			if uid > lastUID {
+				lastUID = uid
+			}
+		}
+		uids = append(retry, uids...)
+	}
 	if err != nil {
-		logger.Errorw("failed to search for unread emails", "folder", f.Name, "error", err)
-		return nil, fmt.Errorf("failed to search emails: %w", err)
+		logger.Errorw("failed to search for new emails", "folder", f.Name, "error", err)
+		return key, nil, fmt.Errorf("failed to search emails: %w", err)
 	}
 
-	logger.Infow("unread emails found", "folder", f.Name, "count", len(ids))
+	logger.Infow("new emails found", "folder", f.Name, "count", len(uids), "retry", len(retry))
 	metrics.MailChecks.Inc()
 
-	if len(ids) == 0 {
-		return nil, nil
+	// Регистрируем письма до отправки: после сбоя они будут отправлены повторно
+	if err := store.Advance(key, lastUID, uids); err != nil {
+		logger.Errorw("failed to save folder state", "folder", f.Name, "error", err)
+		return key, nil, fmt.Errorf("failed to save folder state: %w", err)
+	}
+
+	if len(uids) == 0 {
+		return key, nil, nil
 	}
 
 	// Подготавливаем последовательность для выборки
 	seqset := new(imap.SeqSet)
-	seqset.AddNum(ids...)
+	seqset.AddNum(uids...)
 
-	section := &imap.BodySectionName{}
+	section := &imap.BodySectionName{Peek: true}
 	messagesChan := make(chan *imap.Message, 10)
 	done := make(chan error, 1)
 
 	// Получаем письма асинхронно
 	go func() {
-		done <- c.Fetch(seqset, []imap.FetchItem{section.FetchItem()}, messagesChan)
+		done <- c.UidFetch(seqset, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messagesChan)
 	}()
 
-	var result []*mail.Message
+	var result []Message
+	// returned — UID, которые вернул сервер; остальные удалены или перемещены
+	returned := make(map[uint32]bool)
+	// unreadable — письма без текста или с ошибкой чтения; доставка повторяется по общим правилам
+	unreadable := make(map[uint32]error)
 	for msg := range messagesChan {
 		if msg == nil {
 			continue
 		}
+		returned[msg.Uid] = true
 
 		r := msg.GetBody(section)
 		if r == nil {
-			logger.Warn("email body is empty")
+			logger.Warnw("email body is empty", "uid", msg.Uid)
+			unreadable[msg.Uid] = fmt.Errorf("email body is empty")
+			continue
+		}
+
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			logger.Warnw("failed to read email", "uid", msg.Uid, "error", err)
+			unreadable[msg.Uid] = err
 			continue
 		}
 
-		m, err := mail.ReadMessage(r)
+		m, err := mail.ReadMessage(bytes.NewReader(raw))
 		if err != nil {
-			logger.Warnw("failed to read email", "error", err)
+			logger.Warnw("failed to read email", "uid", msg.Uid, "error", err)
+			// Повторная попытка не поможет — сразу помечаем письмо как недоставленное
+			if err := store.MarkFailed(key, msg.Uid, nil, err, 1); err != nil {
+				logger.Warnw("failed to save message state", "uid", msg.Uid, "error", err)
+			}
 			continue
 		}
 
-		result = append(result, m)
+		result = append(result, Message{UID: msg.Uid, Message: m, Raw: raw})
 		metrics.MailReceived.Inc()
 	}
 
 	// Проверяем ошибки после завершения Fetch
 	if err := <-done; err != nil {
 		logger.Errorw("failed to fetch emails", "folder", f.Name, "error", err)
-		return nil, fmt.Errorf("failed to fetch emails: %w", err)
+		return key, nil, fmt.Errorf("failed to fetch emails: %w", err)
+	}
+
+	// Письма, которые не удалось прочитать, иначе остались бы ожидающими навсегда
+	for _, uid := range uids {
+		if !returned[uid] {
+			logger.Warnw("email no longer exists on server, skipping", "folder", f.Name, "uid", uid)
+			if err := store.Remove(key, uid); err != nil {
+				logger.Warnw("failed to save message state", "uid", uid, "error", err)
+			}
+			continue
+		}
+		if cause, ok := unreadable[uid]; ok {
+			if err := store.MarkFailed(key, uid, store.Delivered(key, uid), cause, cfg.MaxDeliveryAttempts); err != nil {
+				logger.Warnw("failed to save message state", "uid", uid, "error", err)
+			}
+		}
 	}
 
+	// Сервер может вернуть письма в произвольном порядке
+	sort.Slice(result, func(i, j int) bool { return result[i].UID < result[j].UID })
+
 	logger.Infow("emails processed", "folder", f.Name, "count", len(result))
+	return key, result, nil
+}
+
+// bootstrapUIDs возвращает UID непрочитанных писем и UID, начиная с которого
+// письма считаются новыми.
+func bootstrapUIDs(c *client.Client, mbox *imap.MailboxStatus) ([]uint32, uint32, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if mbox.UidNext > 0 {
+		return uids, mbox.UidNext - 1, nil
+	}
+
+	// Сервер не сообщил UIDNEXT — берём UID последнего письма
+	last := imap.NewSearchCriteria()
+	last.Uid = new(imap.SeqSet)
+	last.Uid.AddNum(0)
+
+	tail, err := c.UidSearch(last)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var lastUID uint32
+	for _, uid := range append(tail, uids...) {
+		if uid > lastUID {
+			lastUID = uid
+		}
+	}
+	return uids, lastUID, nil
+}
+
+// newUIDs возвращает UID писем, поступивших после lastUID
+func newUIDs(c *client.Client, lastUID uint32) ([]uint32, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(lastUID+1, 0)
+
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	// Диапазон "n:*" всегда включает последнее письмо, даже если его UID меньше n
+	result := uids[:0]
+	for _, uid := range uids {
+		if uid > lastUID {
+			result = append(result, uid)
+		}
+	}
 	return result, nil
 }
@@ -0,0 +1,58 @@
+package email
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/state"
+	"go.uber.org/zap"
+)
+
+func TestFetchNewEmailsForgetsMissingUIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending []uint32
+		fetched []uint32
+	}{
+		{name: "missing uid", pending: []uint32{3}, fetched: nil},
+		{name: "missing and present", pending: []uint32{3, 6}, fetched: []uint32{6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialMemory(t)
+			store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+			if err != nil {
+				t.Fatalf("state.Open: %v", err)
+			}
+			cfg := &config.Config{MaxDeliveryAttempts: 3}
+			acc := config.Account{Name: "work"}
+			f := config.Folder{Name: "INBOX"}
+			key := state.Key{Account: "work", Folder: "INBOX", UIDValidity: 1}
+			store.Sync(key, cfg.MaxDeliveryAttempts)
+			if err := store.Advance(key, 6, tt.pending); err != nil {
+				t.Fatalf("Advance: %v", err)
+			}
+
+			_, msgs, err := FetchNewEmails(cfg, acc, f, c, store, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatalf("FetchNewEmails: %v", err)
+			}
+			var got []uint32
+			for _, m := range msgs {
+				got = append(got, m.UID)
+			}
+			if len(got) != len(tt.fetched) || (len(got) > 0 && got[0] != tt.fetched[0]) {
+				t.Errorf("fetched %v, want %v", got, tt.fetched)
+			}
+
+			// Письмо, которого нет на сервере, больше не повторяется
+			_, retry, _, _ := store.Sync(key, cfg.MaxDeliveryAttempts)
+			for _, uid := range retry {
+				if uid == 3 {
+					t.Errorf("uid 3 is still retried: %v", retry)
+				}
+			}
+		})
+	}
+}
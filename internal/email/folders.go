@@ -0,0 +1,76 @@
+package email
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/st-kuptsov/mail2tg/config"
+	"go.uber.org/zap"
+)
+
+// ResolveFolders возвращает папки учётной записи для проверки. Папки, заданные шаблоном,
+// раскрываются по списку папок сервера (LIST); имена приходят уже декодированными
+// из modified UTF-7. Если папка подходит под несколько шаблонов, она проверяется
+// с правилами первого из них. При ошибке LIST возвращаются только обычные папки.
+func ResolveFolders(c *client.Client, acc config.Account, logger *zap.SugaredLogger) ([]config.Folder, error) {
+	var literal, patterns []config.Folder
+	for _, r := range acc.Route {
+		for _, f := range r.Folders {
+			if f.IsPattern() {
+				patterns = append(patterns, f)
+			} else {
+				literal = append(literal, f)
+			}
+		}
+	}
+	if len(patterns) == 0 {
+		return literal, nil
+	}
+
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", "*", mailboxes)
+	}()
+
+	var infos []*imap.MailboxInfo
+	for m := range mailboxes {
+		infos = append(infos, m)
+	}
+	if err := <-done; err != nil {
+		return literal, fmt.Errorf("failed to list folders: %w", err)
+	}
+
+	result := literal
+	seen := make(map[string]bool)
+	for _, f := range literal {
+		seen[f.Name] = true
+	}
+	for _, f := range patterns {
+		var found []string
+		for _, m := range infos {
+			if seen[m.Name] || !selectable(m) || !f.Matches(m.Name, m.Delimiter) {
+				continue
+			}
+			seen[m.Name] = true
+			found = append(found, m.Name)
+
+			folder := f
+			folder.Name, folder.Pattern = m.Name, f.Name
+			folder.Include, folder.Exclude = nil, nil
+			result = append(result, folder)
+		}
+		logger.Debugw("folder pattern resolved", "pattern", f.Name, "folders", found)
+	}
+	return result, nil
+}
+
+// selectable проверяет, что папку можно выбрать командой SELECT
+func selectable(m *imap.MailboxInfo) bool {
+	return !slices.ContainsFunc(m.Attributes, func(attr string) bool {
+		return strings.EqualFold(attr, imap.NoSelectAttr) || strings.EqualFold(attr, `\NonExistent`)
+	})
+}
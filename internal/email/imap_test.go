@@ -0,0 +1,135 @@
+package email
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+	"github.com/st-kuptsov/mail2tg/config"
+)
+
+// serveMemory запускает IMAP-сервер с хранилищем в памяти и возвращает его адрес.
+// В INBOX сервера одно прочитанное письмо с UID 6; UIDPLUS сервер поддерживает,
+// только если передано расширение uidPlus.
+func serveMemory(t *testing.T, extensions ...server.Extension) *net.TCPAddr {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	s.Enable(extensions...)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().(*net.TCPAddr)
+}
+
+// memoryAccount возвращает учётную запись для сервера serveMemory
+func memoryAccount(addr *net.TCPAddr) config.Account {
+	return config.Account{Name: "work", IMAP: config.IMAPConfig{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: "username",
+		Password: "password",
+		Security: config.IMAPSecurityNone,
+	}}
+}
+
+// dialMemory запускает IMAP-сервер serveMemory и возвращает подключённый к нему клиент
+func dialMemory(t *testing.T, extensions ...server.Extension) *client.Client {
+	t.Helper()
+	c, err := client.Dial(serveMemory(t, extensions...).String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Logout() })
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	return c
+}
+
+// appendMessages добавляет в папку по письму с каждым набором флагов из flags
+func appendMessages(t *testing.T, c *client.Client, folder string, flags ...[]string) {
+	t.Helper()
+	for i, f := range flags {
+		body := "Subject: test " + string(rune('a'+i)) + "\r\n\r\nbody"
+		if err := c.Append(folder, f, time.Now(), strings.NewReader(body)); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+}
+
+// folderFlags возвращает флаги писем выбранной папки по UID
+func folderFlags(t *testing.T, c *client.Client) map[uint32][]string {
+	t.Helper()
+	seqset, _ := imap.ParseSeqSet("1:*")
+	messages := make(chan *imap.Message, 16)
+	if err := c.UidFetch(seqset, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, messages); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	result := make(map[uint32][]string)
+	for m := range messages {
+		flags := append([]string(nil), m.Flags...)
+		sort.Strings(flags)
+		result[m.Uid] = flags
+	}
+	return result
+}
+
+// uidPlus — расширение тестового сервера с командой UID EXPUNGE (RFC 4315)
+type uidPlus struct{}
+
+func (uidPlus) Capabilities(server.Conn) []string { return []string{uidPlusExtension} }
+
+func (uidPlus) Command(name string) server.HandlerFactory {
+	if name != "EXPUNGE" {
+		return nil
+	}
+	return func() server.Handler { return &uidExpunge{} }
+}
+
+// uidExpunge выполняет EXPUNGE, а с префиксом UID удаляет только помеченные письма из набора UID
+type uidExpunge struct {
+	server.Expunge
+	seqset *imap.SeqSet
+}
+
+func (cmd *uidExpunge) Parse(fields []interface{}) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	s, err := imap.ParseString(fields[0])
+	if err != nil {
+		return err
+	}
+	cmd.seqset, err = imap.ParseSeqSet(s)
+	return err
+}
+
+func (cmd *uidExpunge) UidHandle(conn server.Conn) error {
+	mbox, ok := conn.Context().Mailbox.(*memory.Mailbox)
+	if !ok || cmd.seqset == nil {
+		return errors.New("UID EXPUNGE requires a selected mailbox and a UID set")
+	}
+	kept := mbox.Messages[:0]
+	for _, m := range mbox.Messages {
+		deleted := false
+		for _, f := range m.Flags {
+			deleted = deleted || f == imap.DeletedFlag
+		}
+		if !deleted || !cmd.seqset.Contains(m.Uid) {
+			kept = append(kept, m)
+		}
+	}
+	mbox.Messages = kept
+	return nil
+}
@@ -0,0 +1,306 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// minBackoff — пауза после первого неудачного подключения
+	minBackoff = time.Second
+	// verifyAfter — соединение, простаивавшее дольше, проверяется командой NOOP перед выдачей
+	verifyAfter = 5 * time.Second
+)
+
+// errPoolClosed возвращается при запросе соединения из закрытого пула
+var errPoolClosed = errors.New("IMAP connection pool is closed")
+
+// pooledConn — простаивающее соединение пула
+type pooledConn struct {
+	c        *client.Client
+	lastUsed time.Time
+}
+
+// Pool — постоянные соединения с IMAP-сервером одной учётной записи.
+// Соединения используются повторно, простаивающие поддерживаются командой NOOP,
+// оборванные закрываются. Одновременно открыто не больше imap.max_connections соединений.
+// После неудачного подключения следующие попытки откладываются с экспоненциальной
+// паузой и случайным разбросом, чтобы не упираться в ограничения сервера.
+type Pool struct {
+	acc    config.Account
+	logger *zap.SugaredLogger
+	// slots ограничивает число выданных соединений; пока слот занят, соединение не простаивает
+	slots chan struct{}
+	stop  chan struct{}
+
+	mu       sync.Mutex
+	idle     []*pooledConn
+	failures int
+	retryAt  time.Time
+	closed   bool
+}
+
+// pool — пул соединений вместе с настройками IMAP, с которыми он создан
+type pool struct {
+	p        *Pool
+	snapshot string
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = make(map[string]pool)
+)
+
+// AccountPool возвращает пул соединений учётной записи, создавая его при первом обращении.
+// При изменении настроек imap учётной записи прежний пул закрывается и создаётся новый.
+func AccountPool(acc config.Account, logger *zap.SugaredLogger) *Pool {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	snapshot := poolSnapshot(acc.IMAP)
+	if existing, ok := pools[acc.Name]; ok {
+		if existing.snapshot == snapshot {
+			return existing.p
+		}
+		logger.Infow("IMAP settings changed, reopening connections")
+		existing.p.Close()
+	}
+
+	p := &Pool{
+		acc:    acc,
+		logger: logger,
+		slots:  make(chan struct{}, acc.IMAP.PoolSize()),
+		stop:   make(chan struct{}),
+	}
+	pools[acc.Name] = pool{p: p, snapshot: snapshot}
+	go p.keepalive()
+	return p
+}
+
+// poolSnapshot кодирует настройки IMAP для сравнения при перезагрузке конфигурации.
+// В отличие от %+v, JSON раскрывает вложенные указатели и не зависит от их адресов.
+func poolSnapshot(c config.IMAPConfig) string {
+	// Настройки состоят из строк, чисел и срезов, поэтому кодирование не завершается ошибкой
+	data, _ := json.Marshal(c)
+	return string(data)
+}
+
+// ClosePools закрывает пулы учётных записей, которых нет в keep.
+// Если keep пуст, закрываются все пулы.
+func ClosePools(keep map[string]bool) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	for name, existing := range pools {
+		if !keep[name] {
+			existing.p.Close()
+			delete(pools, name)
+		}
+	}
+}
+
+// Get выдаёт соединение: простаивающее, если оно живо, или новое.
+// Ждёт, пока освободится одно из imap.max_connections соединений.
+// Во время паузы после неудачного подключения сразу возвращает ошибку.
+// Полученное соединение нужно вернуть в пул вызовом Put.
+func (p *Pool) Get(ctx context.Context) (*client.Client, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.slots
+			return nil, errPoolClosed
+		}
+
+		if n := len(p.idle); n > 0 {
+			pc := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			p.mu.Unlock()
+			if time.Since(pc.lastUsed) < verifyAfter || p.alive(pc.c) {
+				return pc.c, nil
+			}
+			p.logger.Infow("IMAP connection is broken, reconnecting")
+			p.discard(pc.c)
+			continue
+		}
+
+		if wait := time.Until(p.retryAt); wait > 0 {
+			failures := p.failures
+			p.mu.Unlock()
+			<-p.slots
+			return nil, fmt.Errorf("IMAP reconnect postponed for %s after %d failed attempts", wait.Round(time.Second), failures)
+		}
+		p.mu.Unlock()
+
+		c, err := ConnectToIMAP(p.acc, p.logger)
+
+		p.mu.Lock()
+		if err != nil {
+			p.failures++
+			delay := Backoff(p.failures, p.acc.IMAP.MaxBackoffDuration())
+			p.retryAt = time.Now().Add(delay)
+			p.mu.Unlock()
+			<-p.slots
+			p.logger.Warnw("IMAP reconnect postponed", "backoff", delay.Round(time.Millisecond), "failures", p.failures)
+			return nil, err
+		}
+		p.failures, p.retryAt = 0, time.Time{}
+		p.mu.Unlock()
+		metrics.ImapConnections.WithLabelValues(p.acc.Name).Inc()
+		return c, nil
+	}
+}
+
+// Put возвращает соединение в пул. Если при работе с ним произошла ошибка err,
+// соединение проверяется командой NOOP и закрывается, если оно оборвано.
+func (p *Pool) Put(c *client.Client, err error) {
+	defer func() { <-p.slots }()
+
+	if c.State() == imap.LogoutState || (err != nil && !p.alive(c)) {
+		p.logger.Infow("IMAP connection is broken, closing it", "error", err)
+		p.discard(c)
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.logout(c)
+		return
+	}
+	p.idle = append(p.idle, &pooledConn{c: c, lastUsed: time.Now()})
+	p.mu.Unlock()
+}
+
+// Close закрывает простаивающие соединения; выданные закрываются при возврате в пул
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	close(p.stop)
+	for _, pc := range idle {
+		p.logout(pc.c)
+	}
+}
+
+// keepalive раз в imap.keepalive проверяет простаивающие соединения командой NOOP,
+// чтобы сервер не закрыл их по тайм-ауту, и закрывает оборванные
+func (p *Pool) keepalive() {
+	ticker := time.NewTicker(p.acc.IMAP.KeepaliveInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		count := len(p.idle)
+		p.mu.Unlock()
+
+		// Каждое соединение проверяется под своим слотом, чтобы его не выдали во время проверки
+		for range count {
+			select {
+			case p.slots <- struct{}{}:
+			default:
+				continue
+			}
+
+			p.mu.Lock()
+			var pc *pooledConn
+			if len(p.idle) > 0 && time.Since(p.idle[0].lastUsed) >= verifyAfter {
+				pc = p.idle[0]
+				p.idle = p.idle[1:]
+			}
+			p.mu.Unlock()
+
+			if pc != nil {
+				if p.alive(pc.c) {
+					pc.lastUsed = time.Now()
+					p.mu.Lock()
+					closed := p.closed
+					if !closed {
+						p.idle = append(p.idle, pc)
+					}
+					p.mu.Unlock()
+					if closed {
+						p.logout(pc.c)
+					}
+				} else {
+					p.logger.Infow("idle IMAP connection is broken, closing it")
+					p.discard(pc.c)
+				}
+			}
+			<-p.slots
+		}
+	}
+}
+
+// alive проверяет соединение командой NOOP. Если read_timeout не задан,
+// на ответ отводится dial_timeout.
+func (p *Pool) alive(c *client.Client) bool {
+	if c.State() == imap.LogoutState {
+		return false
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		c.Timeout = p.acc.IMAP.DialTimeoutDuration()
+	}
+	err := c.Noop()
+	c.Timeout = timeout
+	return err == nil
+}
+
+// discard закрывает оборванное соединение без команды LOGOUT
+func (p *Pool) discard(c *client.Client) {
+	if err := c.Terminate(); err != nil {
+		p.logger.Debugw("IMAP connection close failed", "error", err)
+	}
+	metrics.ImapConnections.WithLabelValues(p.acc.Name).Dec()
+}
+
+// logout завершает сеанс и закрывает соединение
+func (p *Pool) logout(c *client.Client) {
+	if err := c.Logout(); err != nil {
+		p.logger.Debugw("IMAP logout failed", "error", err)
+	}
+	metrics.ImapConnections.WithLabelValues(p.acc.Name).Dec()
+}
+
+// Backoff возвращает паузу перед попыткой подключения номер attempt+1: она удваивается
+// с каждой неудачей до limit, а случайный разброс от половины до полной паузы
+// не даёт нескольким соединениям переподключаться одновременно
+func Backoff(attempt int, limit time.Duration) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+	return d/2 + rand.N(d/2+1)
+}
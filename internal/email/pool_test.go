@@ -0,0 +1,68 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"go.uber.org/zap"
+)
+
+// loadIMAP собирает настройки IMAP заново, как при каждой перезагрузке конфигурации
+func loadIMAP(edit func(*config.IMAPConfig)) config.IMAPConfig {
+	c := config.IMAPConfig{
+		Host:     "imap.example.com",
+		Username: "user",
+		Auth:     config.IMAPAuthXOAuth2,
+		OAuth:    config.OAuthConfig{Provider: "microsoft", Scopes: []string{"offline_access", "IMAP.AccessAsUser.All"}},
+	}
+	if edit != nil {
+		edit(&c)
+	}
+	return c
+}
+
+func TestPoolSnapshot(t *testing.T) {
+	base := poolSnapshot(loadIMAP(nil))
+	tests := []struct {
+		name string
+		edit func(*config.IMAPConfig)
+		same bool
+	}{
+		{name: "reloaded unchanged", same: true},
+		{name: "host changed", edit: func(c *config.IMAPConfig) { c.Host = "imap.other.com" }},
+		{name: "password changed", edit: func(c *config.IMAPConfig) { c.Password = "secret" }},
+		{name: "scope changed", edit: func(c *config.IMAPConfig) { c.OAuth.Scopes[1] = "Mail.Read" }},
+		{name: "refresh token changed", edit: func(c *config.IMAPConfig) { c.OAuth.RefreshToken = "token" }},
+		{name: "max connections changed", edit: func(c *config.IMAPConfig) { c.MaxConnections = 5 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := poolSnapshot(loadIMAP(tt.edit))
+			if (got == base) != tt.same {
+				t.Errorf("snapshot equal = %v, want %v\nbase: %s\ngot:  %s", got == base, tt.same, base, got)
+			}
+		})
+	}
+}
+
+func TestAccountPoolReuse(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	t.Cleanup(func() { ClosePools(nil) })
+
+	acc := config.Account{Name: "work", IMAP: loadIMAP(nil)}
+	p := AccountPool(acc, logger)
+	if got := AccountPool(config.Account{Name: "work", IMAP: loadIMAP(nil)}, logger); got != p {
+		t.Error("pool reopened after reload with unchanged settings")
+	}
+
+	changed := config.Account{Name: "work", IMAP: loadIMAP(func(c *config.IMAPConfig) { c.Host = "imap.other.com" })}
+	if got := AccountPool(changed, logger); got == p {
+		t.Error("pool kept after IMAP settings changed")
+	}
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if !closed {
+		t.Error("previous pool is not closed")
+	}
+}
@@ -0,0 +1,117 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/state"
+	"go.uber.org/zap"
+)
+
+// gmailExtension — расширение IMAP Gmail с поддержкой меток X-GM-LABELS
+const gmailExtension = "X-GM-EXT-1"
+
+// PostProcess выполняет действия с доставленным письмом uid в выбранной папке.
+// actions — действия всех совпавших правил подряд; они упорядочиваются функцией orderPostActions,
+// чтобы перемещение или удаление письма из папки выполнялось последним и один раз.
+func PostProcess(c *client.Client, uid uint32, actions []config.PostAction) error {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+
+	for _, a := range orderPostActions(actions) {
+		if err := postAction(c, seqset, a); err != nil {
+			return fmt.Errorf("%s: %w", a.Action, err)
+		}
+	}
+	return nil
+}
+
+// orderPostActions упорядочивает действия нескольких правил: сначала по порядку выполняются
+// действия, после которых письмо остаётся в папке, затем одно завершающее — первый move
+// или, если move нет, expunge. Остальные move выполняются как copy, повторные expunge пропускаются.
+func orderPostActions(actions []config.PostAction) []config.PostAction {
+	var ordered []config.PostAction
+	var last *config.PostAction
+	for _, a := range actions {
+		switch {
+		case a.Action == config.PostMove && (last == nil || last.Action != config.PostMove):
+			last = &a
+		case a.Action == config.PostMove:
+			ordered = append(ordered, config.PostAction{Action: config.PostCopy, Folder: a.Folder})
+		case a.Action == config.PostExpunge:
+			if last == nil {
+				last = &a
+			}
+		default:
+			ordered = append(ordered, a)
+		}
+	}
+	if last != nil {
+		ordered = append(ordered, *last)
+	}
+	return ordered
+}
+
+// Relocates проверяет, перемещается или удаляется ли письмо из папки действиями actions:
+// после этого его UID в папке недействителен
+func Relocates(actions []config.PostAction) bool {
+	ordered := orderPostActions(actions)
+	if len(ordered) == 0 {
+		return false
+	}
+	last := ordered[len(ordered)-1].Action
+	return last == config.PostMove || last == config.PostExpunge
+}
+
+// PostProcessMessage подключается к почтовому ящику и выполняет действия с письмом uid
+// папки key, как PostProcess. Используется для писем, доставка которых подтверждена
+// уже после завершения их обработки.
+func PostProcessMessage(acc config.Account, key state.Key, uid uint32, actions []config.PostAction, logger *zap.SugaredLogger) error {
+	return withMessage(acc, key, uid, logger, func(c *client.Client, _ *imap.SeqSet) error {
+		return PostProcess(c, uid, actions)
+	})
+}
+
+// postAction выполняет одно действие над письмом
+func postAction(c *client.Client, seqset *imap.SeqSet, a config.PostAction) error {
+	switch a.Action {
+	case config.PostMove:
+		return moveMessages(c, seqset, a.Folder)
+	case config.PostCopy:
+		return c.UidCopy(seqset, a.Folder)
+	case config.PostAddFlags:
+		return c.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), values(a.Flags), nil)
+	case config.PostRemoveFlags:
+		return c.UidStore(seqset, imap.FormatFlagsOp(imap.RemoveFlags, true), values(a.Flags), nil)
+	case config.PostAddLabels, config.PostRemoveLabels:
+		ok, err := c.Support(gmailExtension)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("server does not support Gmail labels")
+		}
+		item := imap.StoreItem("+X-GM-LABELS")
+		if a.Action == config.PostRemoveLabels {
+			item = "-X-GM-LABELS"
+		}
+		return c.UidStore(seqset, item, values(a.Labels), nil)
+	case config.PostDelete:
+		return c.UidStore(seqset, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil)
+	case config.PostExpunge:
+		return expungeMessages(c, seqset)
+	default:
+		return fmt.Errorf("unknown action %q", a.Action)
+	}
+}
+
+func values(s []string) []interface{} {
+	v := make([]interface{}, len(s))
+	for i := range s {
+		v[i] = s[i]
+	}
+	return v
+}
@@ -0,0 +1,130 @@
+package email
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/state"
+	"go.uber.org/zap"
+)
+
+func TestOrderPostActions(t *testing.T) {
+	move := func(folder string) config.PostAction {
+		return config.PostAction{Action: config.PostMove, Folder: folder}
+	}
+	copyTo := func(folder string) config.PostAction {
+		return config.PostAction{Action: config.PostCopy, Folder: folder}
+	}
+	seen := config.PostAction{Action: config.PostAddFlags, Flags: []string{"\\Seen"}}
+	label := config.PostAction{Action: config.PostAddLabels, Labels: []string{"done"}}
+	del := config.PostAction{Action: config.PostDelete}
+	expunge := config.PostAction{Action: config.PostExpunge}
+
+	tests := []struct {
+		name      string
+		actions   []config.PostAction
+		want      []config.PostAction
+		relocates bool
+	}{
+		{name: "empty"},
+		{name: "single rule", actions: []config.PostAction{seen, move("Done")}, want: []config.PostAction{seen, move("Done")}, relocates: true},
+		{name: "flags only", actions: []config.PostAction{seen, label}, want: []config.PostAction{seen, label}},
+		{name: "delete keeps message", actions: []config.PostAction{del}, want: []config.PostAction{del}},
+		{name: "delete and expunge", actions: []config.PostAction{del, expunge}, want: []config.PostAction{del, expunge}, relocates: true},
+		{
+			// Перемещение первого правила выполняется последним, после действий второго
+			name:      "move before flags of next rule",
+			actions:   []config.PostAction{move("A"), seen},
+			want:      []config.PostAction{seen, move("A")},
+			relocates: true,
+		},
+		{
+			name:      "second move becomes copy",
+			actions:   []config.PostAction{move("A"), seen, move("B")},
+			want:      []config.PostAction{seen, copyTo("B"), move("A")},
+			relocates: true,
+		},
+		{
+			name:      "move wins over expunge",
+			actions:   []config.PostAction{del, expunge, move("A")},
+			want:      []config.PostAction{del, move("A")},
+			relocates: true,
+		},
+		{
+			name:      "repeated expunge once",
+			actions:   []config.PostAction{del, expunge, label, expunge},
+			want:      []config.PostAction{del, label, expunge},
+			relocates: true,
+		},
+		{name: "copy keeps message", actions: []config.PostAction{copyTo("A")}, want: []config.PostAction{copyTo("A")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orderPostActions(tt.actions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orderPostActions = %+v, want %+v", got, tt.want)
+			}
+			if got := Relocates(tt.actions); got != tt.relocates {
+				t.Errorf("Relocates = %v, want %v", got, tt.relocates)
+			}
+		})
+	}
+}
+
+func TestApplyActionMissingMessage(t *testing.T) {
+	acc := memoryAccount(serveMemory(t))
+	logger := zap.NewNop().Sugar()
+	tests := []struct {
+		name    string
+		key     state.Key
+		uid     uint32
+		wantErr error
+	}{
+		{name: "existing message", key: state.Key{Account: "work", Folder: "INBOX", UIDValidity: 1}, uid: 6},
+		{name: "moved or deleted message", key: state.Key{Account: "work", Folder: "INBOX", UIDValidity: 1}, uid: 7, wantErr: ErrMessageNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyAction(acc, tt.key, tt.uid, config.ActionFlag, "", logger)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ApplyAction error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := FetchMessage(acc, state.Key{Account: "work", Folder: "INBOX", UIDValidity: 1}, 7, logger); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("FetchMessage error = %v, want %v", err, ErrMessageNotFound)
+	}
+	if err := ApplyAction(acc, state.Key{Account: "work", Folder: "INBOX", UIDValidity: 2}, 6, config.ActionFlag, "", logger); err == nil {
+		t.Error("ApplyAction succeeded after UIDVALIDITY change")
+	}
+}
+
+func TestPostProcessMessage(t *testing.T) {
+	addr := serveMemory(t)
+	acc := memoryAccount(addr)
+	key := state.Key{Account: "work", Folder: "INBOX", UIDValidity: 1}
+	actions := []config.PostAction{
+		{Action: config.PostAddFlags, Flags: []string{"\\Flagged"}},
+		{Action: config.PostRemoveFlags, Flags: []string{"\\Seen"}},
+	}
+	if err := PostProcessMessage(acc, key, 6, actions, zap.NewNop().Sugar()); err != nil {
+		t.Fatalf("PostProcessMessage: %v", err)
+	}
+	if err := PostProcessMessage(acc, key, 7, actions, zap.NewNop().Sugar()); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("PostProcessMessage of missing message error = %v, want %v", err, ErrMessageNotFound)
+	}
+
+	c, err := ConnectToIMAP(acc, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Logout()
+	if _, err := c.Select("INBOX", true); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if got := folderFlags(t, c)[6]; !reflect.DeepEqual(got, []string{"\\Flagged"}) {
+		t.Errorf("flags = %v, want [\\Flagged]", got)
+	}
+}
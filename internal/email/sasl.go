@@ -0,0 +1,76 @@
+package email
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/emersion/go-imap/client"
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/oauth"
+	"go.uber.org/zap"
+)
+
+// oauthClient — клиент SASL для входа по токену OAuth2 механизмом XOAUTH2 или OAUTHBEARER
+type oauthClient struct {
+	mech     string
+	username string
+	token    string
+	host     string
+	port     int
+}
+
+// Start возвращает механизм и начальный ответ с токеном
+func (a *oauthClient) Start() (string, []byte, error) {
+	if a.mech == "XOAUTH2" {
+		return a.mech, []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+	}
+	// OAUTHBEARER, RFC 7628
+	ir := "n,a=" + a.username + ",\x01host=" + a.host + "\x01port=" + strconv.Itoa(a.port) +
+		"\x01auth=Bearer " + a.token + "\x01\x01"
+	return a.mech, []byte(ir), nil
+}
+
+// Next отвечает на сообщение сервера об ошибке, чтобы сервер завершил вход отказом
+func (a *oauthClient) Next(challenge []byte) ([]byte, error) {
+	if a.mech == "XOAUTH2" {
+		return []byte{}, nil
+	}
+	return []byte("\x01"), nil
+}
+
+// login выполняет вход на сервер паролем или токеном OAuth2 в зависимости от imap.auth
+func login(c *client.Client, acc config.Account, logger *zap.SugaredLogger) error {
+	mode := acc.IMAP.AuthMode()
+	if mode == config.IMAPAuthPassword {
+		return c.Login(acc.IMAP.Username, acc.IMAP.Password)
+	}
+
+	mech := "XOAUTH2"
+	if mode == config.IMAPAuthOAuthBearer {
+		mech = "OAUTHBEARER"
+	}
+	ok, err := c.SupportAuth(mech)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("server does not support AUTHENTICATE %s", mech)
+	}
+
+	token, err := oauth.AccessToken(acc, logger)
+	if err != nil {
+		return err
+	}
+	err = c.Authenticate(&oauthClient{
+		mech:     mech,
+		username: acc.IMAP.Username,
+		token:    token,
+		host:     acc.IMAP.Host,
+		port:     acc.IMAP.Port,
+	})
+	if err != nil {
+		// Токен мог быть отозван до истечения срока: при следующем входе он будет обновлён
+		oauth.Invalidate(acc.Name)
+	}
+	return err
+}
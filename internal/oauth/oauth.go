@@ -0,0 +1,421 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// expiryMargin — за сколько до истечения токен доступа обновляется заранее
+	expiryMargin = time.Minute
+	// staleLock — возраст, после которого блокировка хранилища считается брошенной
+	staleLock = 30 * time.Second
+	// lockRetry — пауза между попытками захватить блокировку хранилища
+	lockRetry = 20 * time.Millisecond
+)
+
+// stored — токен обновления учётной записи в хранилище
+type stored struct {
+	RefreshToken string `json:"refresh_token"`
+	// Seed — refresh_token из secrets.yaml на момент сохранения. Если в secrets.yaml
+	// задан другой токен, он считается более новым и используется вместо сохранённого.
+	Seed      string    `json:"seed,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// accessToken — полученный токен доступа и время его истечения
+type accessToken struct {
+	value   string
+	expires time.Time
+}
+
+// tokenResponse — ответ сервера авторизации на запрос токена
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// deviceResponse — ответ сервера авторизации на запрос кода устройства
+type deviceResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	// VerificationURL — то же поле в ответе Google
+	VerificationURL  string `json:"verification_url"`
+	ExpiresIn        int    `json:"expires_in"`
+	Interval         int    `json:"interval"`
+	Message          string `json:"message"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+var (
+	// mu защищает хранилище и кэш; на время запроса к серверу авторизации не удерживается
+	mu     sync.Mutex
+	path   string
+	tokens = make(map[string]stored)
+	cache  = make(map[string]accessToken)
+	// unsaved — учётные записи, новый токен обновления которых не удалось сохранить
+	unsaved = make(map[string]bool)
+	// refreshing упорядочивает обновления токенов одной учётной записи:
+	// провайдер может выдавать новый токен обновления при каждом обновлении
+	refreshing = make(map[string]*sync.Mutex)
+
+	httpClient = &http.Client{Timeout: 30 * time.Second}
+	// lockTimeout — сколько ждать блокировку хранилища, занятую другим процессом
+	lockTimeout = 5 * time.Second
+)
+
+// Open открывает хранилище токенов обновления
+func Open(p string) error {
+	loaded, err := readTokens(p)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	path, tokens = p, loaded
+	mu.Unlock()
+	return nil
+}
+
+// readTokens читает хранилище токенов обновления; отсутствующий файл — пустое хранилище
+func readTokens(p string) (map[string]stored, error) {
+	loaded := make(map[string]stored)
+	data, err := os.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read oauth tokens: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return nil, fmt.Errorf("cannot parse oauth tokens: %w", err)
+		}
+	}
+	return loaded, nil
+}
+
+// AccessToken возвращает действующий токен доступа учётной записи, при необходимости
+// обновляя его по токену обновления. Если новый токен обновления не удалось сохранить,
+// возвращает ошибку: после перезапуска сохранённый прежний токен может быть уже недействителен.
+// Новый токен остаётся в памяти, а сохранение повторяется при следующем вызове.
+func AccessToken(acc config.Account, logger *zap.SugaredLogger) (string, error) {
+	l := accountLock(acc.Name)
+	l.Lock()
+	defer l.Unlock()
+
+	mu.Lock()
+	if unsaved[acc.Name] {
+		if err := save(acc, tokens[acc.Name].RefreshToken); err != nil {
+			mu.Unlock()
+			return "", fmt.Errorf("cannot save oauth refresh token: %w", err)
+		}
+		delete(unsaved, acc.Name)
+		logger.Infow("oauth refresh token saved", "account", acc.Name)
+	}
+	if t, ok := cache[acc.Name]; ok && time.Until(t.expires) > expiryMargin {
+		mu.Unlock()
+		return t.value, nil
+	}
+	// Токен мог быть получен командой auth, пока сервис работает
+	if err := merge(); err != nil {
+		logger.Warnw("cannot reload oauth tokens", "error", err)
+	}
+	refreshToken := currentRefreshToken(acc)
+	mu.Unlock()
+
+	if refreshToken == "" {
+		return "", fmt.Errorf("no oauth refresh token for account %q, run mail2tg auth", acc.Name)
+	}
+
+	o := acc.IMAP.OAuth
+	tokenURL, _, scopes := o.Endpoints()
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {o.ClientID},
+		"refresh_token": {refreshToken},
+	}
+	if o.ClientSecret != "" {
+		form.Set("client_secret", o.ClientSecret)
+	}
+	if len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+
+	// Запрос выполняется без общей блокировки, чтобы не задерживать остальные учётные записи
+	var resp tokenResponse
+	if err := post(context.Background(), tokenURL, form, &resp); err != nil {
+		return "", fmt.Errorf("oauth token refresh failed: %w", err)
+	}
+	if resp.Error != "" || resp.AccessToken == "" {
+		return "", fmt.Errorf("oauth token refresh failed: %s", describe(resp.Error, resp.ErrorDescription))
+	}
+	logger.Infow("oauth access token refreshed", "account", acc.Name, "expires_in", resp.ExpiresIn)
+
+	mu.Lock()
+	defer mu.Unlock()
+	cache[acc.Name] = newAccessToken(resp)
+	if resp.RefreshToken != "" && resp.RefreshToken != refreshToken {
+		if err := save(acc, resp.RefreshToken); err != nil {
+			unsaved[acc.Name] = true
+			return "", fmt.Errorf("cannot save oauth refresh token: %w", err)
+		}
+	}
+	return resp.AccessToken, nil
+}
+
+// accountLock возвращает блокировку обновления токенов учётной записи
+func accountLock(account string) *sync.Mutex {
+	mu.Lock()
+	defer mu.Unlock()
+
+	l, ok := refreshing[account]
+	if !ok {
+		l = new(sync.Mutex)
+		refreshing[account] = l
+	}
+	return l
+}
+
+// Invalidate забывает токен доступа учётной записи, например после отказа сервера во входе
+func Invalidate(account string) {
+	mu.Lock()
+	delete(cache, account)
+	mu.Unlock()
+}
+
+// Authorize выполняет вход по коду устройства (RFC 8628): выводит в out адрес и код
+// для входа в браузере, дожидается подтверждения и сохраняет токен обновления
+func Authorize(ctx context.Context, acc config.Account, out io.Writer) error {
+	o := acc.IMAP.OAuth
+	tokenURL, deviceURL, scopes := o.Endpoints()
+	if deviceURL == "" {
+		return errors.New("device authorization is not configured, set oauth.provider or oauth.device_auth_url")
+	}
+
+	form := url.Values{"client_id": {o.ClientID}}
+	if len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+	var dev deviceResponse
+	if err := post(ctx, deviceURL, form, &dev); err != nil {
+		return fmt.Errorf("device authorization failed: %w", err)
+	}
+	if dev.Error != "" || dev.DeviceCode == "" {
+		return fmt.Errorf("device authorization failed: %s", describe(dev.Error, dev.ErrorDescription))
+	}
+
+	if dev.Message != "" {
+		fmt.Fprintln(out, dev.Message)
+	} else {
+		uri := dev.VerificationURI
+		if uri == "" {
+			uri = dev.VerificationURL
+		}
+		fmt.Fprintf(out, "Open %s and enter the code %s\n", uri, dev.UserCode)
+	}
+
+	interval := time.Duration(dev.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expiresIn := time.Duration(dev.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = 15 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, expiresIn)
+	defer cancel()
+
+	poll := url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {dev.DeviceCode},
+		"client_id":   {o.ClientID},
+	}
+	if o.ClientSecret != "" {
+		poll.Set("client_secret", o.ClientSecret)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("device authorization was not completed: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+
+		var resp tokenResponse
+		if err := post(ctx, tokenURL, poll, &resp); err != nil {
+			return fmt.Errorf("token request failed: %w", err)
+		}
+		switch resp.Error {
+		case "":
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += 5 * time.Second
+			continue
+		default:
+			return fmt.Errorf("device authorization failed: %s", describe(resp.Error, resp.ErrorDescription))
+		}
+		if resp.RefreshToken == "" {
+			return errors.New("no refresh token received, check that offline access is included in oauth.scopes")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err := save(acc, resp.RefreshToken); err != nil {
+			return err
+		}
+		delete(unsaved, acc.Name)
+		cache[acc.Name] = newAccessToken(resp)
+		return nil
+	}
+}
+
+// currentRefreshToken возвращает актуальный токен обновления: сохранённый в хранилище
+// или, если в secrets.yaml с тех пор задан другой, — из secrets.yaml. Вызывается под блокировкой.
+func currentRefreshToken(acc config.Account) string {
+	secret := acc.IMAP.OAuth.RefreshToken
+	if t, ok := tokens[acc.Name]; ok && t.RefreshToken != "" && t.Seed == secret {
+		return t.RefreshToken
+	}
+	return secret
+}
+
+// save сохраняет токен обновления учётной записи. Токен запоминается в памяти,
+// даже если записать хранилище не удалось. Перед записью хранилище перечитывается
+// под блокировкой файла: токены, сохранённые другим процессом (командой auth
+// при работающем сервисе), не затираются. Вызывается под блокировкой.
+func save(acc config.Account, refreshToken string) error {
+	tokens[acc.Name] = stored{RefreshToken: refreshToken, Seed: acc.IMAP.OAuth.RefreshToken, UpdatedAt: time.Now()}
+	if path == "" {
+		return errors.New("oauth token store is not opened")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("cannot create oauth tokens directory: %w", err)
+	}
+	unlock, err := lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := merge(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode oauth tokens: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("cannot write oauth tokens: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("cannot save oauth tokens: %w", err)
+	}
+	return nil
+}
+
+// merge дополняет токены в памяти токенами из хранилища: для каждой учётной записи
+// остаётся сохранённый позже. Вызывается под блокировкой.
+func merge() error {
+	if path == "" {
+		return nil
+	}
+	loaded, err := readTokens(path)
+	if err != nil {
+		return err
+	}
+	for name, t := range loaded {
+		if cur, ok := tokens[name]; !ok || t.UpdatedAt.After(cur.UpdatedAt) {
+			tokens[name] = t
+		}
+	}
+	return nil
+}
+
+// lockStore захватывает блокировку хранилища — файл рядом с ним, созданный с O_EXCL.
+// Блокировка старше staleLock считается оставшейся от завершившегося процесса и снимается.
+func lockStore() (unlock func(), err error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("cannot lock oauth tokens: %w", err)
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > staleLock {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("cannot lock oauth tokens: %s is held by another process", lock)
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+// post отправляет форму на адрес сервера авторизации и разбирает JSON-ответ в v.
+// Ошибки OAuth2 (поле error) возвращаются в v, а не как ошибка.
+func post(ctx context.Context, endpoint string, form url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("unexpected response %s: %w", resp.Status, err)
+	}
+	return nil
+}
+
+// newAccessToken возвращает токен доступа из ответа сервера авторизации
+func newAccessToken(resp tokenResponse) accessToken {
+	expiresIn := time.Duration(resp.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = time.Hour
+	}
+	return accessToken{value: resp.AccessToken, expires: time.Now().Add(expiresIn)}
+}
+
+// describe формирует текст ошибки OAuth2
+func describe(code, description string) string {
+	if code == "" {
+		code = "empty access token"
+	}
+	if description == "" {
+		return code
+	}
+	return code + ": " + description
+}
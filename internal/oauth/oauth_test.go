@@ -0,0 +1,313 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"go.uber.org/zap"
+)
+
+// openStore открывает пустое хранилище во временном каталоге и сбрасывает кэш токенов
+func openStore(t *testing.T) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "oauth.json")
+	if err := Open(p); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	mu.Lock()
+	cache = make(map[string]accessToken)
+	unsaved = make(map[string]bool)
+	mu.Unlock()
+	t.Cleanup(func() { Open("") })
+	return p
+}
+
+// writeStore записывает хранилище так, как это делает другой процесс
+func writeStore(t *testing.T, p string, m map[string]stored) {
+	t.Helper()
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readStore(t *testing.T, p string) map[string]stored {
+	t.Helper()
+	m, err := readTokens(p)
+	if err != nil {
+		t.Fatalf("readTokens: %v", err)
+	}
+	return m
+}
+
+// tokenServer — сервер авторизации: выдаёт токены в ответ на токены обновления из issue
+// и запоминает полученные токены обновления
+type tokenServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	issue    map[string]tokenResponse
+	received []string
+}
+
+func newTokenServer(t *testing.T, issue map[string]tokenResponse) *tokenServer {
+	t.Helper()
+	s := &tokenServer{issue: issue}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("ParseForm: %v", err)
+		}
+		rt := r.PostForm.Get("refresh_token")
+		s.mu.Lock()
+		s.received = append(s.received, rt)
+		s.mu.Unlock()
+		resp, ok := issue[rt]
+		if !ok {
+			resp = tokenResponse{Error: "invalid_grant"}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func account(name, tokenURL, seed string) config.Account {
+	return config.Account{Name: name, IMAP: config.IMAPConfig{
+		Auth:  config.IMAPAuthXOAuth2,
+		OAuth: config.OAuthConfig{TokenURL: tokenURL, ClientID: "client", RefreshToken: seed},
+	}}
+}
+
+func TestSaveMerges(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		// disk — хранилище, записанное другим процессом после открытия
+		disk   map[string]stored
+		memory map[string]stored
+		want   map[string]string
+	}{
+		{
+			name: "other account kept",
+			disk: map[string]stored{"home": {RefreshToken: "home-1", UpdatedAt: now}},
+			want: map[string]string{"home": "home-1", "work": "work-new"},
+		},
+		{
+			name:   "newer token from disk wins",
+			disk:   map[string]stored{"home": {RefreshToken: "home-2", UpdatedAt: now}},
+			memory: map[string]stored{"home": {RefreshToken: "home-1", UpdatedAt: now.Add(-time.Hour)}},
+			want:   map[string]string{"home": "home-2", "work": "work-new"},
+		},
+		{
+			name:   "older token on disk ignored",
+			disk:   map[string]stored{"home": {RefreshToken: "home-1", UpdatedAt: now.Add(-time.Hour)}},
+			memory: map[string]stored{"home": {RefreshToken: "home-2", UpdatedAt: now}},
+			want:   map[string]string{"home": "home-2", "work": "work-new"},
+		},
+		{
+			name: "saved token replaces older one on disk",
+			disk: map[string]stored{"work": {RefreshToken: "work-old", UpdatedAt: now.Add(-time.Hour)}},
+			want: map[string]string{"work": "work-new"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := openStore(t)
+			mu.Lock()
+			for name, s := range tt.memory {
+				tokens[name] = s
+			}
+			mu.Unlock()
+			writeStore(t, p, tt.disk)
+
+			mu.Lock()
+			err := save(account("work", "", ""), "work-new")
+			mu.Unlock()
+			if err != nil {
+				t.Fatalf("save: %v", err)
+			}
+
+			got := make(map[string]string)
+			for name, s := range readStore(t, p) {
+				got[name] = s.RefreshToken
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("stored = %v, want %v", got, tt.want)
+			}
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("stored %s = %q, want %q", name, got[name], want)
+				}
+			}
+			if _, err := os.Stat(p + ".lock"); !os.IsNotExist(err) {
+				t.Errorf("lock is not released: %v", err)
+			}
+		})
+	}
+}
+
+func TestSaveLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		age     time.Duration
+		wantErr bool
+	}{
+		{name: "held by another process", wantErr: true},
+		{name: "stale lock removed", age: 2 * staleLock},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := openStore(t)
+			timeout := lockTimeout
+			lockTimeout = 100 * time.Millisecond
+			t.Cleanup(func() { lockTimeout = timeout })
+
+			if err := os.WriteFile(p+".lock", nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			modified := time.Now().Add(-tt.age)
+			if err := os.Chtimes(p+".lock", modified, modified); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			err := save(account("work", "", ""), "work-new")
+			mu.Unlock()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("save error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// Токен остаётся в памяти, даже если хранилище не записано
+			mu.Lock()
+			got := tokens["work"].RefreshToken
+			mu.Unlock()
+			if got != "work-new" {
+				t.Errorf("token in memory = %q, want work-new", got)
+			}
+			if _, ok := readStore(t, p)["work"]; ok == tt.wantErr {
+				t.Errorf("token stored = %v, want %v", ok, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	tests := []struct {
+		name string
+		seed string
+		// disk — хранилище, записанное командой auth после запуска сервиса
+		disk         map[string]stored
+		wantSent     string
+		wantAccess   string
+		wantStored   string
+		wantErr      bool
+		wantNoStored bool
+	}{
+		{name: "token from secrets", seed: "seed", wantSent: "seed", wantAccess: "access-seed", wantNoStored: true},
+		{name: "rotated token saved", seed: "rotating", wantSent: "rotating", wantAccess: "access-rotating", wantStored: "rotated"},
+		{
+			name:       "token authorized while running",
+			seed:       "seed",
+			disk:       map[string]stored{"work": {RefreshToken: "authorized", Seed: "seed", UpdatedAt: time.Now()}},
+			wantSent:   "authorized",
+			wantAccess: "access-authorized",
+			wantStored: "authorized",
+		},
+		{
+			// Токен из secrets.yaml изменён после сохранения: используется он
+			name:       "changed secret wins",
+			seed:       "seed",
+			disk:       map[string]stored{"work": {RefreshToken: "authorized", Seed: "old-seed", UpdatedAt: time.Now()}},
+			wantSent:   "seed",
+			wantAccess: "access-seed",
+			wantStored: "authorized",
+		},
+		{name: "rejected", seed: "revoked", wantSent: "revoked", wantErr: true, wantNoStored: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTokenServer(t, map[string]tokenResponse{
+				"seed":       {AccessToken: "access-seed", ExpiresIn: 3600},
+				"authorized": {AccessToken: "access-authorized", ExpiresIn: 3600},
+				"rotating":   {AccessToken: "access-rotating", RefreshToken: "rotated", ExpiresIn: 3600},
+			})
+			p := openStore(t)
+			if tt.disk != nil {
+				writeStore(t, p, tt.disk)
+			}
+			acc := account("work", srv.URL, tt.seed)
+
+			got, err := AccessToken(acc, logger)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AccessToken error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.wantAccess {
+				t.Errorf("access token = %q, want %q", got, tt.wantAccess)
+			}
+			if len(srv.received) != 1 || srv.received[0] != tt.wantSent {
+				t.Errorf("refresh tokens sent = %v, want [%s]", srv.received, tt.wantSent)
+			}
+			s, ok := readStore(t, p)["work"]
+			if ok == tt.wantNoStored || s.RefreshToken != tt.wantStored {
+				t.Errorf("stored = %+v (%v), want %q", s, ok, tt.wantStored)
+			}
+
+			// Действующий токен доступа берётся из кэша
+			if !tt.wantErr {
+				if again, _ := AccessToken(acc, logger); again != got || len(srv.received) != 1 {
+					t.Errorf("cached token not reused: %q, %d requests", again, len(srv.received))
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(deviceResponse{DeviceCode: "device", UserCode: "ABCD", VerificationURI: "https://example.com/device", Interval: 1})
+	})
+	polls := 0
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("device_code") != "device" {
+			t.Errorf("device_code = %q", r.PostForm.Get("device_code"))
+		}
+		polls++
+		if polls == 1 {
+			json.NewEncoder(w).Encode(tokenResponse{Error: "authorization_pending"})
+			return
+		}
+		json.NewEncoder(w).Encode(tokenResponse{AccessToken: "access", RefreshToken: "authorized", ExpiresIn: 3600})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	p := openStore(t)
+	// Сервис тем временем сохранил токен другой учётной записи
+	writeStore(t, p, map[string]stored{"home": {RefreshToken: "home-1", UpdatedAt: time.Now()}})
+
+	acc := account("work", srv.URL+"/token", "")
+	acc.IMAP.OAuth.DeviceAuthURL = srv.URL + "/device"
+	if err := Authorize(context.Background(), acc, io.Discard); err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	stored := readStore(t, p)
+	if stored["work"].RefreshToken != "authorized" || stored["home"].RefreshToken != "home-1" {
+		t.Errorf("stored = %+v, want work=authorized and home=home-1", stored)
+	}
+	if got, err := AccessToken(acc, zap.NewNop().Sugar()); err != nil || got != "access" {
+		t.Errorf("AccessToken = %q, %v; want access", got, err)
+	}
+}
@@ -0,0 +1,154 @@
+package postprocess
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/state"
+	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"github.com/st-kuptsov/mail2tg/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// retention — сколько действия с письмом ждут отправки сообщений о нём. Столько же
+// хранятся ссылки кнопок: недоставленное сообщение можно повторить из хранилища недоставленных.
+const retention = 30 * 24 * time.Hour
+
+// waiting — действия с письмом, ожидающие отправки сообщений о нём
+type waiting struct {
+	Actions []config.PostAction `json:"actions"`
+	// Messages — ссылки сообщений о письме (Message.Ref), которые ещё не отправлены
+	Messages []string `json:"messages"`
+}
+
+var (
+	mu      sync.Mutex
+	pending *state.Index
+	conf    *config.CachedConfig
+	logger  *zap.SugaredLogger
+
+	// unsent возвращает неотправленные сообщения с префиксом ссылки
+	unsent = telegram.Pending
+	// run выполняет действия с письмом, доставка которого подтверждена
+	run = apply
+)
+
+// Open открывает хранилище отложенных действий с письмами и начинает выполнять их
+// после отправки сообщений. Вызывается до запуска очереди Telegram.
+func Open(path string, c *config.CachedConfig, l *zap.SugaredLogger) error {
+	idx, err := state.OpenIndex(path, retention)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	pending, conf, logger = idx, c, l
+	mu.Unlock()
+	telegram.OnSent(onSent)
+	return nil
+}
+
+// Defer откладывает действия actions с письмом ref до отправки всех сообщений о нём,
+// которые ещё стоят в очереди Telegram или лежат в хранилище недоставленных.
+// Возвращает false, если таких сообщений нет: доставка подтверждена, и действия
+// выполняются вызывающим сразу.
+func Defer(ref string, actions []config.PostAction) (bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if pending == nil {
+		return false, errors.New("post actions store is not opened")
+	}
+	// Блокировка удерживается до сохранения, поэтому onSent не пропустит сообщение,
+	// отправленное во время проверки очереди
+	messages := unsent(ref + "|")
+	if len(messages) == 0 {
+		return false, nil
+	}
+	if err := save(ref, waiting{Actions: actions, Messages: messages}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// onSent отмечает сообщение отправленным и, если о письме больше нечего отправлять,
+// выполняет отложенные действия с ним
+func onSent(s telegram.SentMessage) {
+	// Ссылка сообщения — ссылка на письмо и получатель через |
+	i := strings.LastIndex(s.Ref, "|")
+	if i < 0 {
+		return
+	}
+	ref := s.Ref[:i]
+
+	mu.Lock()
+	data, ok := pending.Get(ref)
+	if !ok {
+		mu.Unlock()
+		return
+	}
+	var w waiting
+	if err := json.Unmarshal([]byte(data), &w); err != nil {
+		mu.Unlock()
+		logger.Warnw("cannot parse post actions", "ref", ref, "error", err)
+		return
+	}
+	n := slices.Index(w.Messages, s.Ref)
+	if n < 0 {
+		mu.Unlock()
+		return
+	}
+	w.Messages = slices.Delete(w.Messages, n, n+1)
+	if len(w.Messages) > 0 {
+		if err := save(ref, w); err != nil {
+			logger.Warnw("cannot save post actions", "ref", ref, "error", err)
+		}
+		mu.Unlock()
+		return
+	}
+	if err := pending.Delete(ref); err != nil {
+		logger.Warnw("cannot remove post actions", "ref", ref, "error", err)
+	}
+	mu.Unlock()
+
+	// Обработчик вызывается очередью чата: действия через IMAP её не задерживают
+	go run(ref, w.Actions)
+}
+
+// save сохраняет действия с письмом ref. Вызывается под блокировкой.
+func save(ref string, w waiting) error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return fmt.Errorf("cannot encode post actions: %w", err)
+	}
+	if err := pending.Put(ref, string(data)); err != nil {
+		return fmt.Errorf("cannot save post actions: %w", err)
+	}
+	return nil
+}
+
+// apply подключается к почтовому ящику и выполняет действия с письмом ref
+func apply(ref string, actions []config.PostAction) {
+	key, uid, err := state.ParseRef(ref)
+	if err != nil {
+		logger.Errorw("post-delivery actions failed", "ref", ref, "error", err)
+		return
+	}
+	acc, ok := email.FindAccount(conf.Current(), key.Account)
+	if !ok {
+		logger.Errorw("post-delivery actions failed", "ref", ref, "error", "account is not configured")
+		return
+	}
+	if err := email.PostProcessMessage(acc, key, uid, actions, logger); err != nil {
+		logger.Errorw("post-delivery actions failed", "folder", key.Folder, "uid", uid, "error", err)
+		metrics.MailErrors.Inc()
+		return
+	}
+	logger.Infow("post-delivery actions applied", "folder", key.Folder, "uid", uid, "actions", len(actions))
+}
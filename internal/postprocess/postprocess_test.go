@@ -0,0 +1,127 @@
+package postprocess
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"go.uber.org/zap"
+)
+
+// applied — вызов run с действиями над письмом
+type applied struct {
+	ref     string
+	actions []config.PostAction
+}
+
+// stub открывает хранилище во временном каталоге и подменяет очередь Telegram
+// сообщениями queued и выполнение действий записью в канал
+func stub(t *testing.T, path string, queued ...string) chan applied {
+	t.Helper()
+	if err := Open(path, nil, zap.NewNop().Sugar()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	ch := make(chan applied, 4)
+	prevUnsent, prevRun := unsent, run
+	unsent = func(prefix string) []string {
+		var refs []string
+		for _, ref := range queued {
+			if len(ref) >= len(prefix) && ref[:len(prefix)] == prefix {
+				refs = append(refs, ref)
+			}
+		}
+		return refs
+	}
+	run = func(ref string, actions []config.PostAction) { ch <- applied{ref, actions} }
+	t.Cleanup(func() { unsent, run = prevUnsent, prevRun })
+	return ch
+}
+
+func expectRun(t *testing.T, ch chan applied, want *applied) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		if want == nil || !reflect.DeepEqual(got, *want) {
+			t.Errorf("post actions run = %+v, want %+v", got, want)
+		}
+	case <-time.After(100 * time.Millisecond):
+		if want != nil {
+			t.Errorf("post actions are not run, want %+v", *want)
+		}
+	}
+}
+
+func TestDefer(t *testing.T) {
+	actions := []config.PostAction{{Action: config.PostMove, Folder: "Done"}}
+	const ref = "work|INBOX|1|6"
+	tests := []struct {
+		name     string
+		queued   []string
+		deferred bool
+		// sent — сообщения, об отправке которых сообщает очередь
+		sent []string
+		// runAfter — после какого из sent выполняются действия; -1 — не выполняются
+		runAfter int
+	}{
+		{name: "all sent", queued: []string{"work|INBOX|1|7|-100"}, runAfter: -1},
+		{
+			name:     "one queued",
+			queued:   []string{ref + "|-100"},
+			deferred: true,
+			sent:     []string{ref + "|-100"},
+			runAfter: 0,
+		},
+		{
+			name:     "waits for every message",
+			queued:   []string{ref + "|-100", ref + "|-200/5"},
+			deferred: true,
+			sent:     []string{ref + "|-200/5", ref + "|-300", "digest|" + ref + "|-100", ref + "|-100"},
+			runAfter: 3,
+		},
+		{
+			// Письмо с UID 60 — другое, хотя его ссылка начинается так же
+			name:     "other message",
+			queued:   []string{ref + "|-100", ref + "0|-100"},
+			deferred: true,
+			sent:     []string{ref + "0|-100"},
+			runAfter: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := stub(t, filepath.Join(t.TempDir(), "post_actions.json"), tt.queued...)
+			deferred, err := Defer(ref, actions)
+			if err != nil || deferred != tt.deferred {
+				t.Fatalf("Defer = %v, %v; want %v", deferred, err, tt.deferred)
+			}
+			for i, s := range tt.sent {
+				onSent(telegram.SentMessage{Ref: s, MessageID: 1})
+				if i == tt.runAfter {
+					expectRun(t, ch, &applied{ref, actions})
+				}
+			}
+			expectRun(t, ch, nil)
+			if _, ok := pending.Get(ref); ok != (tt.deferred && tt.runAfter < 0) {
+				t.Errorf("post actions stored = %v after sending", ok)
+			}
+		})
+	}
+}
+
+func TestDeferSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "post_actions.json")
+	const ref = "work|INBOX|1|6"
+	actions := []config.PostAction{{Action: config.PostExpunge}}
+	stub(t, path, ref+"|-100", ref+"|-200")
+	if deferred, err := Defer(ref, actions); !deferred || err != nil {
+		t.Fatalf("Defer = %v, %v; want deferred", deferred, err)
+	}
+	onSent(telegram.SentMessage{Ref: ref + "|-100"})
+
+	ch := stub(t, path)
+	onSent(telegram.SentMessage{Ref: ref + "|-200"})
+	expectRun(t, ch, &applied{ref, actions})
+}
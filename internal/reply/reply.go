@@ -0,0 +1,137 @@
+package reply
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/state"
+	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"go.uber.org/zap"
+)
+
+// retention — срок, в течение которого на пересланное письмо можно ответить из Telegram
+const retention = 30 * 24 * time.Hour
+
+// Original — сведения о письме, необходимые для ответа на него
+type Original struct {
+	Ref        string `json:"ref"`
+	MessageID  string `json:"message_id,omitempty"`
+	References string `json:"references,omitempty"`
+	Subject    string `json:"subject"`
+	// To — адрес для ответа: Reply-To или From исходного письма
+	To string `json:"to"`
+	// Channels — каналы, из которых разрешён ответ на письмо
+	Channels []string `json:"channels"`
+}
+
+var (
+	originals *state.Index
+	logger    *zap.SugaredLogger
+)
+
+// Open открывает хранилище писем, на которые можно ответить, и начинает
+// связывать их с отправленными в Telegram сообщениями
+func Open(path string, l *zap.SugaredLogger) error {
+	idx, err := state.OpenIndex(path, retention)
+	if err != nil {
+		return err
+	}
+	originals, logger = idx, l
+	telegram.OnSent(onSent)
+	return nil
+}
+
+// Remember разрешает ответ на письмо msg из канала channel
+func Remember(msg email.Decoded, channel string) error {
+	if originals == nil || msg.Ref == "" {
+		return nil
+	}
+
+	o := Original{
+		Ref:        msg.Ref,
+		MessageID:  strings.TrimSpace(msg.Header.Get("Message-ID")),
+		References: strings.TrimSpace(msg.Header.Get("References")),
+		Subject:    msg.Subject,
+		To:         replyAddress(msg),
+	}
+	if data, ok := originals.Get(mailKey(msg.Ref)); ok {
+		var prev Original
+		if err := json.Unmarshal([]byte(data), &prev); err == nil {
+			o.Channels = prev.Channels
+		}
+	}
+	if !slices.Contains(o.Channels, channel) {
+		o.Channels = append(o.Channels, channel)
+	}
+
+	data, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("cannot encode original message: %w", err)
+	}
+	return originals.Put(mailKey(msg.Ref), string(data))
+}
+
+// Lookup возвращает письмо, которое было переслано сообщением messageID в чате chatID
+func Lookup(chatID int64, messageID int) (Original, bool) {
+	var o Original
+	if originals == nil {
+		return o, false
+	}
+	data, ok := originals.Get(messageKey(chatID, messageID))
+	if !ok {
+		return o, false
+	}
+	if err := json.Unmarshal([]byte(data), &o); err != nil {
+		return o, false
+	}
+	return o, true
+}
+
+// onSent связывает отправленное сообщение с письмом, если из его канала разрешён ответ
+func onSent(s telegram.SentMessage) {
+	i := strings.LastIndex(s.Ref, "|")
+	if i < 0 {
+		return
+	}
+	ref, channel := s.Ref[:i], s.Ref[i+1:]
+
+	data, ok := originals.Get(mailKey(ref))
+	if !ok {
+		return
+	}
+	var o Original
+	if err := json.Unmarshal([]byte(data), &o); err != nil || !slices.Contains(o.Channels, channel) {
+		return
+	}
+	if err := originals.Put(messageKey(s.ChatID, s.MessageID), data); err != nil {
+		logger.Warnw("cannot save reply reference", "chat", s.ChatID, "message", s.MessageID, "error", err)
+	}
+}
+
+// replyAddress возвращает адрес для ответа: Reply-To, а если его нет — From
+func replyAddress(msg email.Decoded) string {
+	for _, h := range []string{"Reply-To", "From"} {
+		if v := msg.Header.Get(h); v != "" {
+			if addr, err := mail.ParseAddress(v); err == nil {
+				return addr.Address
+			}
+		}
+	}
+	if addr, err := mail.ParseAddress(msg.From); err == nil {
+		return addr.Address
+	}
+	return ""
+}
+
+func mailKey(ref string) string {
+	return "mail:" + ref
+}
+
+func messageKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("tg:%d:%d", chatID, messageID)
+}
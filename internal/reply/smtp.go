@@ -0,0 +1,154 @@
+package reply
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+)
+
+// Send отправляет через SMTP ответ с текстом text на письмо o.
+// Заголовки In-Reply-To и References связывают ответ с исходной перепиской.
+func Send(cfg config.SMTPConfig, o Original, text string) error {
+	if cfg.Host == "" {
+		return errors.New("smtp is not configured")
+	}
+	from := cfg.From
+	if from == "" {
+		from = cfg.Username
+	}
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return fmt.Errorf("invalid smtp.from %q: %w", from, err)
+	}
+	toAddr, err := mail.ParseAddress(o.To)
+	if err != nil {
+		return fmt.Errorf("invalid reply address %q: %w", o.To, err)
+	}
+
+	msg, err := buildReply(fromAddr, toAddr, o, text)
+	if err != nil {
+		return err
+	}
+
+	c, err := dialSMTP(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return fmt.Errorf("SMTP auth failed: %w", err)
+		}
+	}
+	if err := c.Mail(fromAddr.Address); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %w", err)
+	}
+	if err := c.Rcpt(toAddr.Address); err != nil {
+		return fmt.Errorf("SMTP RCPT TO failed: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("SMTP write failed: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP DATA failed: %w", err)
+	}
+	return c.Quit()
+}
+
+// dialSMTP подключается к SMTP-серверу по неявному TLS или через STARTTLS
+func dialSMTP(cfg config.SMTPConfig) (*smtp.Client, error) {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+
+	if cfg.Security == config.SMTPSecurityTLS {
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to SMTP: %w", err)
+		}
+		c, err := smtp.NewClient(conn, cfg.Host)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to create SMTP client: %w", err)
+		}
+		return c, nil
+	}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SMTP: %w", err)
+	}
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create SMTP client: %w", err)
+	}
+	if err := c.StartTLS(tlsConfig); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("SMTP STARTTLS failed: %w", err)
+	}
+	return c, nil
+}
+
+// buildReply формирует текст ответного письма в формате RFC 5322
+func buildReply(from, to *mail.Address, o Original, text string) ([]byte, error) {
+	subject := o.Subject
+	if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = "Re: " + subject
+	}
+
+	var b bytes.Buffer
+	header := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+		}
+	}
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(from.Address))
+	header("In-Reply-To", o.MessageID)
+	header("References", strings.TrimSpace(o.References+" "+o.MessageID))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	b.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n"))); err != nil {
+		return nil, fmt.Errorf("cannot encode reply: %w", err)
+	}
+	if err := qp.Close(); err != nil {
+		return nil, fmt.Errorf("cannot encode reply: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// messageID генерирует уникальный Message-ID в домене отправителя
+func messageID(from string) string {
+	domain := "mail2tg.local"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	var rnd [12]byte
+	_, _ = rand.Read(rnd[:])
+	return fmt.Sprintf("<%d.%x@%s>", time.Now().UnixNano(), rnd, domain)
+}
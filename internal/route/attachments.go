@@ -0,0 +1,60 @@
+package route
+
+import (
+	"path"
+	"strings"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"go.uber.org/zap"
+)
+
+// defaultMaxAttachmentMB — лимит размера вложения по умолчанию (Telegram принимает до 50 МБ)
+const defaultMaxAttachmentMB = 20
+
+// selectAttachments отбирает вложения письма по настройкам правила:
+// лимиту размера и спискам разрешённых и запрещённых MIME-типов.
+func selectAttachments(ac config.AttachmentConfig, attachments []email.Attachment, logger *zap.SugaredLogger) []telegram.Attachment {
+	if !ac.Enabled || len(attachments) == 0 {
+		return nil
+	}
+
+	maxSize := ac.MaxSizeMB
+	if maxSize <= 0 {
+		maxSize = defaultMaxAttachmentMB
+	}
+
+	var result []telegram.Attachment
+	for _, a := range attachments {
+		switch {
+		case len(a.Data) > maxSize<<20:
+			logger.Infow("attachment skipped: too large", "filename", a.Filename, "size", len(a.Data), "max_size_mb", maxSize)
+			continue
+		case matchMIME(ac.Deny, a.ContentType):
+			logger.Infow("attachment skipped: denied type", "filename", a.Filename, "content_type", a.ContentType)
+			continue
+		case len(ac.Allow) > 0 && !matchMIME(ac.Allow, a.ContentType):
+			logger.Infow("attachment skipped: type not allowed", "filename", a.Filename, "content_type", a.ContentType)
+			continue
+		}
+
+		result = append(result, telegram.Attachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Data:        a.Data,
+		})
+	}
+	return result
+}
+
+// matchMIME проверяет MIME-тип по списку масок вида "image/*"
+func matchMIME(patterns []string, contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), contentType); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package route
+
+import (
+	"fmt"
+	"net/textproto"
+	"regexp"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+)
+
+// matchRule проверяет письмо по правилу: тема по pattern и условия match.
+// Правило без pattern и match совпадает с любым письмом.
+func matchRule(rule config.Rule, msg email.Decoded) (bool, error) {
+	if rule.Pattern != "" {
+		matched, err := regexp.MatchString(rule.Pattern, msg.Subject)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	if rule.Match == nil {
+		return true, nil
+	}
+	return matchCondition(*rule.Match, msg)
+}
+
+// matchCondition вычисляет условие: все заданные поля, all, any и not объединяются по AND
+func matchCondition(c config.Condition, msg email.Decoded) (bool, error) {
+	checks := []struct {
+		pattern string
+		values  []string
+	}{
+		{c.Subject, []string{msg.Subject}},
+		{c.From, []string{msg.From}},
+		{c.To, msg.To},
+		{c.Cc, msg.Cc},
+		{c.Recipients, append(append([]string{}, msg.To...), msg.Cc...)},
+		{c.ListID, msg.Header["List-Id"]},
+		{c.Folder, []string{msg.Folder}},
+		{c.Body, []string{msg.Body}},
+	}
+	for name, pattern := range c.Headers {
+		checks = append(checks, struct {
+			pattern string
+			values  []string
+		}{pattern, msg.Header[textproto.CanonicalMIMEHeaderKey(name)]})
+	}
+
+	for _, check := range checks {
+		if check.pattern == "" {
+			continue
+		}
+		matched, err := matchAny(check.pattern, check.values)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+
+	if c.HasAttachments != nil && *c.HasAttachments != (len(msg.Attachments) > 0) {
+		return false, nil
+	}
+
+	for _, sub := range c.All {
+		matched, err := matchCondition(sub, msg)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+
+	if len(c.Any) > 0 {
+		anyMatched := false
+		for _, sub := range c.Any {
+			matched, err := matchCondition(sub, msg)
+			if err != nil {
+				return false, err
+			}
+			if matched {
+				anyMatched = true
+				break
+			}
+		}
+		if !anyMatched {
+			return false, nil
+		}
+	}
+
+	if c.Not != nil {
+		matched, err := matchCondition(*c.Not, msg)
+		if err != nil || matched {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// matchAny проверяет, совпадает ли регулярное выражение хотя бы с одним значением
+func matchAny(pattern string, values []string) (bool, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MatchedRules возвращает правила, совпавшие с письмом, в порядке их проверки
+// при маршрутизации: до первого совпавшего правила без continue.
+func MatchedRules(f config.Folder, msg email.Decoded) []config.Rule {
+	var matched []config.Rule
+	for _, rule := range f.Rules {
+		if ok, err := matchRule(rule, msg); err != nil || !ok {
+			continue
+		}
+		matched = append(matched, rule)
+		if !rule.Continue {
+			break
+		}
+	}
+	return matched
+}
+
+// PostActions возвращает post_actions всех правил, совпавших с письмом, подряд
+func PostActions(f config.Folder, msg email.Decoded) []config.PostAction {
+	var actions []config.PostAction
+	for _, rule := range MatchedRules(f, msg) {
+		actions = append(actions, rule.PostActions...)
+	}
+	return actions
+}
@@ -1,43 +1,298 @@
 package route
 
 import (
+	"errors"
 	"fmt"
-	"regexp"
+	"time"
 
 	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/actions"
+	"github.com/st-kuptsov/mail2tg/internal/dedup"
+	"github.com/st-kuptsov/mail2tg/internal/digest"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/reply"
 	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"github.com/st-kuptsov/mail2tg/internal/threads"
 	"go.uber.org/zap"
+	tb "gopkg.in/telebot.v3"
 )
 
-// RouteMessage проверяет тему письма по правилам маршрутизации и отправляет
-// его в соответствующий Telegram-канал. Если ни одно правило не совпало,
-// сообщение отправляется в канал по умолчанию.
-func RouteMessage(cfg *config.Config, f config.Folder, subject, body string, logger *zap.SugaredLogger) {
-	for _, rule := range f.Rules {
-		logger.Debugw("checking pattern for email",
+// RouteMessage проверяет письмо по правилам маршрутизации (тема, отправитель,
+// получатели, заголовки, тело, вложения) и отправляет его во все каналы совпавших правил.
+// Проверка правил прекращается на первом совпавшем правиле без continue.
+// Если ни одно правило не совпало, сообщение отправляется в канал по умолчанию.
+// Вне окна schedule правила письма перенаправляются, отправляются без звука или откладываются.
+// Письма правил с mode: digest откладываются в сводку, которая отправляется по расписанию.
+// Повторы письма в окне dedup правила не отправляются, а их количество указывается
+// в сообщении о следующем доставленном письме.
+// Получатели из done пропускаются: им письмо уже доставлено при прошлой попытке.
+// Если после доставки письмо перемещается или удаляется из папки (post_actions),
+// кнопки действий к сообщениям не добавляются: UID письма станет недействительным.
+// Возвращает всех получателей (chat_id или chat_id/message_thread_id, digest#N для сводки
+// правила N), которым письмо доставлено, получателей из их числа, сообщения для которых
+// остались в очереди Telegram, и ошибку, если доставка хотя бы одному получателю не удалась.
+func RouteMessage(cfg *config.Config, f config.Folder, msg email.Decoded, done []string, logger *zap.SugaredLogger) (delivered, queued []string, err error) {
+	delivered = append([]string(nil), done...)
+	seen := make(map[string]bool)
+	for _, ch := range done {
+		seen[ch] = true
+	}
+
+	// После перемещения или удаления письма из папки кнопки действий с ним не работали бы
+	relocated := email.Relocates(PostActions(f, msg))
+	if relocated {
+		logger.Debugw("email is moved after delivery, sending without buttons", "folder", f.Name)
+	}
+
+	var errs []error
+	send := func(d config.Destination, m telegram.Message) {
+		key := d.String()
+		if seen[key] {
+			logger.Debugw("channel already received the message, skipping", "channel", key)
+			return
+		}
+		seen[key] = true
+		m.Channel, m.ThreadID = d.ChatID, d.ThreadID
+		if msg.Ref != "" {
+			m.Ref = msg.Ref + "|" + key
+		}
+		m.ReplyTo = threads.Track(cfg.Telegram.Threads, msg, key, m.Ref)
+
+		status, err := telegram.Deliver(m, logger)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("channel %s: %w", key, err))
+			return
+		}
+		delivered = append(delivered, key)
+		if status == telegram.Queued {
+			queued = append(queued, key)
+		}
+	}
+	buttonsFor := func(bc config.ButtonsConfig) []telegram.Button {
+		if relocated {
+			return nil
+		}
+		return actions.Buttons(bc, msg.Ref, logger)
+	}
+
+	matchedAny := false
+	for i, rule := range f.Rules {
+		logger.Debugw("checking rule for email",
 			"pattern", rule.Pattern,
-			"subject", subject,
+			"subject", msg.Subject,
+			"from", msg.From,
 		)
 
-		matched, err := regexp.MatchString(rule.Pattern, subject)
+		matched, err := matchRule(rule, msg)
 		if err != nil {
 			logger.Warnw("failed to match pattern", "pattern", rule.Pattern, "error", err)
 			continue
 		}
+		if !matched {
+			continue
+		}
+
+		matchedAny = true
+		dc := cfg.Dedup
+		if rule.Dedup != nil {
+			dc = *rule.Dedup
+		}
+		ruleID := dedup.RuleKey(rule, i+1)
+		duplicate, suppressed := dedup.Check(dc, msg, ruleID, logger)
+		if duplicate {
+			logger.Infow("duplicate email suppressed", "rule", ruleName(rule), "subject", msg.Subject)
+			if !rule.Continue {
+				break
+			}
+			continue
+		}
+
+		// Письмо запоминается для подавления копий, только если все получатели правила его получили
+		failed := len(errs)
+		if rule.Mode == config.RuleModeDigest {
+			// Письмо откладывается в сводку правила; повторно при следующей попытке не добавляется
+			key := fmt.Sprintf("digest#%d", i+1)
+			if !seen[key] {
+				seen[key] = true
+				if err := digest.Add(rule, i+1, f.Name, msg); err != nil {
+					errs = append(errs, fmt.Errorf("digest: %w", err))
+				} else {
+					logger.Infow("message added to digest", "rule", ruleName(rule))
+					delivered = append(delivered, key)
+				}
+			}
+			if len(errs) == failed {
+				dedup.Record(dc, msg, ruleID, logger)
+			}
+			if !rule.Continue {
+				break
+			}
+			continue
+		}
 
-		if matched {
+		ac := cfg.Telegram.Attachments
+		if rule.Attachments != nil {
+			ac = *rule.Attachments
+		}
+		text, parseMode := renderMessage(resolveFormat(cfg, f, &rule), msg, f.Name, ruleName(rule), logger)
+		text += suppressedNote(suppressed)
+		longText, fullBody := longMessage(cfg, &rule, msg)
+		bc := cfg.Telegram.Buttons
+		if rule.Buttons != nil {
+			bc = *rule.Buttons
+		}
+		buttons := buttonsFor(bc)
+		dests, silent, notBefore := applySchedule(rule, time.Now(), logger)
+		for _, d := range dests {
 			logger.Debugw("message routed to channel",
-				"channel", rule.Channel,
+				"channel", d.String(),
 				"pattern", rule.Pattern,
 			)
-			telegram.SendToTelegram(fmt.Sprintf("%s\n%s", subject, body), rule.Channel, logger)
-			return
+			if rule.Reply {
+				if err := reply.Remember(msg, d.String()); err != nil {
+					logger.Warnw("cannot save email for reply", "channel", d.String(), "error", err)
+				}
+			}
+			send(d, telegram.Message{
+				Text:                text,
+				ParseMode:           parseMode,
+				DisableNotification: silent,
+				Protected:           rule.ProtectContent,
+				DisableLinkPreview:  rule.DisableLinkPreview,
+				Attachments:         selectAttachments(ac, msg.Attachments, logger),
+				Caption:             attachmentsCaption(msg.Subject),
+				LongText:            longText,
+				FullBody:            fullBody,
+				Buttons:             buttons,
+				NotBefore:           notBefore,
+			})
+		}
+		if len(errs) == failed {
+			dedup.Record(dc, msg, ruleID, logger)
+		}
+
+		if !rule.Continue {
+			break
 		}
 	}
 
 	// Если ни одно правило не сработало, отправляем в канал по умолчанию
-	logger.Infow("message routed to default channel",
-		"channel", cfg.Telegram.DefaultChannel,
-	)
-	telegram.SendToTelegram(fmt.Sprintf("subject: %s\n%s", subject, body), cfg.Telegram.DefaultChannel, logger)
+	if !matchedAny {
+		duplicate, suppressed := dedup.Check(cfg.Dedup, msg, "default", logger)
+		if duplicate {
+			logger.Infow("duplicate email suppressed", "rule", "default", "subject", msg.Subject)
+			return delivered, queued, errors.Join(errs...)
+		}
+
+		logger.Infow("message routed to default channel",
+			"channel", cfg.Telegram.DefaultChannel,
+		)
+		text, parseMode := renderMessage(resolveFormat(cfg, f, nil), msg, f.Name, "default", logger)
+		text += suppressedNote(suppressed)
+		longText, fullBody := longMessage(cfg, nil, msg)
+		failed := len(errs)
+		send(config.ParseDestination(cfg.Telegram.DefaultChannel), telegram.Message{
+			Text:        text,
+			ParseMode:   parseMode,
+			Attachments: selectAttachments(cfg.Telegram.Attachments, msg.Attachments, logger),
+			Caption:     attachmentsCaption(msg.Subject),
+			LongText:    longText,
+			FullBody:    fullBody,
+			Buttons:     buttonsFor(cfg.Telegram.Buttons),
+		})
+		if len(errs) == failed {
+			dedup.Record(cfg.Dedup, msg, "default", logger)
+		}
+	}
+
+	return delivered, queued, errors.Join(errs...)
+}
+
+// applySchedule возвращает получателей и параметры отправки правила в момент now.
+// Вне окна schedule письма перенаправляются, отправляются без звука или откладываются
+// до открытия окна.
+func applySchedule(rule config.Rule, now time.Time, logger *zap.SugaredLogger) (dests []config.Destination, silent bool, notBefore time.Time) {
+	dests, silent = rule.Destinations(), rule.DisableNotification
+	s := rule.Schedule
+	if s == nil || s.Contains(now) {
+		return dests, silent, notBefore
+	}
+
+	switch s.Outside {
+	case config.OutsideRedirect:
+		dests = []config.Destination{config.ParseDestination(s.Redirect)}
+	case config.OutsideSilent:
+		silent = true
+	case config.OutsideHold:
+		notBefore = s.NextOpen(now)
+	}
+	logger.Infow("rule is outside its schedule window", "rule", ruleName(rule), "action", s.Outside)
+	return dests, silent, notBefore
+}
+
+// renderMessage формирует текст сообщения по шаблону.
+// При ошибке шаблона письмо отправляется без разметки, чтобы не потерять его.
+func renderMessage(fm format, msg email.Decoded, folder, rule string, logger *zap.SugaredLogger) (string, tb.ParseMode) {
+	text, parseMode, err := fm.render(msg, folder, rule)
+	if err != nil {
+		logger.Warnw("failed to render message template, sending plain text", "rule", rule, "error", err)
+		return fmt.Sprintf("%s\n%s", msg.Subject, msg.Body), tb.ModeDefault
+	}
+	return text, parseMode
+}
+
+// longMessage выбирает обработку длинных сообщений (правило важнее глобальных настроек)
+// и готовит файл с полным текстом письма на случай обрезки
+func longMessage(cfg *config.Config, rule *config.Rule, msg email.Decoded) (string, *telegram.Attachment) {
+	mode, fullBody := cfg.Telegram.LongMessage, cfg.Telegram.FullBody
+	if rule != nil {
+		if rule.LongMessage != "" {
+			mode = rule.LongMessage
+		}
+		if rule.FullBody != "" {
+			fullBody = rule.FullBody
+		}
+	}
+	if mode != config.LongMessageTruncate {
+		return telegram.LongTextSplit, nil
+	}
+
+	switch fullBody {
+	case config.FullBodyNone:
+		return telegram.LongTextTruncate, nil
+	case config.FullBodyEML:
+		if len(msg.Raw) > 0 {
+			return telegram.LongTextTruncate, &telegram.Attachment{
+				Filename:    "message.eml",
+				ContentType: "message/rfc822",
+				Data:        msg.Raw,
+			}
+		}
+	}
+	return telegram.LongTextTruncate, &telegram.Attachment{
+		Filename:    "message.txt",
+		ContentType: "text/plain",
+		Data:        []byte(msg.Subject + "\n\n" + msg.Body),
+	}
+}
+
+// suppressedNote возвращает отметку о подавленных с прошлой доставки повторах письма
+func suppressedNote(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n\n🔁 Подавлено повторов: %d", n)
+}
+
+// ruleName возвращает имя правила для шаблонов и логов
+func ruleName(rule config.Rule) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return rule.Pattern
+}
+
+// attachmentsCaption формирует подпись, связывающую вложения с письмом
+func attachmentsCaption(subject string) string {
+	return fmt.Sprintf("📎 Вложения к письму: %s", subject)
 }
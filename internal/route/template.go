@@ -0,0 +1,126 @@
+package route
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+	"text/template"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	tb "gopkg.in/telebot.v3"
+)
+
+// defaultTemplate — оформление сообщения, если шаблон не задан
+const defaultTemplate = "{{.Subject}}\n{{.Body}}"
+
+// templateData — данные, доступные в шаблоне сообщения.
+// Все значения уже экранированы для выбранного режима разметки.
+type templateData struct {
+	Subject string
+	From    string
+	To      []string
+	Cc      []string
+	Date    string
+	Folder  string
+	Rule    string
+	Body    string
+}
+
+// format — выбранные шаблон и режим разметки
+type format struct {
+	template  string
+	parseMode string
+}
+
+// resolveFormat выбирает шаблон и режим разметки: правило, затем папка, затем глобальные настройки
+func resolveFormat(cfg *config.Config, f config.Folder, rule *config.Rule) format {
+	result := format{template: defaultTemplate, parseMode: cfg.Telegram.ParseMode}
+	if cfg.Telegram.Template != "" {
+		result.template = cfg.Telegram.Template
+	}
+	if f.Template != "" {
+		result.template = f.Template
+	}
+	if f.ParseMode != "" {
+		result.parseMode = f.ParseMode
+	}
+	if rule != nil {
+		if rule.Template != "" {
+			result.template = rule.Template
+		}
+		if rule.ParseMode != "" {
+			result.parseMode = rule.ParseMode
+		}
+	}
+	return result
+}
+
+// render формирует текст сообщения по шаблону с экранированием содержимого письма
+func (fm format) render(msg email.Decoded, folder, rule string) (string, tb.ParseMode, error) {
+	mode := strings.ToLower(fm.parseMode)
+	esc := escaper(mode)
+
+	data := templateData{
+		Subject: esc(msg.Subject),
+		From:    esc(msg.From),
+		Folder:  esc(folder),
+		Rule:    esc(rule),
+		Body:    esc(msg.Body),
+	}
+	for _, a := range msg.To {
+		data.To = append(data.To, esc(a))
+	}
+	for _, a := range msg.Cc {
+		data.Cc = append(data.Cc, esc(a))
+	}
+	if !msg.Date.IsZero() {
+		data.Date = esc(msg.Date.Format("2006-01-02 15:04:05"))
+	}
+
+	tmpl, err := template.New("message").Funcs(config.TemplateFuncs).Parse(fm.template)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", "", fmt.Errorf("template execution failed: %w", err)
+	}
+	return buf.String(), telegramParseMode(mode), nil
+}
+
+// telegramParseMode переводит режим разметки из конфигурации в значение Bot API
+func telegramParseMode(mode string) tb.ParseMode {
+	switch mode {
+	case config.ParseModeHTML:
+		return tb.ModeHTML
+	case config.ParseModeMarkdownV2:
+		return tb.ModeMarkdownV2
+	}
+	return tb.ModeDefault
+}
+
+// escaper возвращает функцию экранирования для режима разметки
+func escaper(mode string) func(string) string {
+	switch mode {
+	case config.ParseModeHTML:
+		return html.EscapeString
+	case config.ParseModeMarkdownV2:
+		return escapeMarkdownV2
+	}
+	return func(s string) string { return s }
+}
+
+// markdownV2Replacer экранирует все спецсимволы MarkdownV2
+var markdownV2Replacer = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`,
+	"|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// escapeMarkdownV2 экранирует текст для MarkdownV2
+func escapeMarkdownV2(s string) string {
+	return markdownV2Replacer.Replace(s)
+}
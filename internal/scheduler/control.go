@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+)
+
+// FolderStatus — время последней успешной проверки папки
+type FolderStatus struct {
+	Account   string
+	Folder    string
+	LastCheck time.Time
+}
+
+// paused — маршрутизация приостановлена: папки не проверяются, письма остаются в ящике
+var paused atomic.Bool
+
+// forced закрывается, чтобы разбудить наблюдатели idle для внеочередной проверки. Защищён mu.
+var forced = make(chan struct{})
+
+// folderCheck — последняя успешная проверка папки и шаблон, по которому папка найдена
+type folderCheck struct {
+	account, folder, pattern string
+	at                       time.Time
+}
+
+// lastChecks хранит последнюю успешную проверку по ключу "учётная запись/папка". Защищён mu.
+var lastChecks = make(map[string]folderCheck)
+
+// Pause приостанавливает получение и маршрутизацию писем
+func Pause() {
+	paused.Store(true)
+}
+
+// Resume возобновляет маршрутизацию и сразу проверяет все учётные записи,
+// чтобы доставить письма, пришедшие во время паузы
+func Resume() {
+	paused.Store(false)
+	CheckNow()
+}
+
+// Paused сообщает, приостановлена ли маршрутизация
+func Paused() bool {
+	return paused.Load()
+}
+
+// CheckNow запрашивает внеочередную проверку всех учётных записей
+func CheckNow() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, st := range accounts {
+		st.nextRun = time.Time{}
+	}
+	close(forced)
+	forced = make(chan struct{})
+}
+
+// forcedCheck возвращает канал, который закроется при запросе внеочередной проверки
+func forcedCheck() <-chan struct{} {
+	mu.Lock()
+	defer mu.Unlock()
+	return forced
+}
+
+// markChecked запоминает время успешной проверки папки
+func markChecked(account string, f config.Folder) {
+	mu.Lock()
+	lastChecks[account+"/"+f.Name] = folderCheck{account: account, folder: f.Name, pattern: f.Pattern, at: time.Now()}
+	mu.Unlock()
+}
+
+// Status возвращает время последней успешной проверки каждой папки из конфигурации.
+// Вместо папки, заданной шаблоном, перечисляются найденные по нему папки.
+// Для папок, которые ещё не проверялись, LastCheck равно нулю.
+func Status(cfg *config.Config) []FolderStatus {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var result []FolderStatus
+	for _, acc := range cfg.GetAccounts() {
+		for _, r := range acc.Route {
+			for _, f := range r.Folders {
+				if !f.IsPattern() {
+					result = append(result, FolderStatus{
+						Account:   acc.Name,
+						Folder:    f.Name,
+						LastCheck: lastChecks[acc.Name+"/"+f.Name].at,
+					})
+					continue
+				}
+
+				var found []FolderStatus
+				for _, c := range lastChecks {
+					if c.account == acc.Name && c.pattern == f.Name {
+						found = append(found, FolderStatus{Account: acc.Name, Folder: c.folder, LastCheck: c.at})
+					}
+				}
+				if len(found) == 0 {
+					found = append(found, FolderStatus{Account: acc.Name, Folder: f.Name})
+				}
+				sort.Slice(found, func(i, j int) bool { return found[i].Folder < found[j].Folder })
+				result = append(result, found...)
+			}
+		}
+	}
+	return result
+}
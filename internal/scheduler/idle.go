@@ -0,0 +1,235 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/emersion/go-imap/client"
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/alerts"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/state"
+	"github.com/st-kuptsov/mail2tg/pkg/metrics"
+	"go.uber.org/zap"
+	"time"
+)
+
+// idleFallbackPoll — интервал NOOP-опроса, если сервер не поддерживает IDLE
+const idleFallbackPoll = 5 * time.Second
+
+// watcher — запущенные наблюдатели папок одной учётной записи
+type watcher struct {
+	cancel context.CancelFunc
+	config string // снимок конфигурации учётной записи, с которой запущены наблюдатели
+}
+
+// watchers хранит наблюдатели по имени учётной записи. Используется только из Scheduler.
+var watchers = make(map[string]*watcher)
+
+// ensureWatchers запускает наблюдатели папок folders учётной записи в режиме idle.
+// При изменении конфигурации учётной записи или списка папок наблюдатели перезапускаются.
+func ensureWatchers(ctx context.Context, conf *config.CachedConfig, acc config.Account, folders []config.Folder, st *accountStatus, store *state.Store, logger *zap.SugaredLogger) {
+	w, running := watchers[acc.Name]
+	names := folderNames(folders)
+	snapshot := watcherSnapshot(acc, names)
+	if running {
+		if w.config == snapshot {
+			return
+		}
+		logger.Infow("account config or folders changed, restarting idle watchers")
+		w.cancel()
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	watchers[acc.Name] = &watcher{cancel: cancel, config: snapshot}
+
+	for _, f := range folders {
+		go watchFolder(wctx, conf, acc, f, st, store, logger.With("folder", f.Name))
+	}
+	logger.Infow("idle watchers started", "folders", names)
+}
+
+// watcherSnapshot возвращает снимок конфигурации учётной записи и списка наблюдаемых папок.
+// Указатели в конфигурации раскрываются, поэтому снимки одинаковых настроек, загруженных
+// заново при перезагрузке, совпадают.
+func watcherSnapshot(acc config.Account, names []string) string {
+	// Конфигурация состоит из строк, чисел, срезов и отображений, поэтому кодирование не завершается ошибкой
+	data, _ := json.Marshal(struct {
+		Account config.Account
+		Folders []string
+	}{acc, names})
+	return string(data)
+}
+
+// idleFolders делит папки учётной записи в режиме idle, чтобы не превысить ограничение сервера
+// на число соединений: через IDLE наблюдаются обычные папки, пока их не больше imap.max_connections.
+// Иначе, а также если есть папки по шаблонам, одно соединение отводится для опроса раз
+// в check_interval: наблюдаются первые max_connections-1 обычных папок, остальные опрашиваются.
+// Возвращает наблюдаемые папки и признак того, что есть опрашиваемые.
+func idleFolders(acc config.Account) (watched []config.Folder, polled bool) {
+	var literal []config.Folder
+	for _, r := range acc.Route {
+		for _, f := range r.Folders {
+			if f.IsPattern() {
+				polled = true
+			} else {
+				literal = append(literal, f)
+			}
+		}
+	}
+
+	limit := acc.IMAP.IdleConnections()
+	if !polled && len(literal) <= limit {
+		return literal, false
+	}
+	return literal[:min(len(literal), limit-1)], true
+}
+
+// folderNames возвращает имена папок
+func folderNames(folders []config.Folder) []string {
+	names := make([]string, 0, len(folders))
+	for _, f := range folders {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// stopStaleWatchers останавливает наблюдатели учётных записей, которые удалены
+// из конфигурации или переведены в режим poll.
+func stopStaleWatchers(cfg *config.Config, logger *zap.SugaredLogger) {
+	active := make(map[string]bool)
+	for _, acc := range cfg.GetAccounts() {
+		if acc.Mode == config.ModeIdle {
+			active[acc.Name] = true
+		}
+	}
+
+	for name, w := range watchers {
+		if !active[name] {
+			w.cancel()
+			delete(watchers, name)
+			logger.Infow("idle watchers stopped", "account", name)
+		}
+	}
+}
+
+// watchFolder держит постоянное соединение с папкой и доставляет новые письма
+// по уведомлениям IDLE. При обрыве соединения переподключается с экспоненциальной паузой
+// и случайным разбросом, не больше imap.max_backoff.
+func watchFolder(ctx context.Context, conf *config.CachedConfig, acc config.Account, f config.Folder, st *accountStatus, store *state.Store, logger *zap.SugaredLogger) {
+	failures := 0
+
+	for ctx.Err() == nil {
+		c, err := email.ConnectToIMAP(acc, logger)
+		mu.Lock()
+		alerts.ConnectToIMAPError(err, logger, conf, acc.Name, &st.connectionToIMAP)
+		mu.Unlock()
+
+		if err == nil {
+			failures = 0
+			err = idleLoop(ctx, conf, acc, f, c, st, store, logger)
+			if logoutErr := c.Logout(); logoutErr != nil {
+				logger.Debugw("IMAP logout failed", "error", logoutErr)
+			}
+			if err == nil {
+				return
+			}
+
+			// Обрыв постоянного соединения учитываем как ошибку подключения
+			mu.Lock()
+			alerts.ConnectToIMAPError(err, logger, conf, acc.Name, &st.connectionToIMAP)
+			mu.Unlock()
+		}
+
+		failures++
+		backoff := email.Backoff(failures, acc.IMAP.MaxBackoffDuration())
+		logger.Warnw("idle connection lost, reconnecting", "backoff", backoff.Round(time.Millisecond), "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// idleLoop проверяет папку, после чего ждёт уведомления о новых письмах в режиме IDLE.
+// Раз в check_interval и по запросу CheckNow папка проверяется принудительно.
+// Возвращает nil при отмене контекста и ошибку при обрыве соединения.
+func idleLoop(ctx context.Context, conf *config.CachedConfig, acc config.Account, f config.Folder, c *client.Client, st *accountStatus, store *state.Store, logger *zap.SugaredLogger) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(conf.Current(), acc, r, logger)
+			err = fmt.Errorf("panic recovered: %v", r)
+		}
+	}()
+
+	updates := make(chan client.Update, 16)
+	newMail := make(chan struct{}, 1)
+	c.Updates = updates
+
+	// Клиент блокируется, пока обновление не прочитано, поэтому разбираем их отдельно
+	go func() {
+		for {
+			select {
+			case u := <-updates:
+				if _, ok := u.(*client.MailboxUpdate); ok {
+					select {
+					case newMail <- struct{}{}:
+					default:
+					}
+				}
+			case <-c.LoggedOut():
+				return
+			}
+		}
+	}()
+
+	interval := time.Duration(acc.CheckInterval) * time.Second
+	// Таймаут ответа imap.read_timeout действует и на команду IDLE, которая длится
+	// до check_interval, поэтому на время ожидания он отключается
+	readTimeout := c.Timeout
+	for {
+		processingStart := time.Now()
+		checkFolder(conf.Current(), conf, acc, f, c, st, store, logger)
+		metrics.MailProcessingDuration.Observe(time.Since(processingStart).Seconds())
+		wake := forcedCheck()
+
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		c.Timeout = 0
+		go func() {
+			done <- c.Idle(stop, &client.IdleOptions{PollInterval: idleFallbackPoll})
+		}()
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			close(stop)
+			<-done
+			timer.Stop()
+			c.Timeout = readTimeout
+			return nil
+		case <-newMail:
+			logger.Debugw("new mail notification received")
+			close(stop)
+			err = <-done
+		case <-timer.C:
+			close(stop)
+			err = <-done
+		case <-wake:
+			logger.Debugw("forced check requested")
+			close(stop)
+			err = <-done
+		case err = <-done:
+			if err == nil {
+				err = fmt.Errorf("idle stopped unexpectedly")
+			}
+		}
+		timer.Stop()
+		c.Timeout = readTimeout
+
+		if err != nil {
+			return fmt.Errorf("idle failed: %w", err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/st-kuptsov/mail2tg/config"
+)
+
+// loadAccount собирает учётную запись заново, как при каждой перезагрузке конфигурации:
+// все вложенные указатели каждый раз новые
+func loadAccount(t *testing.T, edit func(*config.Account)) config.Account {
+	t.Helper()
+	attachments := true
+	acc := config.Account{
+		Name: "work",
+		Mode: config.ModeIdle,
+		IMAP: config.IMAPConfig{Host: "imap.example.com", Username: "user"},
+		Route: []config.RouteConfig{{
+			Folders: []config.Folder{{
+				Name: "INBOX",
+				Rules: []config.Rule{{
+					Name:     "alerts",
+					Match:    &config.Condition{Subject: "(?i)alert", HasAttachments: &attachments},
+					Channel:  "-100123",
+					Buttons:  &config.ButtonsConfig{Actions: []string{config.ActionRead}},
+					Schedule: &config.RuleSchedule{Days: []string{"mon-fri"}, Hours: "09:00-18:00"},
+				}},
+			}},
+		}},
+	}
+	if edit != nil {
+		edit(&acc)
+	}
+	return acc
+}
+
+func TestWatcherSnapshot(t *testing.T) {
+	base := watcherSnapshot(loadAccount(t, nil), []string{"INBOX"})
+	tests := []struct {
+		name  string
+		edit  func(*config.Account)
+		names []string
+		same  bool
+	}{
+		{name: "reloaded unchanged", names: []string{"INBOX"}, same: true},
+		{name: "folder list changed", names: []string{"INBOX", "Work"}},
+		{name: "imap setting changed", names: []string{"INBOX"}, edit: func(a *config.Account) { a.IMAP.Host = "imap.other.com" }},
+		{name: "nested condition changed", names: []string{"INBOX"}, edit: func(a *config.Account) {
+			*a.Route[0].Folders[0].Rules[0].Match.HasAttachments = false
+		}},
+		{name: "rule schedule changed", names: []string{"INBOX"}, edit: func(a *config.Account) {
+			a.Route[0].Folders[0].Rules[0].Schedule.Hours = "10:00-18:00"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := watcherSnapshot(loadAccount(t, tt.edit), tt.names)
+			if (got == base) != tt.same {
+				t.Errorf("snapshot equal = %v, want %v\nbase: %s\ngot:  %s", got == base, tt.same, base, got)
+			}
+		})
+	}
+}
+
+func TestIdleFolders(t *testing.T) {
+	folders := func(names ...string) []config.Folder {
+		var fs []config.Folder
+		for _, n := range names {
+			fs = append(fs, config.Folder{Name: n})
+		}
+		return fs
+	}
+	tests := []struct {
+		name           string
+		maxConnections int
+		routes         [][]config.Folder
+		wantWatched    []string
+		wantPolled     bool
+	}{
+		{name: "fits limit", maxConnections: 3, routes: [][]config.Folder{folders("INBOX", "Work", "Alerts")}, wantWatched: []string{"INBOX", "Work", "Alerts"}},
+		{name: "folders from several routes", maxConnections: 3, routes: [][]config.Folder{folders("INBOX"), folders("Work")}, wantWatched: []string{"INBOX", "Work"}},
+		// Одно соединение отводится для опроса, поэтому наблюдается на одну папку меньше лимита
+		{name: "over limit", maxConnections: 3, routes: [][]config.Folder{folders("INBOX", "Work", "Alerts", "Billing")}, wantWatched: []string{"INBOX", "Work"}, wantPolled: true},
+		{name: "pattern takes a connection", maxConnections: 3, routes: [][]config.Folder{folders("INBOX", "Work", "Alerts/*")}, wantWatched: []string{"INBOX", "Work"}, wantPolled: true},
+		{name: "pattern over limit", maxConnections: 2, routes: [][]config.Folder{folders("INBOX", "Work"), {{Name: "Archive", Include: []string{"Archive/**"}}}}, wantWatched: []string{"INBOX"}, wantPolled: true},
+		{name: "only patterns", maxConnections: 3, routes: [][]config.Folder{folders("Alerts/*")}, wantPolled: true},
+		{name: "single connection polls everything", maxConnections: 1, routes: [][]config.Folder{folders("INBOX", "Work")}, wantPolled: true},
+		{name: "single connection single folder", maxConnections: 1, routes: [][]config.Folder{folders("INBOX")}, wantWatched: []string{"INBOX"}},
+		{name: "default limit", routes: [][]config.Folder{folders("INBOX", "Work")}, wantWatched: []string{"INBOX", "Work"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc := loadAccount(t, func(a *config.Account) {
+				a.IMAP.MaxConnections = tt.maxConnections
+				a.Route = nil
+				for _, fs := range tt.routes {
+					a.Route = append(a.Route, config.RouteConfig{Folders: fs})
+				}
+			})
+			watched, polled := idleFolders(acc)
+			if got := folderNames(watched); !slices.Equal(got, tt.wantWatched) {
+				t.Errorf("watched = %v, want %v", got, tt.wantWatched)
+			}
+			if polled != tt.wantPolled {
+				t.Errorf("polled = %v, want %v", polled, tt.wantPolled)
+			}
+			// Наблюдаемые папки вместе с опросом не занимают больше max_connections соединений
+			conns := len(watched)
+			if polled {
+				conns++
+			}
+			if limit := acc.IMAP.IdleConnections(); conns > limit {
+				t.Errorf("%d connections used, limit %d", conns, limit)
+			}
+		})
+	}
+}
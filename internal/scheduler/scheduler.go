@@ -3,10 +3,13 @@ package scheduler
 import (
 	"context"
 	"fmt"
+	"github.com/emersion/go-imap/client"
 	"github.com/st-kuptsov/mail2tg/config"
 	"github.com/st-kuptsov/mail2tg/internal/alerts"
 	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/postprocess"
 	"github.com/st-kuptsov/mail2tg/internal/route"
+	"github.com/st-kuptsov/mail2tg/internal/state"
 	"github.com/st-kuptsov/mail2tg/internal/telegram"
 	"github.com/st-kuptsov/mail2tg/pkg/metrics"
 	"go.uber.org/zap"
@@ -14,83 +17,277 @@ import (
 	"time"
 )
 
+// accountStatus хранит расписание и состояние алертинга одной учётной записи
+type accountStatus struct {
+	nextRun           time.Time
+	running           bool
+	connectionToIMAP  alerts.Status
+	fetchUnreadEmails alerts.Status
+}
+
 var mu sync.Mutex
-var connectionToIMAP alerts.Status
-var fetchUnreadEmails alerts.Status
+var accounts = make(map[string]*accountStatus)
 
-// Scheduler запускает цикл опроса почты по заданному интервалу.
+// Scheduler запускает цикл опроса почты всех учётных записей.
+// Каждая учётная запись проверяется по своему интервалу, независимо от остальных.
 // Работает до отмены контекста.
-func Scheduler(ctx context.Context, conf *config.CachedConfig, logger *zap.SugaredLogger, start time.Time, configPath string) {
-	ticker := time.NewTicker(time.Duration(conf.Config.CheckInterval) * time.Second)
+func Scheduler(ctx context.Context, conf *config.CachedConfig, store *state.Store, logger *zap.SugaredLogger, start time.Time, configPath string) {
+	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
+			email.ClosePools(nil)
 			logger.Infow("scheduler stopped")
 			return
-		case <-ticker.C:
+		case now := <-ticker.C:
 			metrics.UptimeGauge.Set(time.Since(start).Seconds())
 
-			func() {
-				// Отслеживание времени обработки всех писем
-				processingStart := time.Now()
-				defer func() {
-					metrics.MailProcessingDuration.Observe(time.Since(processingStart).Seconds())
-				}()
+			if !hasDueAccounts(conf.Current(), now) {
+				continue
+			}
 
-				changed, err := conf.ReloadIfChanged(configPath)
-				if err != nil {
-					logger.Errorw("reload config error", "error", err)
+			changed, err := conf.ReloadIfChanged(configPath)
+			if err != nil {
+				logger.Errorw("reload config error", "error", err)
+			}
+			if changed {
+				logger.Infow("config reloaded due to changes")
+			}
+
+			cfg := conf.Current()
+			stopStaleWatchers(cfg, logger)
+			closeStalePools(cfg)
+			for _, acc := range cfg.GetAccounts() {
+				st, due := takeDue(acc, now)
+				if !due {
+					continue
 				}
-				if changed {
-					logger.Infow("config reloaded due to changes")
+				var skip map[string]bool
+				if acc.Mode == config.ModeIdle {
+					// В режиме idle письма получают наблюдатели папок, здесь лишь сверяем их с конфигурацией
+					watched, polled := idleFolders(acc)
+					ensureWatchers(ctx, conf, acc, watched, st, store, logger.With("account", acc.Name))
+					if !polled {
+						finish(st)
+						continue
+					}
+					// Остальные папки опрашиваются через одно соединение сверх соединений наблюдателей
+					skip = make(map[string]bool)
+					for _, name := range folderNames(watched) {
+						skip[name] = true
+					}
+					acc.IMAP.MaxConnections = 1
 				}
+				go func(acc config.Account, st *accountStatus) {
+					defer finish(st)
+					checkAccount(cfg, conf, acc, skip, st, store, logger.With("account", acc.Name))
+				}(acc, st)
+			}
+		}
+	}
+}
 
-				defer func() {
-					if r := recover(); r != nil {
-						logger.Errorf("panic recovered: %v", r)
-						metrics.MailErrors.Inc()
-						telegram.SendToTelegram(
-							fmt.Sprintf("Паника в обработчике почты: %v", r),
-							conf.Config.Telegram.ErrorsChannel,
-							logger,
-						)
-					}
-				}()
+// closeStalePools закрывает постоянные соединения учётных записей, которые удалены
+// из конфигурации или переведены в режим idle без опрашиваемых папок
+func closeStalePools(cfg *config.Config) {
+	keep := make(map[string]bool)
+	for _, acc := range cfg.GetAccounts() {
+		if _, polled := idleFolders(acc); acc.Mode != config.ModeIdle || polled {
+			keep[acc.Name] = true
+		}
+	}
+	email.ClosePools(keep)
+}
 
-				// Подключение к IMAP
-				c, err := email.ConnectToIMAP(conf.Config, logger)
-				mu.Lock()
-				alerts.ConnectToIMAPError(err, logger, conf, &connectionToIMAP)
-				mu.Unlock()
+// hasDueAccounts проверяет, есть ли учётные записи, которые пора проверить
+func hasDueAccounts(cfg *config.Config, now time.Time) bool {
+	mu.Lock()
+	defer mu.Unlock()
 
-				if err != nil {
-					// не получилось подключиться — дальше смысла идти нет
-					return
-				}
+	for _, acc := range cfg.GetAccounts() {
+		st, ok := accounts[acc.Name]
+		if !ok || (!st.running && !now.Before(st.nextRun)) {
+			return true
+		}
+	}
+	return false
+}
 
-				defer func() {
-					if err := c.Logout(); err != nil {
-						logger.Warnf("Ошибка выхода из IMAP: %v", err)
-					}
-				}()
-
-				// Обходим все папки и правила
-				for _, r := range conf.Config.Route {
-					for _, f := range r.Folders {
-						messages, err := email.FetchUnreadEmails(conf.Config, f, c, logger)
-						mu.Lock()
-						alerts.FetchUnreadEmailsError(err, logger, conf, &fetchUnreadEmails)
-						mu.Unlock()
-
-						for _, m := range messages {
-							subject, body := email.DecodeMessage(m, logger)
-							route.RouteMessage(conf.Config, f, subject, body, logger)
-						}
-					}
+// takeDue помечает учётную запись как выполняющуюся, если подошло время её проверки.
+// Первая проверка в режиме poll выполняется через check_interval после запуска,
+// наблюдатели режима idle запускаются сразу.
+func takeDue(acc config.Account, now time.Time) (*accountStatus, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	interval := time.Duration(acc.CheckInterval) * time.Second
+	st, ok := accounts[acc.Name]
+	if !ok {
+		st = &accountStatus{nextRun: now.Add(interval)}
+		if acc.Mode == config.ModeIdle {
+			st.nextRun = now
+		}
+		accounts[acc.Name] = st
+	}
+	if st.running || now.Before(st.nextRun) {
+		return st, false
+	}
+
+	st.running = true
+	st.nextRun = now.Add(interval)
+	return st, true
+}
+
+// finish снимает отметку о выполнении проверки учётной записи
+func finish(st *accountStatus) {
+	mu.Lock()
+	st.running = false
+	mu.Unlock()
+}
+
+// checkAccount выполняет одну проверку всех папок учётной записи, кроме папок из skip,
+// которые получают наблюдатели режима idle
+func checkAccount(cfg *config.Config, conf *config.CachedConfig, acc config.Account, skip map[string]bool, st *accountStatus, store *state.Store, logger *zap.SugaredLogger) {
+	if Paused() {
+		logger.Debugw("routing is paused, skipping check")
+		return
+	}
+
+	// Отслеживание времени обработки всех писем
+	processingStart := time.Now()
+	defer func() {
+		metrics.MailProcessingDuration.Observe(time.Since(processingStart).Seconds())
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(cfg, acc, r, logger)
+		}
+	}()
+
+	// Соединение из пула постоянных соединений учётной записи
+	pool := email.AccountPool(acc, logger)
+	c, ok := getConnection(conf, acc, pool, st, logger)
+	if !ok {
+		// не получилось подключиться — дальше смысла идти нет
+		return
+	}
+
+	// Раскрываем шаблоны папок
+	folders, err := email.ResolveFolders(c, acc, logger)
+	if err != nil {
+		logger.Errorw("failed to resolve folder patterns", "error", err)
+		metrics.MailErrors.Inc()
+	}
+	pool.Put(c, err)
+
+	// Папки проверяются параллельно, каждая через своё соединение из пула,
+	// поэтому одновременно проверяется не больше imap.max_connections папок
+	var wg sync.WaitGroup
+	for _, f := range folders {
+		if skip[f.Name] {
+			continue
+		}
+		wg.Add(1)
+		go func(f config.Folder) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					reportPanic(cfg, acc, r, logger)
 				}
 			}()
+
+			c, ok := getConnection(conf, acc, pool, st, logger)
+			if !ok {
+				return
+			}
+			pool.Put(c, checkFolder(cfg, conf, acc, f, c, st, store, logger))
+		}(f)
+	}
+	wg.Wait()
+}
+
+// getConnection берёт соединение из пула и отслеживает ошибки подключения для алертинга
+func getConnection(conf *config.CachedConfig, acc config.Account, pool *email.Pool, st *accountStatus, logger *zap.SugaredLogger) (*client.Client, bool) {
+	c, err := pool.Get(context.Background())
+	mu.Lock()
+	alerts.ConnectToIMAPError(err, logger, conf, acc.Name, &st.connectionToIMAP)
+	mu.Unlock()
+	return c, err == nil
+}
+
+// reportPanic логирует панику обработчика почты и уведомляет errors_channel
+func reportPanic(cfg *config.Config, acc config.Account, r interface{}, logger *zap.SugaredLogger) {
+	logger.Errorf("panic recovered: %v", r)
+	metrics.MailErrors.Inc()
+	telegram.SendToTelegram(
+		fmt.Sprintf("Паника в обработчике почты [%s]: %v", acc.Name, r),
+		cfg.Telegram.ErrorsChannel,
+		logger,
+	)
+}
+
+// checkFolder получает новые письма из папки и доставляет их.
+// Пока маршрутизация приостановлена, письма не забираются и будут доставлены после возобновления.
+// Возвращает ошибку получения писем.
+func checkFolder(cfg *config.Config, conf *config.CachedConfig, acc config.Account, f config.Folder, c *client.Client, st *accountStatus, store *state.Store, logger *zap.SugaredLogger) error {
+	if Paused() {
+		return nil
+	}
+
+	key, messages, err := email.FetchNewEmails(cfg, acc, f, c, store, logger)
+	mu.Lock()
+	alerts.FetchUnreadEmailsError(err, logger, conf, acc.Name, &st.fetchUnreadEmails)
+	mu.Unlock()
+	if err == nil {
+		markChecked(acc.Name, f)
+	}
+
+	for _, m := range messages {
+		deliver(cfg, f, c, store, key, m.UID, email.Decode(m, logger), logger)
+	}
+	return err
+}
+
+// deliver маршрутизирует письмо и фиксирует результат доставки в хранилище состояния.
+// После подтверждённой отправки всех сообщений о письме выполняет post_actions совпавших
+// правил через соединение c. Если часть сообщений ещё в очереди Telegram, действия
+// выполняются после их отправки.
+func deliver(cfg *config.Config, f config.Folder, c *client.Client, store *state.Store, key state.Key, uid uint32, msg email.Decoded, logger *zap.SugaredLogger) {
+	msg.Ref, msg.Folder = key.Ref(uid), f.Name
+	delivered, queued, err := route.RouteMessage(cfg, f, msg, store.Delivered(key, uid), logger)
+	if err != nil {
+		logger.Errorw("email delivery failed", "folder", f.Name, "uid", uid, "delivered", delivered, "error", err)
+		if err := store.MarkFailed(key, uid, delivered, err, cfg.MaxDeliveryAttempts); err != nil {
+			logger.Errorw("failed to save message state", "folder", f.Name, "uid", uid, "error", err)
 		}
+		return
+	}
+
+	if err := store.MarkDelivered(key, uid); err != nil {
+		logger.Errorw("failed to save message state", "folder", f.Name, "uid", uid, "error", err)
+	}
+
+	actions := route.PostActions(f, msg)
+	if len(actions) == 0 {
+		return
+	}
+	deferred, err := postprocess.Defer(msg.Ref, actions)
+	if err != nil {
+		logger.Errorw("post-delivery actions failed", "folder", f.Name, "uid", uid, "error", err)
+		metrics.MailErrors.Inc()
+		return
+	}
+	if deferred {
+		logger.Infow("post-delivery actions deferred until messages are sent", "folder", f.Name, "uid", uid, "queued", queued)
+		return
+	}
+	if err := email.PostProcess(c, uid, actions); err != nil {
+		logger.Errorw("post-delivery actions failed", "folder", f.Name, "uid", uid, "error", err)
+		metrics.MailErrors.Inc()
+		return
 	}
+	logger.Infow("post-delivery actions applied", "folder", f.Name, "uid", uid, "actions", len(actions))
 }
@@ -0,0 +1,114 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// indexEntry — значение индекса и время его последнего обновления
+type indexEntry struct {
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Index — файловый словарь строковых значений со сроком хранения записей.
+// Записи старше ttl удаляются при очередном изменении.
+type Index struct {
+	mu      sync.Mutex
+	path    string
+	ttl     time.Duration
+	entries map[string]indexEntry
+}
+
+// OpenIndex открывает индекс по указанному пути, создавая его при отсутствии
+func OpenIndex(path string, ttl time.Duration) (*Index, error) {
+	idx := &Index{path: path, ttl: ttl, entries: make(map[string]indexEntry)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, fmt.Errorf("cannot read index file: %w", err)
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &idx.entries); err != nil {
+			return nil, fmt.Errorf("cannot parse index file: %w", err)
+		}
+	}
+	return idx, nil
+}
+
+// Put сохраняет значение по ключу и удаляет устаревшие записи
+func (i *Index) Put(key, value string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	for k, e := range i.entries {
+		if now.Sub(e.UpdatedAt) > i.ttl {
+			delete(i.entries, k)
+		}
+	}
+	i.entries[key] = indexEntry{Value: value, UpdatedAt: now}
+	return i.save()
+}
+
+// Get возвращает значение по ключу, если оно есть и не устарело
+func (i *Index) Get(key string) (string, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	e, ok := i.entries[key]
+	if !ok || time.Since(e.UpdatedAt) > i.ttl {
+		return "", false
+	}
+	return e.Value, true
+}
+
+// Delete удаляет запись по ключу
+func (i *Index) Delete(key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.entries[key]; !ok {
+		return nil
+	}
+	delete(i.entries, key)
+	return i.save()
+}
+
+// SetTTL изменяет срок хранения записей
+func (i *Index) SetTTL(ttl time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.ttl = ttl
+}
+
+// save атомарно записывает индекс на диск. Вызывается под блокировкой.
+func (i *Index) save() error {
+	data, err := json.Marshal(i.entries)
+	if err != nil {
+		return fmt.Errorf("cannot encode index: %w", err)
+	}
+
+	if dir := filepath.Dir(i.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("cannot create index directory: %w", err)
+		}
+	}
+
+	tmp := i.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("cannot write index file: %w", err)
+	}
+	if err := os.Rename(tmp, i.path); err != nil {
+		return fmt.Errorf("cannot replace index file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Статусы доставки отдельного письма
+const (
+	StatusPending = "pending" // письмо получено, доставка ещё не подтверждена
+	StatusFailed  = "failed"  // попытки доставки исчерпаны
+)
+
+// FailedRetention — срок хранения писем со статусом failed; после него запись удаляется из состояния
+const FailedRetention = 30 * 24 * time.Hour
+
+// Key идентифицирует папку почтового ящика. При смене UIDVALIDITY
+// все ранее сохранённые UID становятся недействительными.
+type Key struct {
+	Account     string
+	Folder      string
+	UIDValidity uint32
+}
+
+func (k Key) id() string {
+	return k.Account + "|" + k.Folder
+}
+
+// Ref возвращает ссылку на письмо с указанным UID
+func (k Key) Ref(uid uint32) string {
+	return fmt.Sprintf("%s|%s|%d|%d", k.Account, k.Folder, k.UIDValidity, uid)
+}
+
+// ParseRef разбирает ссылку на письмо, полученную из Key.Ref
+func ParseRef(ref string) (Key, uint32, error) {
+	parts := strings.Split(ref, "|")
+	if len(parts) < 4 {
+		return Key{}, 0, fmt.Errorf("invalid message reference %q", ref)
+	}
+	n := len(parts)
+	validity, err := strconv.ParseUint(parts[n-2], 10, 32)
+	if err != nil {
+		return Key{}, 0, fmt.Errorf("invalid message reference %q", ref)
+	}
+	uid, err := strconv.ParseUint(parts[n-1], 10, 32)
+	if err != nil {
+		return Key{}, 0, fmt.Errorf("invalid message reference %q", ref)
+	}
+	key := Key{
+		Account:     parts[0],
+		Folder:      strings.Join(parts[1:n-2], "|"),
+		UIDValidity: uint32(validity),
+	}
+	return key, uint32(uid), nil
+}
+
+// MessageState хранит статус доставки письма, которое ещё не доставлено
+type MessageState struct {
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// Delivered — каналы, в которые письмо уже доставлено; при повторе они пропускаются
+	Delivered []string  `json:"delivered,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FolderState хранит состояние обработки одной папки
+type FolderState struct {
+	UIDValidity uint32                   `json:"uid_validity"`
+	LastUID     uint32                   `json:"last_uid"`
+	Messages    map[uint32]*MessageState `json:"messages,omitempty"`
+}
+
+// Store — файловое хранилище состояния доставки.
+// Каждое изменение атомарно сохраняется на диск.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	folders map[string]*FolderState
+}
+
+// Open открывает хранилище по указанному пути, создавая его при отсутствии
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, folders: make(map[string]*FolderState)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("cannot read state file: %w", err)
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.folders); err != nil {
+			return nil, fmt.Errorf("cannot parse state file: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Sync возвращает последний обработанный UID и список UID, доставку которых
+// нужно повторить. fresh=true означает, что состояние папки создано заново
+// (первый запуск или смена UIDVALIDITY).
+func (s *Store) Sync(key Key, maxAttempts int) (lastUID uint32, retry []uint32, fresh bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fs, ok := s.folders[key.id()]
+	if !ok || fs.UIDValidity != key.UIDValidity {
+		s.folders[key.id()] = &FolderState{
+			UIDValidity: key.UIDValidity,
+			Messages:    make(map[uint32]*MessageState),
+		}
+		return 0, nil, true, s.save()
+	}
+
+	if prune(fs, time.Now()) {
+		if err := s.save(); err != nil {
+			return 0, nil, false, err
+		}
+	}
+	for uid, m := range fs.Messages {
+		if m.Status == StatusPending && m.Attempts < maxAttempts {
+			retry = append(retry, uid)
+		}
+	}
+	sort.Slice(retry, func(i, j int) bool { return retry[i] < retry[j] })
+
+	return fs.LastUID, retry, false, nil
+}
+
+// Advance сдвигает последний обработанный UID и помечает новые письма как ожидающие доставки.
+// Письма регистрируются до отправки, чтобы после сбоя их доставка была повторена.
+func (s *Store) Advance(key Key, lastUID uint32, pending []uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fs, err := s.folder(key)
+	if err != nil {
+		return err
+	}
+
+	for _, uid := range pending {
+		if _, ok := fs.Messages[uid]; !ok {
+			fs.Messages[uid] = &MessageState{Status: StatusPending, UpdatedAt: time.Now()}
+		}
+	}
+	if lastUID > fs.LastUID {
+		fs.LastUID = lastUID
+	}
+	return s.save()
+}
+
+// MarkDelivered фиксирует подтверждённую доставку письма
+func (s *Store) MarkDelivered(key Key, uid uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fs, err := s.folder(key)
+	if err != nil {
+		return err
+	}
+
+	delete(fs.Messages, uid)
+	return s.save()
+}
+
+// Remove забывает письмо, которого больше нет в папке на сервере
+func (s *Store) Remove(key Key, uid uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fs, err := s.folder(key)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := fs.Messages[uid]; !ok {
+		return nil
+	}
+	delete(fs.Messages, uid)
+	return s.save()
+}
+
+// Delivered возвращает каналы, в которые письмо уже доставлено
+func (s *Store) Delivered(key Key, uid uint32) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fs, err := s.folder(key)
+	if err != nil {
+		return nil
+	}
+	if m, ok := fs.Messages[uid]; ok {
+		return append([]string(nil), m.Delivered...)
+	}
+	return nil
+}
+
+// MarkFailed увеличивает счётчик неудачных попыток доставки письма и запоминает
+// каналы, в которые оно уже доставлено.
+// После maxAttempts попыток письмо получает статус failed и больше не повторяется.
+func (s *Store) MarkFailed(key Key, uid uint32, delivered []string, cause error, maxAttempts int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fs, err := s.folder(key)
+	if err != nil {
+		return err
+	}
+
+	m, ok := fs.Messages[uid]
+	if !ok {
+		m = &MessageState{Status: StatusPending}
+		fs.Messages[uid] = m
+	}
+	m.Attempts++
+	m.UpdatedAt = time.Now()
+	m.Delivered = delivered
+	if cause != nil {
+		m.LastError = cause.Error()
+	}
+	if m.Attempts >= maxAttempts {
+		m.Status = StatusFailed
+	}
+	return s.save()
+}
+
+// Rename переносит состояние папок учётной записи from на идентификатор to,
+// если для to состояния ещё нет. Используется при смене идентификатора учётной записи.
+func (s *Store) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if from == to {
+		return nil
+	}
+	renamed := false
+	for id, fs := range s.folders {
+		folder, ok := strings.CutPrefix(id, from+"|")
+		if !ok {
+			continue
+		}
+		target := Key{Account: to, Folder: folder}.id()
+		if _, exists := s.folders[target]; !exists {
+			s.folders[target] = fs
+		}
+		delete(s.folders, id)
+		renamed = true
+	}
+	if !renamed {
+		return nil
+	}
+	return s.save()
+}
+
+// prune удаляет письма со статусом failed старше FailedRetention. Вызывается под блокировкой.
+func prune(fs *FolderState, now time.Time) bool {
+	pruned := false
+	for uid, m := range fs.Messages {
+		if m.Status == StatusFailed && now.Sub(m.UpdatedAt) > FailedRetention {
+			delete(fs.Messages, uid)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// folder возвращает состояние папки с проверкой UIDVALIDITY. Вызывается под блокировкой.
+func (s *Store) folder(key Key) (*FolderState, error) {
+	fs, ok := s.folders[key.id()]
+	if !ok || fs.UIDValidity != key.UIDValidity {
+		return nil, fmt.Errorf("state for %s/%s is outdated", key.Account, key.Folder)
+	}
+	if fs.Messages == nil {
+		fs.Messages = make(map[uint32]*MessageState)
+	}
+	return fs, nil
+}
+
+// save атомарно записывает состояние на диск. Вызывается под блокировкой.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.folders, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode state: %w", err)
+	}
+
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("cannot create state directory: %w", err)
+		}
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("cannot write state file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("cannot replace state file: %w", err)
+	}
+	return nil
+}
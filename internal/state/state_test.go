@@ -0,0 +1,214 @@
+package state
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return s
+}
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		name    string
+		ref     string
+		key     Key
+		uid     uint32
+		wantErr bool
+	}{
+		{name: "plain", ref: "work|INBOX|7|42", key: Key{Account: "work", Folder: "INBOX", UIDValidity: 7}, uid: 42},
+		{name: "folder with separator", ref: "work|a|b|7|42", key: Key{Account: "work", Folder: "a|b", UIDValidity: 7}, uid: 42},
+		{name: "too short", ref: "work|INBOX|42", wantErr: true},
+		{name: "bad uid", ref: "work|INBOX|7|x", wantErr: true},
+		{name: "bad validity", ref: "work|INBOX|x|1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, uid, err := ParseRef(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRef(%q) error = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if key != tt.key || uid != tt.uid {
+				t.Errorf("ParseRef(%q) = %+v, %d; want %+v, %d", tt.ref, key, uid, tt.key, tt.uid)
+			}
+			if got := key.Ref(uid); got != tt.ref {
+				t.Errorf("Ref round trip = %q, want %q", got, tt.ref)
+			}
+		})
+	}
+}
+
+func TestSyncRetriesPendingOnly(t *testing.T) {
+	s := openStore(t)
+	key := Key{Account: "work", Folder: "INBOX", UIDValidity: 1}
+	if _, _, fresh, err := s.Sync(key, 3); err != nil || !fresh {
+		t.Fatalf("first Sync: fresh = %v, err = %v", fresh, err)
+	}
+	if err := s.Advance(key, 12, []uint32{10, 11, 12}); err != nil {
+		t.Fatalf("Advance: %v", err)
+	}
+	cause := errors.New("boom")
+	for i := 0; i < 3; i++ {
+		if err := s.MarkFailed(key, 11, nil, cause, 3); err != nil {
+			t.Fatalf("MarkFailed: %v", err)
+		}
+	}
+	if err := s.MarkDelivered(key, 12); err != nil {
+		t.Fatalf("MarkDelivered: %v", err)
+	}
+
+	last, retry, fresh, err := s.Sync(key, 3)
+	if err != nil || fresh {
+		t.Fatalf("Sync: fresh = %v, err = %v", fresh, err)
+	}
+	if last != 12 || !reflect.DeepEqual(retry, []uint32{10}) {
+		t.Errorf("Sync = %d, %v; want 12, [10]", last, retry)
+	}
+
+	// Смена UIDVALIDITY сбрасывает состояние папки
+	key.UIDValidity = 2
+	if last, retry, fresh, _ := s.Sync(key, 3); !fresh || last != 0 || retry != nil {
+		t.Errorf("Sync after UIDVALIDITY change = %d, %v, %v; want 0, nil, true", last, retry, fresh)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	s := openStore(t)
+	key := Key{Account: "work", Folder: "INBOX", UIDValidity: 1}
+	s.Sync(key, 5)
+	if err := s.Advance(key, 3, []uint32{1, 2, 3}); err != nil {
+		t.Fatalf("Advance: %v", err)
+	}
+	for _, uid := range []uint32{2, 2, 99} {
+		if err := s.Remove(key, uid); err != nil {
+			t.Fatalf("Remove(%d): %v", uid, err)
+		}
+	}
+	if _, retry, _, _ := s.Sync(key, 5); !reflect.DeepEqual(retry, []uint32{1, 3}) {
+		t.Errorf("retry after Remove = %v, want [1 3]", retry)
+	}
+}
+
+func TestPruneFailed(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		status string
+		age    time.Duration
+		kept   bool
+	}{
+		{name: "recent failed", status: StatusFailed, age: time.Hour, kept: true},
+		{name: "failed at retention", status: StatusFailed, age: FailedRetention, kept: true},
+		{name: "expired failed", status: StatusFailed, age: FailedRetention + time.Minute, kept: false},
+		{name: "old pending", status: StatusPending, age: 2 * FailedRetention, kept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &FolderState{Messages: map[uint32]*MessageState{
+				1: {Status: tt.status, UpdatedAt: now.Add(-tt.age)},
+			}}
+			pruned := prune(fs, now)
+			_, kept := fs.Messages[1]
+			if kept != tt.kept || pruned == tt.kept {
+				t.Errorf("prune: kept = %v, pruned = %v; want kept = %v", kept, pruned, tt.kept)
+			}
+		})
+	}
+}
+
+func TestSyncPrunesExpiredFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, _ := Open(path)
+	key := Key{Account: "work", Folder: "INBOX", UIDValidity: 1}
+	s.Sync(key, 1)
+	if err := s.MarkFailed(key, 5, nil, errors.New("boom"), 1); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	s.folders[key.id()].Messages[5].UpdatedAt = time.Now().Add(-FailedRetention - time.Hour)
+	if _, _, _, err := s.Sync(key, 1); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if n := len(reopened.folders[key.id()].Messages); n != 0 {
+		t.Errorf("messages after prune = %d, want 0", n)
+	}
+}
+
+func TestRename(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		from, to string
+		want     []string
+	}{
+		{
+			name:     "legacy key",
+			existing: []string{"user@imap.example.com|INBOX", "user@imap.example.com|Work|Tasks", "other@host|INBOX"},
+			from:     "user@imap.example.com",
+			to:       "work",
+			want:     []string{"other@host|INBOX", "work|INBOX", "work|Work|Tasks"},
+		},
+		{
+			name:     "prefix of another account",
+			existing: []string{"user@host.org|INBOX"},
+			from:     "user@host",
+			to:       "work",
+			want:     []string{"user@host.org|INBOX"},
+		},
+		{
+			name:     "same key",
+			existing: []string{"work|INBOX"},
+			from:     "work",
+			to:       "work",
+			want:     []string{"work|INBOX"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openStore(t)
+			for _, id := range tt.existing {
+				s.folders[id] = &FolderState{UIDValidity: 1, LastUID: 10}
+			}
+			if err := s.Rename(tt.from, tt.to); err != nil {
+				t.Fatalf("Rename: %v", err)
+			}
+			var got []string
+			for id := range s.folders {
+				got = append(got, id)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("folders = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameKeepsExistingTarget(t *testing.T) {
+	s := openStore(t)
+	s.folders["user@host|INBOX"] = &FolderState{UIDValidity: 1, LastUID: 5}
+	s.folders["work|INBOX"] = &FolderState{UIDValidity: 1, LastUID: 9}
+	if err := s.Rename("user@host", "work"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if len(s.folders) != 1 || s.folders["work|INBOX"].LastUID != 9 {
+		t.Errorf("folders = %+v, want only work|INBOX with last uid 9", s.folders)
+	}
+}
@@ -0,0 +1,102 @@
+package telegram
+
+import (
+	"bytes"
+	tb "gopkg.in/telebot.v3"
+	"strings"
+)
+
+const (
+	// maxPhotoSize — максимальный размер изображения, которое Telegram принимает как фото
+	maxPhotoSize = 10 << 20
+	// maxAlbumSize — максимальное количество элементов в альбоме
+	maxAlbumSize = 10
+)
+
+// Attachment — файл, отправляемый вместе с сообщением
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// isPhoto проверяет, можно ли отправить вложение как фото
+func (a Attachment) isPhoto() bool {
+	switch strings.ToLower(a.ContentType) {
+	case "image/jpeg", "image/png", "image/webp":
+		return len(a.Data) <= maxPhotoSize
+	}
+	return false
+}
+
+// media создаёт объект для отправки. Вызывается на каждую попытку,
+// так как содержимое читается из одноразового reader.
+func (a Attachment) media(caption string) tb.Inputtable {
+	file := tb.FromReader(bytes.NewReader(a.Data))
+	if a.isPhoto() {
+		return &tb.Photo{File: file, Caption: caption}
+	}
+	return &tb.Document{File: file, FileName: a.Filename, MIME: a.ContentType, Caption: caption}
+}
+
+// sendAttachments отправляет вложения ответом на текстовое сообщение.
+// Фото и документы группируются в отдельные альбомы, так как Telegram не смешивает их.
+func sendAttachments(m tgMessage, chat *tb.Chat, replyTo *tb.Message) error {
+	var photos, documents []Attachment
+	for _, a := range m.attachments {
+		if a.isPhoto() {
+			photos = append(photos, a)
+		} else {
+			documents = append(documents, a)
+		}
+	}
+
+	caption := m.caption
+	if textLength(caption) > MaxCaptionLength {
+		caption = truncateText(caption, tb.ModeDefault, MaxCaptionLength)
+	}
+	for _, group := range [][]Attachment{photos, documents} {
+		for len(group) > 0 {
+			n := len(group)
+			if n > maxAlbumSize {
+				n = maxAlbumSize
+			}
+			if err := sendGroup(m, chat, replyTo, group[:n], caption); err != nil {
+				return err
+			}
+			// Подпись ставится только на первую группу вложений
+			caption = ""
+			group = group[n:]
+		}
+	}
+	return nil
+}
+
+// sendGroup отправляет одно вложение или альбом из нескольких
+func sendGroup(m tgMessage, chat *tb.Chat, replyTo *tb.Message, group []Attachment, caption string) error {
+	opts := &tb.SendOptions{
+		ReplyTo:             replyTo,
+		AllowWithoutReply:   true,
+		ThreadID:            m.threadID,
+		DisableNotification: m.silent,
+		Protected:           m.protected,
+	}
+
+	return withRetry(m, len(group), func() error {
+		if len(group) == 1 {
+			_, err := Bot.Send(chat, group[0].media(caption), opts)
+			return err
+		}
+
+		album := make(tb.Album, 0, len(group))
+		for i, a := range group {
+			c := ""
+			if i == 0 {
+				c = caption
+			}
+			album = append(album, a.media(c))
+		}
+		_, err := Bot.SendAlbum(chat, album, opts)
+		return err
+	})
+}
@@ -0,0 +1,98 @@
+package telegram
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+	tb "gopkg.in/telebot.v3"
+)
+
+// ButtonUnique — идентификатор обработчика кнопок действий с письмом
+const ButtonUnique = "mail"
+
+// buttonsPerRow — количество кнопок в одном ряду клавиатуры
+const buttonsPerRow = 3
+
+// Button — кнопка действия под сообщением. Data передаётся обработчику ButtonUnique.
+type Button struct {
+	Text string `json:"text"`
+	Data string `json:"data"`
+}
+
+// keyboard строит встроенную клавиатуру из кнопок
+func keyboard(buttons []Button) *tb.ReplyMarkup {
+	if len(buttons) == 0 {
+		return nil
+	}
+
+	markup := &tb.ReplyMarkup{}
+	var row []tb.InlineButton
+	for _, b := range buttons {
+		row = append(row, tb.InlineButton{Unique: ButtonUnique, Text: b.Text, Data: b.Data})
+		if len(row) == buttonsPerRow {
+			markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+	}
+	return markup
+}
+
+// ActionDone — данные кнопки-отметки о выполненном действии
+const ActionDone = "done"
+
+// ActedKeyboard возвращает клавиатуру сообщения после выполнения действия:
+// кнопка выполненного действия убирается, внизу добавляется отметка status.
+// Если keep=false, убираются все кнопки действий (письмо больше недоступно в папке).
+// Ранее добавленные отметки сохраняются.
+func ActedKeyboard(old *tb.ReplyMarkup, action, status string, keep bool) *tb.ReplyMarkup {
+	var buttons []Button
+	var done [][]tb.InlineButton
+	if old != nil {
+		for _, row := range old.InlineKeyboard {
+			for _, b := range row {
+				data := strings.TrimPrefix(b.Data, "\f"+ButtonUnique+"|")
+				a, _, _ := strings.Cut(data, "|")
+				switch {
+				case a == ActionDone:
+					done = append(done, []tb.InlineButton{b})
+				case keep && a != action:
+					buttons = append(buttons, Button{Text: b.Text, Data: data})
+				}
+			}
+		}
+	}
+
+	markup := keyboard(buttons)
+	if markup == nil {
+		markup = &tb.ReplyMarkup{}
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, done...)
+	markup.InlineKeyboard = append(markup.InlineKeyboard, []tb.InlineButton{
+		{Unique: ButtonUnique, Text: status, Data: ActionDone},
+	})
+	return markup
+}
+
+// Reply отправляет текст без разметки ответом на сообщение, в ту же тему форума.
+// Текст длиннее лимита Telegram разбивается на части.
+func Reply(to *tb.Message, text string, logger *zap.SugaredLogger) error {
+	m := tgMessage{chatID: to.Chat.ID, threadID: to.ThreadID, logger: logger}
+	for _, part := range splitText(text, tb.ModeDefault, MaxTextLength) {
+		err := withRetry(m, 1, func() error {
+			_, err := Bot.Send(to.Chat, part, &tb.SendOptions{
+				ReplyTo:               to,
+				AllowWithoutReply:     true,
+				ThreadID:              to.ThreadID,
+				DisableWebPagePreview: true,
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	tb "gopkg.in/telebot.v3"
+)
+
+// fakeBot подключает Bot к тестовому API Telegram, который отвечает на sendMessage
+// успехом или, для чатов из rejected, ошибкой «chat not found»
+func fakeBot(t *testing.T, rejected ...int64) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ChatID string `json:"chat_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		chat := req.ChatID
+		for _, id := range rejected {
+			if chat == fmt.Sprint(id) {
+				fmt.Fprint(w, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`)
+				return
+			}
+		}
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":77,"chat":{"id":%s}}}`, chat)
+	}))
+	t.Cleanup(srv.Close)
+
+	bot, err := tb.NewBot(tb.Settings{URL: srv.URL, Token: "test", Offline: true})
+	if err != nil {
+		t.Fatalf("NewBot: %v", err)
+	}
+	prev := Bot
+	Bot = bot
+	t.Cleanup(func() { Bot = prev })
+}
+
+// openOutbox открывает очередь и хранилище недоставленных во временном каталоге
+func openOutbox(t *testing.T) {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+	dir := t.TempDir()
+	dead, err := OpenDeadLetters(filepath.Join(dir, "dlq"), logger)
+	if err != nil {
+		t.Fatalf("OpenDeadLetters: %v", err)
+	}
+	if err := InitOutbox(filepath.Join(dir, "outbox"), dead, logger); err != nil {
+		t.Fatalf("InitOutbox: %v", err)
+	}
+}
+
+func TestDeliverStatus(t *testing.T) {
+	defer func(b time.Duration) { retryBackoff = b }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	sent := make(chan SentMessage, 8)
+	defer func(h []func(SentMessage)) { sentHandlers = h }(sentHandlers)
+	sentHandlers = []func(SentMessage){func(s SentMessage) { sent <- s }}
+
+	fakeBot(t, -2002)
+	openOutbox(t)
+	// Пауза чата только отмечает его ожидающим: Deliver не ждёт отправки в такой чат
+	limiter.pause(-2003, time.Hour)
+
+	tests := []struct {
+		name    string
+		msg     Message
+		status  DeliveryStatus
+		wantErr bool
+		// sent — о сообщении сообщено обработчикам OnSent до возврата из Deliver
+		sent bool
+	}{
+		{name: "sent", msg: Message{Channel: "-2001", Text: "hi", Ref: "work|INBOX|1|6|-2001"}, status: Sent, sent: true},
+		{name: "rejected", msg: Message{Channel: "-2002", Text: "hi", Ref: "work|INBOX|1|6|-2002"}, status: Queued, wantErr: true},
+		{name: "held", msg: Message{Channel: "-2001", Text: "hi", Ref: "work|INBOX|1|7|-2001", NotBefore: time.Now().Add(time.Hour)}, status: Queued},
+		{name: "invalid channel", msg: Message{Channel: "@name", Text: "hi"}, status: Queued, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := Deliver(tt.msg, zap.NewNop().Sugar())
+			if status != tt.status || (err != nil) != tt.wantErr {
+				t.Fatalf("Deliver = %v, %v; want %v, error %v", status, err, tt.status, tt.wantErr)
+			}
+			select {
+			case s := <-sent:
+				if !tt.sent || s.Ref != tt.msg.Ref || s.MessageID != 77 {
+					t.Errorf("OnSent called with %+v, want sent %v", s, tt.sent)
+				}
+			default:
+				if tt.sent {
+					t.Error("OnSent is not called for the sent message")
+				}
+			}
+		})
+	}
+
+	// Неотправленные сообщения письма: недоставленное и отложенное
+	if got, want := Pending("work|INBOX|1|6|"), []string{"work|INBOX|1|6|-2002"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Pending = %v, want %v", got, want)
+	}
+	if got, want := Pending("work|INBOX|1|7|"), []string{"work|INBOX|1|7|-2001"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Pending = %v, want %v", got, want)
+	}
+	if got := Pending("work|INBOX|1|1"); got != nil {
+		t.Errorf("Pending matched other messages: %v", got)
+	}
+
+	t.Run("throttled", func(t *testing.T) {
+		ref := "work|INBOX|1|8|-2003"
+		status, err := Deliver(Message{Channel: "-2003", Text: "hi", Ref: ref}, zap.NewNop().Sugar())
+		if status != Queued || err != nil {
+			t.Fatalf("Deliver = %v, %v; want queued", status, err)
+		}
+		// Сообщение отправляется из очереди позже, и об этом сообщают обработчики OnSent
+		select {
+		case s := <-sent:
+			if s.Ref != ref {
+				t.Errorf("OnSent called for %s, want %s", s.Ref, ref)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("queued message is not sent")
+		}
+		for Pending(ref) != nil {
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
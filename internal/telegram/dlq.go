@@ -0,0 +1,205 @@
+package telegram
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// DeadLetter — сообщение, которое не удалось доставить после всех попыток
+type DeadLetter struct {
+	record
+	LastError string    `json:"last_error"`
+	Failures  int       `json:"failures"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// DeadLetters — хранилище недоставленных сообщений на диске
+type DeadLetters struct {
+	mu     sync.Mutex
+	dir    string
+	logger *zap.SugaredLogger
+}
+
+// deadLetterHandler вызывается при попадании нового сообщения в хранилище
+var deadLetterHandler func(DeadLetter)
+
+// OnDeadLetter задаёт обработчик новых недоставленных сообщений
+func OnDeadLetter(h func(DeadLetter)) {
+	deadLetterHandler = h
+}
+
+// OpenDeadLetters открывает хранилище недоставленных сообщений
+func OpenDeadLetters(dir string, logger *zap.SugaredLogger) (*DeadLetters, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create dead letter directory: %w", err)
+	}
+	d := &DeadLetters{dir: dir, logger: logger}
+	d.updateMetrics()
+	return d, nil
+}
+
+// put сохраняет недоставленное сообщение. Сообщение с той же ссылкой на письмо
+// перезаписывается, чтобы повторные доставки не создавали дубликатов.
+// Возвращает true, если сообщение попало в хранилище впервые.
+func (d *DeadLetters) put(m tgMessage, cause error) (DeadLetter, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := m.id
+	if m.ref != "" {
+		id = fmt.Sprintf("%x", sha256.Sum256([]byte(m.ref)))[:16]
+	}
+
+	dl := DeadLetter{record: m.record(), Failures: 1}
+	existing, err := d.read(id)
+	isNew := err != nil
+	if !isNew {
+		dl.Failures = existing.Failures + 1
+	}
+	dl.ID = id
+	dl.LastError = cause.Error()
+	dl.FailedAt = time.Now()
+
+	data, err := json.MarshalIndent(dl, "", "  ")
+	if err != nil {
+		return dl, false, fmt.Errorf("cannot encode dead letter: %w", err)
+	}
+	if err := writeFileSync(d.path(id), data); err != nil {
+		return dl, false, err
+	}
+	d.updateMetrics()
+	return dl, isNew, nil
+}
+
+// List возвращает недоставленные сообщения в порядке поступления.
+// Файлы, которые не удалось прочитать, пропускаются с предупреждением в журнале.
+func (d *DeadLetters) List() ([]DeadLetter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(d.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list dead letters: %w", err)
+	}
+
+	var result []DeadLetter
+	for _, file := range files {
+		dl, err := d.read(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			d.logger.Warnw("unreadable dead letter, skipping", "file", file, "error", err)
+			continue
+		}
+		result = append(result, dl)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].FailedAt.Before(result[j].FailedAt) })
+	return result, nil
+}
+
+// Get возвращает недоставленное сообщение по идентификатору
+func (d *DeadLetters) Get(id string) (DeadLetter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.read(id)
+}
+
+// Remove удаляет недоставленное сообщение
+func (d *DeadLetters) Remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := os.Remove(d.path(id)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("dead letter %s not found", id)
+		}
+		return fmt.Errorf("cannot remove dead letter: %w", err)
+	}
+	d.updateMetrics()
+	return nil
+}
+
+// removeRef удаляет недоставленное сообщение по ссылке на письмо, если оно есть.
+// Вызывается после успешной повторной доставки того же письма.
+func (d *DeadLetters) removeRef(ref string) {
+	if ref == "" {
+		return
+	}
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte(ref)))[:16]
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.Remove(d.path(id)); err == nil {
+		d.updateMetrics()
+	}
+}
+
+// Replay переносит недоставленное сообщение в очередь исходящих сообщений в каталоге outboxDir
+// и удаляет его из хранилища. Сообщение отправляет запущенный сервис (или сервис при следующем
+// запуске) так же, как остальные сообщения очереди: со связью с письмом для ответов и переписок.
+// При новой неудаче сообщение возвращается в хранилище.
+func (d *DeadLetters) Replay(id, outboxDir string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dl, err := d.read(id)
+	if err != nil {
+		return err
+	}
+
+	r := dl.record
+	r.ID = fmt.Sprintf("%020d", time.Now().UnixNano())
+	r.CreatedAt = time.Now()
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("cannot encode outbox message: %w", err)
+	}
+	if err := os.MkdirAll(outboxDir, 0o755); err != nil {
+		return fmt.Errorf("cannot create outbox directory: %w", err)
+	}
+	if err := writeFileSync(filepath.Join(outboxDir, r.ID+".json"), data); err != nil {
+		return err
+	}
+
+	if err := os.Remove(d.path(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove dead letter: %w", err)
+	}
+	d.updateMetrics()
+	return nil
+}
+
+// read читает сообщение из файла. Вызывается под блокировкой.
+func (d *DeadLetters) read(id string) (DeadLetter, error) {
+	var dl DeadLetter
+	data, err := os.ReadFile(d.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dl, fmt.Errorf("dead letter %s not found", id)
+		}
+		return dl, fmt.Errorf("cannot read dead letter: %w", err)
+	}
+	if err := json.Unmarshal(data, &dl); err != nil {
+		return dl, fmt.Errorf("cannot parse dead letter %s: %w", id, err)
+	}
+	return dl, nil
+}
+
+// updateMetrics обновляет метрику размера хранилища. Вызывается под блокировкой.
+func (d *DeadLetters) updateMetrics() {
+	files, err := filepath.Glob(filepath.Join(d.dir, "*.json"))
+	if err == nil {
+		metrics.TgDeadLetters.Set(float64(len(files)))
+	}
+}
+
+func (d *DeadLetters) path(id string) string {
+	return filepath.Join(d.dir, filepath.Base(id)+".json")
+}
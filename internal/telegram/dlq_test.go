@@ -0,0 +1,142 @@
+package telegram
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func openDeadLetters(t *testing.T) *DeadLetters {
+	t.Helper()
+	d, err := OpenDeadLetters(filepath.Join(t.TempDir(), "dlq"), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("OpenDeadLetters: %v", err)
+	}
+	return d
+}
+
+func TestDeadLettersList(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt map[string]string
+		want    int
+	}{
+		{name: "all readable", want: 2},
+		{name: "corrupt file skipped", corrupt: map[string]string{"broken.json": "{"}, want: 2},
+		{name: "directory skipped", corrupt: map[string]string{"dir.json/x": ""}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := openDeadLetters(t)
+			for _, ref := range []string{"work|INBOX|1|1|a", "work|INBOX|1|2|a"} {
+				if _, _, err := d.put(tgMessage{ref: ref, chatID: 1, text: "x"}, errors.New("boom")); err != nil {
+					t.Fatalf("put: %v", err)
+				}
+			}
+			for name, data := range tt.corrupt {
+				path := filepath.Join(d.dir, name)
+				os.MkdirAll(filepath.Dir(path), 0o755)
+				if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			letters, err := d.List()
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if len(letters) != tt.want {
+				t.Errorf("List returned %d letters, want %d", len(letters), tt.want)
+			}
+		})
+	}
+}
+
+func TestDeadLettersPutCountsFailures(t *testing.T) {
+	d := openDeadLetters(t)
+	m := tgMessage{ref: "work|INBOX|1|1|a", chatID: 1, text: "x"}
+	first, isNew, _ := d.put(m, errors.New("first"))
+	second, again, _ := d.put(m, errors.New("second"))
+	if !isNew || again || first.ID != second.ID {
+		t.Fatalf("put: new = %v, %v; ids %s, %s", isNew, again, first.ID, second.ID)
+	}
+	if second.Failures != 2 || second.LastError != "second" {
+		t.Errorf("dead letter = %+v, want 2 failures and last error", second)
+	}
+}
+
+func TestReplayQueuesThroughOutbox(t *testing.T) {
+	tests := []struct {
+		name string
+		// queued — ссылка на письмо, сообщение о котором уже стоит в очереди сервиса
+		queued string
+		want   int
+	}{
+		{name: "adopted by outbox", want: 1},
+		{name: "duplicate of queued message", queued: "work|INBOX|1|7|a", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := openDeadLetters(t)
+			outboxDir := filepath.Join(t.TempDir(), "outbox")
+			dl, _, err := d.put(tgMessage{ref: "work|INBOX|1|7|a", chatID: 42, text: "hello", replyTo: 5}, errors.New("boom"))
+			if err != nil {
+				t.Fatalf("put: %v", err)
+			}
+
+			if err := d.Replay(dl.ID, outboxDir); err != nil {
+				t.Fatalf("Replay: %v", err)
+			}
+			if _, err := d.Get(dl.ID); err == nil {
+				t.Error("dead letter is still stored after replay")
+			}
+
+			b := &outbox{
+				dir:     outboxDir,
+				byRef:   make(map[string]*tgMessage),
+				workers: map[int64]bool{42: true}, // обработчик чата не запускается в тесте
+				dead:    d,
+				logger:  zap.NewNop().Sugar(),
+			}
+			if tt.queued != "" {
+				b.add(&tgMessage{id: "queued", ref: tt.queued, chatID: 42})
+			}
+			b.scan()
+			b.scan()
+
+			var adopted []*tgMessage
+			for _, m := range b.pending {
+				if m.id != "queued" {
+					adopted = append(adopted, m)
+				}
+			}
+			if len(adopted) != tt.want {
+				t.Fatalf("adopted %d messages, want %d", len(adopted), tt.want)
+			}
+			files, _ := filepath.Glob(filepath.Join(outboxDir, "*.json"))
+			if len(files) != tt.want {
+				t.Errorf("outbox has %d files, want %d", len(files), tt.want)
+			}
+			if tt.want == 0 {
+				return
+			}
+			m := adopted[0]
+			if m.ref != dl.Ref || m.text != "hello" || m.replyTo != 5 || b.byRef[m.ref] != m {
+				t.Errorf("adopted message = %+v, want the dead letter with its reference", m)
+			}
+			if filepath.Base(files[0]) != m.id+".json" {
+				t.Errorf("message id %s does not match file %s", m.id, files[0])
+			}
+		})
+	}
+}
+
+func TestReplayUnknownID(t *testing.T) {
+	d := openDeadLetters(t)
+	if err := d.Replay("missing", t.TempDir()); err == nil {
+		t.Error("Replay of a missing dead letter succeeded")
+	}
+}
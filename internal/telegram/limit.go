@@ -0,0 +1,105 @@
+package telegram
+
+import (
+	"sync"
+	"time"
+)
+
+// Ограничения Telegram Bot API на частоту отправки
+const (
+	globalRate  = 30.0        // сообщений в секунду для всех чатов
+	groupRate   = 20.0 / 60.0 // сообщений в секунду в одну группу или канал
+	groupBurst  = 20.0
+	privateRate = 1.0 // сообщений в секунду в личный чат
+)
+
+// bucket — token bucket: rate токенов в секунду, не более burst в запасе
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst float64) *bucket {
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve забирает n токенов и возвращает время ожидания до момента, когда их можно использовать
+func (b *bucket) reserve(n float64, now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// rateLimiter ограничивает частоту отправки глобально и для каждого чата
+type rateLimiter struct {
+	mu     sync.Mutex
+	global *bucket
+	chats  map[int64]*bucket
+	// paused — до какого времени чат ждёт после ошибки отправки или retry-after
+	paused map[int64]time.Time
+}
+
+var limiter = &rateLimiter{
+	global: newBucket(globalRate, globalRate),
+	chats:  make(map[int64]*bucket),
+	paused: make(map[int64]time.Time),
+}
+
+// wait блокирует вызывающего, пока отправка n сообщений в чат не уложится в лимиты.
+// Сначала выдерживается лимит чата и только затем занимается общий лимит, чтобы
+// ожидание одного чата не расходовало общий лимит, нужный остальным.
+// Отрицательный идентификатор — группа или канал, положительный — личный чат.
+func (l *rateLimiter) wait(chatID int64, n int) {
+	l.mu.Lock()
+	chat, ok := l.chats[chatID]
+	if !ok {
+		if chatID < 0 {
+			chat = newBucket(groupRate, groupBurst)
+		} else {
+			chat = newBucket(privateRate, privateRate)
+		}
+		l.chats[chatID] = chat
+	}
+	delay := chat.reserve(float64(n), time.Now())
+	l.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	l.mu.Lock()
+	delay = l.global.reserve(float64(n), time.Now())
+	l.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// pause отмечает, что отправка в чат приостановлена на время d
+func (l *rateLimiter) pause(chatID int64, d time.Duration) {
+	l.mu.Lock()
+	l.paused[chatID] = time.Now().Add(d)
+	l.mu.Unlock()
+}
+
+// throttled сообщает, ждёт ли чат после ошибки отправки или retry-after
+func (l *rateLimiter) throttled(chatID int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until, ok := l.paused[chatID]
+	if ok && !time.Now().Before(until) {
+		delete(l.paused, chatID)
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,87 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+)
+
+// reservation — вызов reserve через at после начала теста для n токенов
+type reservation struct {
+	at time.Duration
+	n  float64
+}
+
+func TestBucketReserve(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		rate  float64
+		burst float64
+		calls []reservation
+		want  time.Duration // ожидание последнего вызова
+	}{
+		{
+			name: "burst available", rate: groupRate, burst: groupBurst,
+			calls: []reservation{{0, 20}},
+			want:  0,
+		},
+		{
+			name: "group over burst waits three seconds", rate: groupRate, burst: groupBurst,
+			calls: []reservation{{0, 20}, {0, 1}},
+			want:  3 * time.Second,
+		},
+		{
+			name: "private chat refills after a second", rate: privateRate, burst: privateRate,
+			calls: []reservation{{0, 1}, {time.Second, 1}},
+			want:  0,
+		},
+		{
+			name: "refill capped at burst", rate: privateRate, burst: privateRate,
+			calls: []reservation{{time.Hour, 1}, {time.Hour, 1}},
+			want:  time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &bucket{rate: tt.rate, burst: tt.burst, tokens: tt.burst, last: start}
+			var got time.Duration
+			for _, c := range tt.calls {
+				got = b.reserve(c.n, start.Add(c.at))
+			}
+			if diff := got - tt.want; diff > time.Millisecond || diff < -time.Millisecond {
+				t.Errorf("reserve wait = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterThrottled(t *testing.T) {
+	l := &rateLimiter{chats: make(map[int64]*bucket), paused: make(map[int64]time.Time)}
+	tests := []struct {
+		name  string
+		chat  int64
+		pause time.Duration
+		want  bool
+	}{
+		{name: "not paused", chat: 1, want: false},
+		{name: "paused", chat: 2, pause: time.Hour, want: true},
+		{name: "pause expired", chat: 3, pause: -time.Second, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.pause != 0 {
+				l.pause(tt.chat, tt.pause)
+			}
+			if got := l.throttled(tt.chat); got != tt.want {
+				t.Errorf("throttled = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	// Пауза одного чата не влияет на остальные, а истёкшая пауза забывается
+	if _, ok := l.paused[3]; ok {
+		t.Error("expired pause is still stored")
+	}
+	if l.throttled(1) {
+		t.Error("chat 1 throttled by another chat's pause")
+	}
+}
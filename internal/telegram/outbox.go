@@ -0,0 +1,418 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/pkg/metrics"
+	"go.uber.org/zap"
+	tb "gopkg.in/telebot.v3"
+)
+
+// record — сообщение очереди в том виде, в котором оно хранится на диске
+type record struct {
+	ID          string       `json:"id"`
+	Ref         string       `json:"ref,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	ChatID      int64        `json:"chat_id"`
+	ThreadID    int          `json:"thread_id,omitempty"`
+	Text        string       `json:"text"`
+	ParseMode   tb.ParseMode `json:"parse_mode,omitempty"`
+	Silent      bool         `json:"silent,omitempty"`
+	Protected   bool         `json:"protected,omitempty"`
+	NoPreview   bool         `json:"no_preview,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Caption     string       `json:"caption,omitempty"`
+	LongText    string       `json:"long_text,omitempty"`
+	FullBody    *Attachment  `json:"full_body,omitempty"`
+	Buttons     []Button     `json:"buttons,omitempty"`
+	ReplyTo     int          `json:"reply_to,omitempty"`
+	NotBefore   time.Time    `json:"not_before,omitzero"`
+}
+
+// outbox — очередь исходящих сообщений на диске. Каждое сообщение хранится в отдельном
+// файле до завершения отправки, поэтому переживает перезапуск и падение процесса.
+// Сообщения каждого чата отправляет отдельный обработчик в порядке поступления,
+// поэтому ожидание или сбой в одном чате не задерживает остальные.
+// Отложенные сообщения ждут своего времени, не задерживая остальные сообщения чата.
+type outbox struct {
+	mu      sync.Mutex
+	dir     string
+	seq     uint64
+	pending []*tgMessage
+	// byRef связывает ссылку на письмо с сообщением в очереди, чтобы не ставить его дважды
+	byRef map[string]*tgMessage
+	// workers — чаты, для которых запущен обработчик
+	workers map[int64]bool
+	dead    *DeadLetters
+	logger  *zap.SugaredLogger
+}
+
+var box *outbox
+
+// InitOutbox открывает очередь исходящих сообщений, ставит в неё неотправленные
+// сообщения с прошлого запуска и запускает обработчик очереди.
+// Сообщения, которые не удалось доставить, сохраняются в хранилище dead.
+func InitOutbox(dir string, dead *DeadLetters, logger *zap.SugaredLogger) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("cannot create outbox directory: %w", err)
+	}
+
+	b := &outbox{
+		dir:     dir,
+		byRef:   make(map[string]*tgMessage),
+		workers: make(map[int64]bool),
+		dead:    dead,
+		logger:  logger,
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("cannot list outbox: %w", err)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("cannot read outbox message: %w", err)
+		}
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			logger.Warnw("corrupted outbox message, skipping", "file", file, "error", err)
+			continue
+		}
+		b.add(fromRecord(r, logger))
+	}
+	b.seq = uint64(time.Now().UnixNano())
+
+	if len(b.pending) > 0 {
+		logger.Infow("replaying undelivered telegram messages", "count", len(b.pending))
+	}
+
+	box = b
+	b.mu.Lock()
+	for _, m := range b.pending {
+		b.schedule(m)
+	}
+	b.updateMetrics()
+	b.mu.Unlock()
+	go b.watch()
+	return nil
+}
+
+// add ставит в очередь сообщение, прочитанное с диска. Вызывается под блокировкой
+// или до запуска обработчиков.
+func (b *outbox) add(m *tgMessage) {
+	b.pending = append(b.pending, m)
+	if m.ref != "" {
+		b.byRef[m.ref] = m
+	}
+}
+
+// scan ставит в очередь сообщения, добавленные в каталог очереди другим процессом
+// (например, командой dlq replay). Сообщение, письмо которого уже стоит в очереди, удаляется.
+func (b *outbox) scan() {
+	files, err := filepath.Glob(filepath.Join(b.dir, "*.json"))
+	if err != nil {
+		b.logger.Warnw("cannot list outbox", "error", err)
+		return
+	}
+	sort.Strings(files)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	known := make(map[string]bool, len(b.pending))
+	for _, m := range b.pending {
+		known[m.id] = true
+	}
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".json")
+		if known[id] {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			// Сообщение могло быть отправлено и удалено после получения списка файлов
+			if !os.IsNotExist(err) {
+				b.logger.Warnw("cannot read outbox message", "file", file, "error", err)
+			}
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			b.logger.Warnw("corrupted outbox message, skipping", "file", file, "error", err)
+			continue
+		}
+		m := fromRecord(r, b.logger)
+		m.id = id
+		if _, queued := b.byRef[m.ref]; m.ref != "" && queued {
+			b.logger.Infow("message is already queued, dropping duplicate", "ref", m.ref)
+			os.Remove(file)
+			continue
+		}
+		b.add(m)
+		b.schedule(m)
+		b.logger.Infow("queued message added to outbox", "id", id, "chat", m.chatID, "ref", m.ref)
+	}
+	b.updateMetrics()
+}
+
+// push сохраняет сообщение на диск и ставит его в очередь.
+// Если в очереди уже есть сообщение с той же ссылкой, новое не создаётся:
+// ожидающий получит результат отправки уже поставленного сообщения.
+func (b *outbox) push(m *tgMessage) error {
+	if b == nil {
+		return errors.New("telegram outbox is not initialized")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if m.ref != "" {
+		if existing, ok := b.byRef[m.ref]; ok {
+			if m.result != nil {
+				existing.waiters = append(existing.waiters, m.result)
+			}
+			return nil
+		}
+	}
+
+	b.seq++
+	m.id = fmt.Sprintf("%020d", b.seq)
+	m.createdAt = time.Now()
+
+	data, err := json.Marshal(m.record())
+	if err != nil {
+		return fmt.Errorf("cannot encode outbox message: %w", err)
+	}
+	if err := writeFileSync(b.path(m.id), data); err != nil {
+		return err
+	}
+
+	if m.result != nil {
+		m.waiters = append(m.waiters, m.result)
+	}
+	b.pending = append(b.pending, m)
+	if m.ref != "" {
+		b.byRef[m.ref] = m
+	}
+	b.updateMetrics()
+	b.schedule(m)
+	return nil
+}
+
+// schedule запускает обработчик чата сразу или, для отложенного сообщения, в момент
+// его отправки. Вызывается под блокировкой.
+func (b *outbox) schedule(m *tgMessage) {
+	if wait := time.Until(m.notBefore); wait > 0 {
+		time.AfterFunc(wait, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.startWorker(m.chatID)
+		})
+		return
+	}
+	b.startWorker(m.chatID)
+}
+
+// startWorker запускает обработчик чата, если он ещё не запущен. Вызывается под блокировкой.
+func (b *outbox) startWorker(chatID int64) {
+	if b.workers[chatID] {
+		return
+	}
+	b.workers[chatID] = true
+	go b.work(chatID)
+}
+
+// work последовательно отправляет сообщения одного чата и завершается, когда они заканчиваются
+func (b *outbox) work(chatID int64) {
+	for {
+		m, snapshot, ok := b.next(chatID)
+		if !ok {
+			return
+		}
+		err := sendWithRetry(snapshot)
+		if err != nil {
+			b.bury(snapshot, err)
+		} else {
+			b.dead.removeRef(snapshot.ref)
+		}
+		b.done(m, err)
+	}
+}
+
+// bury сохраняет недоставленное сообщение и уведомляет обработчик
+func (b *outbox) bury(m tgMessage, cause error) {
+	dl, isNew, err := b.dead.put(m, cause)
+	if err != nil {
+		b.logger.Errorw("cannot save dead letter, message lost", "chat", m.chatID, "error", err)
+		return
+	}
+	b.logger.Warnw("message moved to dead letter store", "id", dl.ID, "chat", m.chatID, "ref", m.ref)
+	if isNew && deadLetterHandler != nil {
+		deadLetterHandler(dl)
+	}
+}
+
+// next возвращает первое сообщение чата, время отправки которого наступило, вместе с копией
+// для отправки. Если таких сообщений нет, обработчик чата снимается с учёта и ok=false:
+// для отложенных сообщений он будет запущен заново в момент их отправки.
+func (b *outbox) next(chatID int64) (m *tgMessage, snapshot tgMessage, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for _, p := range b.pending {
+		if p.chatID == chatID && !now.Before(p.notBefore) {
+			return p, *p, true
+		}
+	}
+	delete(b.workers, chatID)
+	return nil, tgMessage{}, false
+}
+
+// done удаляет обработанное сообщение из очереди и сообщает результат ожидающим
+func (b *outbox) done(m *tgMessage, err error) {
+	b.mu.Lock()
+	if removeErr := os.Remove(b.path(m.id)); removeErr != nil && !os.IsNotExist(removeErr) {
+		b.logger.Errorw("cannot remove outbox message", "id", m.id, "error", removeErr)
+	}
+	for i, p := range b.pending {
+		if p == m {
+			b.pending = append(b.pending[:i], b.pending[i+1:]...)
+			break
+		}
+	}
+	if m.ref != "" {
+		delete(b.byRef, m.ref)
+	}
+	waiters := m.waiters
+	b.updateMetrics()
+	b.mu.Unlock()
+
+	for _, w := range waiters {
+		w <- err
+	}
+}
+
+// QueueDepth возвращает количество сообщений в очереди на отправку
+func QueueDepth() int {
+	if box == nil {
+		return 0
+	}
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	return len(box.pending)
+}
+
+// watch периодически подхватывает сообщения, добавленные в каталог очереди,
+// и обновляет метрику возраста старейшего сообщения
+func (b *outbox) watch() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.scan()
+	}
+}
+
+// updateMetrics обновляет метрики очереди. Вызывается под блокировкой.
+func (b *outbox) updateMetrics() {
+	metrics.TgQueueDepth.Set(float64(len(b.pending)))
+	// Отложенные сообщения не считаются застрявшими, пока не наступило время их отправки
+	now := time.Now()
+	for _, m := range b.pending {
+		if !now.Before(m.notBefore) {
+			since := m.createdAt
+			if m.notBefore.After(since) {
+				since = m.notBefore
+			}
+			metrics.TgQueueOldestAge.Set(now.Sub(since).Seconds())
+			return
+		}
+	}
+	metrics.TgQueueOldestAge.Set(0)
+}
+
+func (b *outbox) path(id string) string {
+	return filepath.Join(b.dir, id+".json")
+}
+
+// record преобразует сообщение в формат хранения
+func (m *tgMessage) record() record {
+	return record{
+		ID:          m.id,
+		Ref:         m.ref,
+		CreatedAt:   m.createdAt,
+		ChatID:      m.chatID,
+		ThreadID:    m.threadID,
+		Text:        m.text,
+		ParseMode:   m.parseMode,
+		Silent:      m.silent,
+		Protected:   m.protected,
+		NoPreview:   m.noPreview,
+		Attachments: m.attachments,
+		Caption:     m.caption,
+		LongText:    m.longText,
+		FullBody:    m.fullBody,
+		Buttons:     m.buttons,
+		ReplyTo:     m.replyTo,
+		NotBefore:   m.notBefore,
+	}
+}
+
+// fromRecord восстанавливает сообщение из формата хранения
+func fromRecord(r record, logger *zap.SugaredLogger) *tgMessage {
+	return &tgMessage{
+		id:          r.ID,
+		ref:         r.Ref,
+		createdAt:   r.CreatedAt,
+		chatID:      r.ChatID,
+		threadID:    r.ThreadID,
+		text:        r.Text,
+		parseMode:   r.ParseMode,
+		silent:      r.Silent,
+		protected:   r.Protected,
+		noPreview:   r.NoPreview,
+		attachments: r.Attachments,
+		caption:     r.Caption,
+		longText:    r.LongText,
+		fullBody:    r.FullBody,
+		buttons:     r.Buttons,
+		replyTo:     r.ReplyTo,
+		notBefore:   r.NotBefore,
+		logger:      logger,
+	}
+}
+
+// writeFileSync атомарно записывает файл и сбрасывает его на диск
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("cannot create outbox message: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write outbox message: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot sync outbox message: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot close outbox message: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("cannot save outbox message: %w", err)
+	}
+	return nil
+}
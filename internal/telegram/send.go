@@ -1,101 +1,375 @@
 package telegram
 
 import (
+	"errors"
+	"fmt"
+	"github.com/st-kuptsov/mail2tg/config"
 	"github.com/st-kuptsov/mail2tg/pkg/metrics"
 	"go.uber.org/zap"
 	tb "gopkg.in/telebot.v3"
+	"net/http"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 )
 
 var Bot *tb.Bot
 
+// deliverWait — сколько Deliver ждёт результата отправки, прежде чем оставить сообщение в очереди
+const deliverWait = 10 * time.Second
+
+// Message — сообщение для доставки в Telegram
+type Message struct {
+	Channel string
+	// ThreadID — тема форума в супергруппе (message_thread_id); 0 — основной чат
+	ThreadID  int
+	Text      string
+	ParseMode tb.ParseMode
+	// DisableNotification, Protected и DisableLinkPreview — параметры отправки Telegram
+	DisableNotification bool
+	Protected           bool
+	DisableLinkPreview  bool
+	// Attachments отправляются ответом на текстовое сообщение
+	Attachments []Attachment
+	// Caption — подпись к вложениям
+	Caption string
+	// LongText — обработка текста длиннее лимита Telegram: LongTextSplit (по умолчанию) или LongTextTruncate
+	LongText string
+	// FullBody — файл с полным текстом письма, отправляемый при обрезке сообщения
+	FullBody *Attachment
+	// Buttons — кнопки действий под первым сообщением
+	Buttons []Button
+	// ReplyTo — сообщение в том же чате, ответом на которое отправляется текст; 0 — без ответа
+	ReplyTo int
+	// NotBefore — сообщение отложено и отправляется не раньше этого времени
+	NotBefore time.Time
+	// Ref — ссылка на исходное письмо и канал. Сообщение с той же ссылкой,
+	// уже находящееся в очереди, повторно не ставится.
+	Ref string
+}
+
 // структура сообщения в очереди
 type tgMessage struct {
-	chatID int64
-	text   string
-	retry  int
-	logger *zap.SugaredLogger
+	id          string
+	ref         string
+	createdAt   time.Time
+	chatID      int64
+	threadID    int
+	text        string
+	parseMode   tb.ParseMode
+	silent      bool
+	protected   bool
+	noPreview   bool
+	attachments []Attachment
+	caption     string
+	longText    string
+	fullBody    *Attachment
+	buttons     []Button
+	replyTo     int
+	notBefore   time.Time
+	retry       int
+	logger      *zap.SugaredLogger
+	result      chan error   // если задан, получает итог отправки
+	waiters     []chan error // все ожидающие результата отправки
 }
 
-// очередь сообщений
-var (
-	queue      = make(chan tgMessage, 100) // буфер очереди
-	queueMutex sync.Mutex
-)
+// SentMessage — сведения об отправленном сообщении с текстом письма
+type SentMessage struct {
+	// Ref — ссылка на письмо и канал из Message.Ref
+	Ref       string
+	ChatID    int64
+	ThreadID  int
+	MessageID int
+	// ReplyTo — сообщение, ответом на которое отправлено сообщение; 0 — без ответа
+	ReplyTo int
+}
 
-func init() {
-	go worker()
+// sentHandlers вызываются после отправки сообщения, у которого задан Ref
+var sentHandlers []func(SentMessage)
+
+// OnSent добавляет обработчик успешно отправленных сообщений. Вызывается до запуска очереди.
+func OnSent(h func(SentMessage)) {
+	sentHandlers = append(sentHandlers, h)
 }
 
-// SendToTelegram помещает сообщение в очередь на отправку
+// SendToTelegram помещает сообщение в очередь на отправку, не дожидаясь результата.
+// channel — chat_id или chat_id/message_thread_id.
 func SendToTelegram(msg, channel string, logger *zap.SugaredLogger) {
 	if channel == "" {
 		logger.Warn("empty channel_id")
 		return
 	}
-	chatID := parseChatID(channel)
+	d := config.ParseDestination(channel)
+	chatID := parseChatID(d.ChatID)
 	if chatID == 0 {
 		logger.Warnf("invalid channel_id format: %s", channel)
 		return
 	}
 
 	// помещаем в очередь
-	select {
-	case queue <- tgMessage{chatID: chatID, text: msg, retry: 0, logger: logger}:
+	if err := box.push(&tgMessage{chatID: chatID, threadID: d.ThreadID, text: msg, retry: 0, logger: logger}); err != nil {
+		logger.Errorw("failed to queue telegram message, dropping", "error", err)
+	}
+}
+
+// DeliveryStatus — результат Deliver
+type DeliveryStatus int
+
+const (
+	// Sent — сообщение отправлено в Telegram
+	Sent DeliveryStatus = iota
+	// Queued — сообщение осталось в очереди и будет отправлено позже
+	Queued
+)
+
+// Deliver помещает сообщение в очередь на диске и ждёт результата отправки не дольше
+// deliverWait. Возвращает ошибку, если сообщение не удалось поставить в очередь или доставить.
+// Отложенное сообщение (NotBefore в будущем), сообщение в чат, отправка в который
+// приостановлена (retry-after, повтор после ошибки), и сообщение, результат которого
+// не получен за deliverWait, остаются в очереди со статусом Queued: очередь переживает
+// перезапуск, а при ошибке отправки сообщение попадёт в хранилище недоставленных.
+// Поэтому ожидание одного чата не задерживает обработку писем для остальных.
+// Об отправке сообщения из очереди сообщают обработчики OnSent.
+func Deliver(m Message, logger *zap.SugaredLogger) (DeliveryStatus, error) {
+	if m.Channel == "" {
+		return Queued, errors.New("empty channel_id")
+	}
+	chatID := parseChatID(m.Channel)
+	if chatID == 0 {
+		return Queued, fmt.Errorf("invalid channel_id format: %s", m.Channel)
+	}
+
+	var result chan error
+	switch {
+	case m.NotBefore.After(time.Now()):
+		logger.Infow("message held until schedule window opens", "chat", chatID, "not_before", m.NotBefore)
+	case limiter.throttled(chatID):
+		logger.Infow("chat is throttled, message left in outbox", "chat", chatID)
 	default:
-		logger.Warn("telegram queue full, dropping message")
+		result = make(chan error, 1)
+	}
+	err := box.push(&tgMessage{
+		ref:         m.Ref,
+		chatID:      chatID,
+		threadID:    m.ThreadID,
+		text:        m.Text,
+		parseMode:   m.ParseMode,
+		silent:      m.DisableNotification,
+		protected:   m.Protected,
+		noPreview:   m.DisableLinkPreview,
+		attachments: m.Attachments,
+		caption:     m.Caption,
+		longText:    m.LongText,
+		fullBody:    m.FullBody,
+		buttons:     m.Buttons,
+		replyTo:     m.ReplyTo,
+		notBefore:   m.NotBefore,
+		logger:      logger,
+		result:      result,
+	})
+	if err != nil {
+		return Queued, fmt.Errorf("failed to queue telegram message: %w", err)
+	}
+	if result == nil {
+		return Queued, nil
+	}
+
+	timer := time.NewTimer(deliverWait)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		if err != nil {
+			return Queued, err
+		}
+		return Sent, nil
+	case <-timer.C:
+		logger.Infow("telegram send is taking long, message left in outbox", "chat", chatID)
+		return Queued, nil
 	}
 }
 
-// worker обрабатывает очередь сообщений
-func worker() {
-	for m := range queue {
-		sendWithRetry(m)
+// Pending возвращает ссылки сообщений с префиксом prefix, которые ещё не отправлены:
+// стоят в очереди или лежат в хранилище недоставленных
+func Pending(prefix string) []string {
+	if box == nil {
+		return nil
+	}
+	var refs []string
+	box.mu.Lock()
+	for ref := range box.byRef {
+		if strings.HasPrefix(ref, prefix) {
+			refs = append(refs, ref)
+		}
+	}
+	box.mu.Unlock()
+
+	if box.dead != nil {
+		letters, err := box.dead.List()
+		if err != nil {
+			box.logger.Warnw("cannot list dead letters", "error", err)
+		}
+		for _, dl := range letters {
+			if strings.HasPrefix(dl.Ref, prefix) && !slices.Contains(refs, dl.Ref) {
+				refs = append(refs, dl.Ref)
+			}
+		}
 	}
+	sort.Strings(refs)
+	return refs
 }
 
-// sendWithRetry отправляет сообщение с экспоненциальным backoff и лимитом ретраев
-func sendWithRetry(m tgMessage) {
+// sendWithRetry отправляет сообщение и его вложения.
+// Текст длиннее лимита Telegram разбивается на части или обрезается.
+// Ошибка возвращается, только если не удалось отправить текст: повторная
+// доставка из-за вложений привела бы к дублированию текста.
+func sendWithRetry(m tgMessage) error {
+	chat := &tb.Chat{ID: m.chatID}
+
+	texts := []string{m.text}
+	attachments := m.attachments
+	if textLength(m.text) > MaxTextLength {
+		if m.longText == LongTextTruncate {
+			m.logger.Infow("message is too long, truncating", "chat", m.chatID, "length", textLength(m.text))
+			texts = []string{truncateText(m.text, m.parseMode, MaxTextLength)}
+			if m.fullBody != nil {
+				attachments = append([]Attachment{*m.fullBody}, attachments...)
+			}
+		} else {
+			texts = splitText(m.text, m.parseMode, MaxTextLength)
+			m.logger.Infow("message is too long, splitting", "chat", m.chatID, "length", textLength(m.text), "parts", len(texts))
+		}
+	}
+
+	var first *tb.Message
+	for _, text := range texts {
+		opts := &tb.SendOptions{
+			ParseMode:             m.parseMode,
+			ThreadID:              m.threadID,
+			DisableNotification:   m.silent,
+			Protected:             m.protected,
+			DisableWebPagePreview: m.noPreview,
+		}
+		if first == nil {
+			opts.ReplyMarkup = keyboard(m.buttons)
+			if m.replyTo != 0 {
+				// Если исходное сообщение удалено, текст отправляется без ответа
+				opts.ReplyTo = &tb.Message{ID: m.replyTo}
+				opts.AllowWithoutReply = true
+			}
+		}
+
+		var sent *tb.Message
+		err := withRetry(m, 1, func() error {
+			var err error
+			sent, err = Bot.Send(chat, text, opts)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if first == nil {
+			first = sent
+		}
+	}
+	m.logger.Infof("message sent successfully to chat %d", m.chatID)
+	if m.ref != "" {
+		for _, h := range sentHandlers {
+			h(SentMessage{Ref: m.ref, ChatID: m.chatID, ThreadID: m.threadID, MessageID: first.ID, ReplyTo: m.replyTo})
+		}
+	}
+
+	if len(attachments) > 0 {
+		m.attachments = attachments
+		if err := sendAttachments(m, chat, first); err != nil {
+			m.logger.Errorf("failed to send attachments to chat %d: %v", m.chatID, err)
+		}
+	}
+	return nil
+}
+
+// retryBackoff — пауза перед первым повтором отправки; каждая следующая вдвое длиннее
+var retryBackoff = time.Second
+
+// withRetry выполняет отправку с экспоненциальным backoff и лимитом ретраев.
+// Перед каждой попыткой ожидает, пока отправка count сообщений уложится в лимиты Telegram.
+// Ожидание retry-after выполняется в обработчике чата и не задерживает другие чаты.
+// Повторяются только превышение лимита (429), ошибки сервера (5xx) и сетевые ошибки:
+// остальные отказы Telegram (разметка, бот заблокирован, чат не найден) повтор не исправит.
+func withRetry(m tgMessage, count int, send func() error) error {
 	maxRetries := 5
-	backoff := time.Second * 1
+	backoff := retryBackoff
 
 	for {
+		limiter.wait(m.chatID, count)
 		start := time.Now()
-		_, err := Bot.Send(&tb.Chat{ID: m.chatID}, m.text)
+		err := send()
 		duration := time.Since(start).Seconds()
 		metrics.TgSendDuration.WithLabelValues(strconv.FormatInt(m.chatID, 10)).Observe(duration)
 
 		if err == nil {
 			metrics.TgMessagesSent.WithLabelValues(strconv.FormatInt(m.chatID, 10)).Inc()
-			m.logger.Infof("message sent successfully to chat %d", m.chatID)
-			return
+			return nil
 		}
 
 		metrics.TgErrors.WithLabelValues(strconv.FormatInt(m.chatID, 10)).Inc()
 		m.logger.Errorf("failed to send message to chat %d: %v", m.chatID, err)
 
+		if permanentError(err) {
+			m.logger.Errorf("telegram rejected message to chat %d, not retrying", m.chatID)
+			return fmt.Errorf("message to chat %d rejected: %w", m.chatID, err)
+		}
+
 		// проверяем retry-after
 		retryAfter := parseRetryAfter(err)
 		if retryAfter > 0 {
 			m.logger.Warnf("telegram API retry after %d seconds for chat %d", retryAfter, m.chatID)
-			time.Sleep(time.Duration(retryAfter) * time.Second)
+			pauseChat(m.chatID, time.Duration(retryAfter)*time.Second)
 		} else {
 			m.logger.Warnf("retrying message to chat %d after %s", m.chatID, backoff)
-			time.Sleep(backoff)
+			pauseChat(m.chatID, backoff)
 			backoff *= 2 // экспоненциальный рост
 		}
 
 		m.retry++
 		if m.retry >= maxRetries {
 			m.logger.Errorf("message to chat %d failed after %d retries", m.chatID, maxRetries)
-			return
+			return fmt.Errorf("message to chat %d failed after %d retries: %w", m.chatID, maxRetries, err)
 		}
 	}
 }
 
+// errorCodeRe извлекает код ответа из ошибки Telegram API, которую telebot не распознал
+var errorCodeRe = regexp.MustCompile(`(?s)^telegram: .*\((\d{3})\)$`)
+
+// errorCode возвращает код ответа Telegram API или 0 для сетевых и прочих ошибок
+func errorCode(err error) int {
+	var tbErr *tb.Error
+	if errors.As(err, &tbErr) {
+		return tbErr.Code
+	}
+	if m := errorCodeRe.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return code
+	}
+	return 0
+}
+
+// permanentError сообщает, что Telegram отклонил запрос и повтор не поможет: коды 4xx, кроме 429
+func permanentError(err error) bool {
+	code := errorCode(err)
+	return code >= 400 && code < 500 && code != http.StatusTooManyRequests
+}
+
+// pauseChat приостанавливает отправку в чат на время d. Пока чат ждёт, Deliver
+// не дожидается результата отправки в него.
+func pauseChat(chatID int64, d time.Duration) {
+	limiter.pause(chatID, d)
+	time.Sleep(d)
+}
+
 // parseRetryAfter извлекает время из ошибки "retry after"
 func parseRetryAfter(err error) int {
 	re := regexp.MustCompile(`retry after (\d+)`)
@@ -107,6 +381,16 @@ func parseRetryAfter(err error) int {
 	return 0
 }
 
+// LookupChat проверяет через getChat, что чат доступен боту, и возвращает его идентификатор.
+// chat — числовой chat_id или @username публичного канала или группы.
+func LookupChat(chat string) (int64, error) {
+	c, err := Bot.ChatByUsername(chat)
+	if err != nil {
+		return 0, err
+	}
+	return c.ID, nil
+}
+
 // parseChatID парсит строку channel_id в int64
 func parseChatID(s string) int64 {
 	id, err := strconv.ParseInt(s, 10, 64)
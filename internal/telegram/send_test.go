@@ -0,0 +1,89 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	tb "gopkg.in/telebot.v3"
+)
+
+func TestPermanentError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		code      int
+		permanent bool
+	}{
+		{name: "blocked by user", err: tb.ErrBlockedByUser, code: 403, permanent: true},
+		{name: "kicked from group", err: tb.ErrKickedFromGroup, code: 403, permanent: true},
+		{name: "chat not found", err: tb.ErrChatNotFound, code: 400, permanent: true},
+		{name: "unknown bad request", err: fmt.Errorf("telegram: Bad Request: can't parse entities: unexpected end tag at byte offset 12 (400)"), code: 400, permanent: true},
+		{name: "wrapped rejection", err: fmt.Errorf("send: %w", tb.ErrChatNotFound), code: 400, permanent: true},
+		{name: "flood wait", err: fmt.Errorf("telegram: Too Many Requests: retry after 7 (429)"), code: 429},
+		{name: "internal server error", err: tb.ErrInternal, code: 500},
+		{name: "bad gateway", err: fmt.Errorf("telegram: Bad Gateway (502)"), code: 502},
+		{name: "network error", err: &url.Error{Op: "Post", URL: "https://api.telegram.org", Err: errors.New("connection reset by peer")}},
+		{name: "code in the middle of text", err: errors.New("telebot: unexpected (403) reply from proxy")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorCode(tt.err); got != tt.code {
+				t.Errorf("errorCode(%v) = %d, want %d", tt.err, got, tt.code)
+			}
+			if got := permanentError(tt.err); got != tt.permanent {
+				t.Errorf("permanentError(%v) = %v, want %v", tt.err, got, tt.permanent)
+			}
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	defer func(b time.Duration) { retryBackoff = b }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	tests := []struct {
+		name    string
+		errs    []error // ошибки последовательных попыток; после них отправка успешна
+		calls   int
+		wantErr bool
+	}{
+		{name: "success", calls: 1},
+		{name: "permanent error not retried", errs: []error{tb.ErrBlockedByUser}, calls: 1, wantErr: true},
+		{name: "server error retried", errs: []error{tb.ErrInternal, tb.ErrInternal}, calls: 3},
+		{name: "network error retried", errs: []error{&url.Error{Op: "Post", Err: errors.New("timeout")}}, calls: 2},
+		{name: "permanent after transient", errs: []error{tb.ErrInternal, tb.ErrChatNotFound}, calls: 2, wantErr: true},
+		{
+			name:    "retries exhausted",
+			errs:    []error{tb.ErrInternal, tb.ErrInternal, tb.ErrInternal, tb.ErrInternal, tb.ErrInternal, tb.ErrInternal},
+			calls:   5,
+			wantErr: true,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Отдельный чат для каждого случая, чтобы не упираться в лимит отправки
+			m := tgMessage{chatID: -1000 - int64(i), logger: zap.NewNop().Sugar()}
+			calls := 0
+			err := withRetry(m, 1, func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("withRetry error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.calls {
+				t.Errorf("send called %d times, want %d", calls, tt.calls)
+			}
+			if err != nil && calls <= len(tt.errs) && !errors.Is(err, tt.errs[calls-1]) {
+				t.Errorf("error %v does not wrap the last send error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package telegram
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	// MaxTextLength — максимальная длина текста сообщения Telegram
+	MaxTextLength = 4096
+	// MaxCaptionLength — максимальная длина подписи к файлу
+	MaxCaptionLength = 1024
+	// tagsReserve — запас длины под закрывающие теги в конце части
+	tagsReserve = 128
+)
+
+// Режимы обработки длинных сообщений
+const (
+	LongTextSplit    = "split"    // разбить на несколько сообщений
+	LongTextTruncate = "truncate" // обрезать и приложить полный текст файлом
+)
+
+// textLength возвращает длину текста в единицах UTF-16, как её считает Telegram
+func textLength(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// prefixByLength возвращает длину в байтах самого длинного префикса s,
+// который укладывается в limit единиц UTF-16
+func prefixByLength(s string, limit int) int {
+	n := 0
+	for i, r := range s {
+		n += utf16.RuneLen(r)
+		if n > limit {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// splitText разбивает текст на части не длиннее limit. Разрез выполняется по границе
+// абзаца, строки или слова. Незакрытые на месте разреза теги HTML и маркеры MarkdownV2
+// закрываются в конце части и открываются заново в начале следующей.
+func splitText(text string, mode tb.ParseMode, limit int) []string {
+	if textLength(text) <= limit {
+		return []string{text}
+	}
+
+	tr := newEntityTracker(mode)
+	var parts []string
+	for text != "" {
+		prefix := tr.open()
+		budget := limit - textLength(prefix)
+		if mode != tb.ModeDefault {
+			budget -= tagsReserve
+		}
+
+		if textLength(text) <= budget {
+			parts = append(parts, prefix+text)
+			break
+		}
+
+		cut := cutPosition(text, budget, mode)
+		chunk := text[:cut]
+		tr.feed(chunk)
+
+		parts = append(parts, prefix+strings.TrimRight(chunk, " \n")+tr.close())
+		text = strings.TrimLeft(text[cut:], " \n")
+	}
+	return parts
+}
+
+// cutPosition выбирает место разреза текста в пределах budget единиц UTF-16.
+// Текст не разрезается внутри тега HTML, HTML-сущности или экранированного символа MarkdownV2.
+func cutPosition(text string, budget int, mode tb.ParseMode) int {
+	window := text[:prefixByLength(text, budget)]
+
+	// Предпочитаем абзац, затем строку, затем слово, если разрез не слишком близко к началу
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		for end := len(window); ; {
+			i := strings.LastIndex(window[:end], sep)
+			if i <= len(window)/3 {
+				break
+			}
+			if cut := i + len(sep); openMarkup(window[:cut], mode) < 0 {
+				return cut
+			}
+			end = i
+		}
+	}
+
+	// Жёсткий разрез перед незавершённым элементом разметки
+	cut := len(window)
+	if i := openMarkup(window, mode); i >= 0 {
+		cut = i
+	}
+	if cut == 0 {
+		_, size := utf8.DecodeRuneInString(text)
+		cut = size
+	}
+	return cut
+}
+
+// openMarkup возвращает позицию начала элемента разметки, который не завершён в конце s:
+// тега или сущности HTML либо экранирования MarkdownV2. Если такого нет, возвращает -1.
+func openMarkup(s string, mode tb.ParseMode) int {
+	switch mode {
+	case tb.ModeHTML:
+		if i := strings.LastIndex(s, "<"); i > strings.LastIndex(s, ">") {
+			return i
+		}
+		if i := strings.LastIndex(s, "&"); i >= 0 && !strings.ContainsAny(s[i:], "; \n") {
+			return i
+		}
+	case tb.ModeMarkdownV2:
+		n := 0
+		for n < len(s) && s[len(s)-1-n] == '\\' {
+			n++
+		}
+		if n%2 == 1 {
+			return len(s) - 1
+		}
+	}
+	return -1
+}
+
+// truncateText обрезает текст до limit с учётом разметки и добавляет маркер обрезки
+func truncateText(text string, mode tb.ParseMode, limit int) string {
+	marker := "…(truncated)"
+	if mode == tb.ModeMarkdownV2 {
+		marker = `…\(truncated\)`
+	}
+
+	parts := splitText(text, mode, limit-textLength(marker)-1)
+	return parts[0] + "\n" + marker
+}
+
+// entityTracker отслеживает незакрытые элементы разметки
+type entityTracker struct {
+	mode tb.ParseMode
+	// stack — открытые теги HTML (целиком, с атрибутами) или маркеры MarkdownV2
+	stack []string
+}
+
+func newEntityTracker(mode tb.ParseMode) *entityTracker {
+	return &entityTracker{mode: mode}
+}
+
+var htmlTagRe = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)[^>]*>`)
+
+// markdownV2Markers — маркеры сущностей MarkdownV2, от длинных к коротким
+var markdownV2Markers = []string{"```", "||", "__", "`", "*", "_", "~"}
+
+// feed учитывает открывающие и закрывающие элементы разметки во фрагменте текста
+func (t *entityTracker) feed(s string) {
+	switch t.mode {
+	case tb.ModeHTML:
+		for _, m := range htmlTagRe.FindAllStringSubmatch(s, -1) {
+			name := strings.ToLower(m[2])
+			if m[1] == "" {
+				t.stack = append(t.stack, m[0])
+				continue
+			}
+			for i := len(t.stack) - 1; i >= 0; i-- {
+				if tagName(t.stack[i]) == name {
+					t.stack = append(t.stack[:i], t.stack[i+1:]...)
+					break
+				}
+			}
+		}
+	case tb.ModeMarkdownV2:
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+				continue
+			}
+			// Внутри блока кода действует только закрывающий маркер
+			if n := len(t.stack); n > 0 && (t.stack[n-1] == "```" || t.stack[n-1] == "`") {
+				if strings.HasPrefix(s[i:], t.stack[n-1]) {
+					i += len(t.stack[n-1]) - 1
+					t.stack = t.stack[:n-1]
+				}
+				continue
+			}
+			for _, m := range markdownV2Markers {
+				if strings.HasPrefix(s[i:], m) {
+					t.toggle(m)
+					i += len(m) - 1
+					break
+				}
+			}
+		}
+	}
+}
+
+// toggle открывает маркер MarkdownV2 или закрывает ранее открытый
+func (t *entityTracker) toggle(marker string) {
+	for i := len(t.stack) - 1; i >= 0; i-- {
+		if t.stack[i] == marker {
+			t.stack = append(t.stack[:i], t.stack[i+1:]...)
+			return
+		}
+	}
+	t.stack = append(t.stack, marker)
+}
+
+// open возвращает разметку, которая открывает все незакрытые элементы
+func (t *entityTracker) open() string {
+	return strings.Join(t.stack, "")
+}
+
+// close возвращает разметку, которая закрывает все незакрытые элементы
+func (t *entityTracker) close() string {
+	var b strings.Builder
+	for i := len(t.stack) - 1; i >= 0; i-- {
+		if t.mode == tb.ModeHTML {
+			b.WriteString("</" + tagName(t.stack[i]) + ">")
+		} else {
+			b.WriteString(t.stack[i])
+		}
+	}
+	return b.String()
+}
+
+// tagName извлекает имя тега из открывающего тега HTML
+func tagName(tag string) string {
+	m := htmlTagRe.FindStringSubmatch(tag)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[2])
+}
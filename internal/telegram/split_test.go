@@ -0,0 +1,312 @@
+package telegram
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// checkParts проверяет, что каждая часть укладывается в limit, является корректным UTF-8
+// и не содержит разорванных или незакрытых элементов разметки
+func checkParts(t *testing.T, parts []string, mode tb.ParseMode, limit int) {
+	t.Helper()
+	for i, p := range parts {
+		if n := textLength(p); n > limit {
+			t.Errorf("part %d: length %d exceeds %d", i, n, limit)
+		}
+		if !utf8.ValidString(p) {
+			t.Errorf("part %d: invalid UTF-8", i)
+		}
+		if j := openMarkup(p, mode); j >= 0 {
+			t.Errorf("part %d ends inside markup: %q", i, p[j:])
+		}
+		if mode == tb.ModeHTML && strings.Count(p, "<") != strings.Count(p, ">") {
+			t.Errorf("part %d has a broken tag: %q", i, p)
+		}
+		tr := newEntityTracker(mode)
+		tr.feed(p)
+		if len(tr.stack) != 0 {
+			t.Errorf("part %d leaves %v open", i, tr.stack)
+		}
+	}
+}
+
+// plainText убирает разметку и пробельные символы, чтобы сравнить содержимое частей с исходным текстом
+func plainText(s string, mode tb.ParseMode) string {
+	switch mode {
+	case tb.ModeHTML:
+		s = htmlTagRe.ReplaceAllString(s, "")
+	case tb.ModeMarkdownV2:
+		s = strings.NewReplacer("```", "", "*", "", "_", "", "~", "").Replace(s)
+	}
+	return strings.Join(strings.Fields(s), "")
+}
+
+// chunks делит строку из однобайтовых символов на части по n байт
+func chunks(s string, n int) []string {
+	var parts []string
+	for len(s) > n {
+		parts = append(parts, s[:n])
+		s = s[n:]
+	}
+	return append(parts, s)
+}
+
+func TestSplitText(t *testing.T) {
+	words := strings.Repeat("word ", 30)
+	tests := []struct {
+		name  string
+		text  string
+		mode  tb.ParseMode
+		limit int
+		// want — ожидаемые части; если nil, проверяются только инварианты
+		want []string
+	}{
+		{
+			name:  "fits",
+			text:  "short text",
+			mode:  tb.ModeDefault,
+			limit: 20,
+			want:  []string{"short text"},
+		},
+		{
+			name:  "paragraph preferred over word",
+			text:  "aaaa bbbb\n\ncccc dddd",
+			mode:  tb.ModeDefault,
+			limit: 16,
+			want:  []string{"aaaa bbbb", "cccc dddd"},
+		},
+		{
+			name:  "hard cut without separators",
+			text:  strings.Repeat("x", 25),
+			mode:  tb.ModeDefault,
+			limit: 10,
+			want:  []string{strings.Repeat("x", 10), strings.Repeat("x", 10), strings.Repeat("x", 5)},
+		},
+		{
+			name:  "nested tags reopened",
+			text:  "<b><i>" + strings.Repeat("word ", 60) + "</i></b>",
+			mode:  tb.ModeHTML,
+			limit: tagsReserve + 100,
+		},
+		{
+			// Последний пробел в окне — внутри тега <a href>; разрез по нему разорвал бы тег
+			name:  "space inside tag",
+			text:  words[:80] + `<a href="https://example.com/x" title="a b c">link</a> ` + words,
+			mode:  tb.ModeHTML,
+			limit: tagsReserve + 100,
+		},
+		{
+			name:  "entity at boundary",
+			text:  strings.Repeat("a", 98) + "&amp;" + strings.Repeat("b", 195),
+			mode:  tb.ModeHTML,
+			limit: tagsReserve + 100,
+			want:  []string{strings.Repeat("a", 98), "&amp;" + strings.Repeat("b", 95), strings.Repeat("b", 100)},
+		},
+		{
+			name:  "escape at boundary",
+			text:  strings.Repeat("a", 9) + `\.` + strings.Repeat("b", 150),
+			mode:  tb.ModeMarkdownV2,
+			limit: tagsReserve + 10,
+			want:  append([]string{strings.Repeat("a", 9), `\.` + strings.Repeat("b", 8)}, chunks(strings.Repeat("b", 142), 10)...),
+		},
+		{
+			name:  "escaped backslash at boundary",
+			text:  strings.Repeat("a", 8) + `\\` + strings.Repeat("b", 150),
+			mode:  tb.ModeMarkdownV2,
+			limit: tagsReserve + 10,
+			want:  append([]string{strings.Repeat("a", 8) + `\\`}, chunks(strings.Repeat("b", 150), 10)...),
+		},
+		{
+			name:  "code block reopened",
+			text:  "```\n" + strings.Repeat("line\n", 60) + "```",
+			mode:  tb.ModeMarkdownV2,
+			limit: tagsReserve + 50,
+		},
+		{
+			name:  "cyrillic at telegram limit",
+			text:  strings.Repeat("я", MaxTextLength+10),
+			mode:  tb.ModeDefault,
+			limit: MaxTextLength,
+			want:  []string{strings.Repeat("я", MaxTextLength), strings.Repeat("я", 10)},
+		},
+		{
+			// Эмодзи занимает две единицы UTF-16
+			name:  "emoji at telegram limit",
+			text:  strings.Repeat("😀", MaxTextLength/2+1),
+			mode:  tb.ModeDefault,
+			limit: MaxTextLength,
+			want:  []string{strings.Repeat("😀", MaxTextLength/2), "😀"},
+		},
+		{
+			name:  "cyrillic html at telegram limit",
+			text:  "<b>" + strings.Repeat("слово ", 1500) + "</b>",
+			mode:  tb.ModeHTML,
+			limit: MaxTextLength,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitText(tt.text, tt.mode, tt.limit)
+			checkParts(t, parts, tt.mode, tt.limit)
+			if tt.want != nil && !reflect.DeepEqual(parts, tt.want) {
+				t.Errorf("splitText = %q, want %q", parts, tt.want)
+			}
+			if got, want := plainText(strings.Join(parts, ""), tt.mode), plainText(tt.text, tt.mode); got != want {
+				t.Errorf("content changed:\ngot  %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSplitTextReopensNestedTags(t *testing.T) {
+	text := `<b><a href="https://example.com">` + strings.Repeat("word ", 60) + "</a></b>"
+	parts := splitText(text, tb.ModeHTML, tagsReserve+100)
+	if len(parts) < 2 {
+		t.Fatalf("expected several parts, got %q", parts)
+	}
+	for i, p := range parts {
+		if i > 0 && !strings.HasPrefix(p, `<b><a href="https://example.com">`) {
+			t.Errorf("part %d does not reopen tags: %q", i, p)
+		}
+		if !strings.HasSuffix(p, "</a></b>") {
+			t.Errorf("part %d does not close tags: %q", i, p)
+		}
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		mode   tb.ParseMode
+		limit  int
+		marker string
+	}{
+		{name: "plain", text: strings.Repeat("word ", 100), mode: tb.ModeDefault, limit: 100, marker: "…(truncated)"},
+		{name: "html", text: "<b>" + strings.Repeat("a&amp;b ", 100) + "</b>", mode: tb.ModeHTML, limit: 300, marker: "…(truncated)"},
+		{name: "markdown", text: "*" + strings.Repeat(`a\.b `, 100) + "*", mode: tb.ModeMarkdownV2, limit: 300, marker: `…\(truncated\)`},
+		{name: "multibyte", text: strings.Repeat("я", MaxTextLength*2), mode: tb.ModeDefault, limit: MaxTextLength, marker: "…(truncated)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateText(tt.text, tt.mode, tt.limit)
+			if !strings.HasSuffix(got, "\n"+tt.marker) {
+				t.Errorf("missing marker: %q", got)
+			}
+			checkParts(t, []string{strings.TrimSuffix(got, "\n"+tt.marker)}, tt.mode, tt.limit)
+			if n := textLength(got); n > tt.limit {
+				t.Errorf("length %d exceeds %d", n, tt.limit)
+			}
+		})
+	}
+}
+
+func TestEntityTracker(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  tb.ParseMode
+		feed  []string
+		open  string
+		close string
+	}{
+		{
+			name:  "html nested",
+			mode:  tb.ModeHTML,
+			feed:  []string{`<b>bold <a href="https://e.com">link`},
+			open:  `<b><a href="https://e.com">`,
+			close: "</a></b>",
+		},
+		{
+			name:  "html closed across chunks",
+			mode:  tb.ModeHTML,
+			feed:  []string{"<b><i>x", "</i> y"},
+			open:  "<b>",
+			close: "</b>",
+		},
+		{
+			name: "html case insensitive",
+			mode: tb.ModeHTML,
+			feed: []string{"<B>x</b>"},
+		},
+		{
+			name: "html entities ignored",
+			mode: tb.ModeHTML,
+			feed: []string{"a &lt;b&gt; &amp; c"},
+		},
+		{
+			name:  "markdown nested",
+			mode:  tb.ModeMarkdownV2,
+			feed:  []string{"*bold _italic"},
+			open:  "*_",
+			close: "_*",
+		},
+		{
+			name: "markdown escapes",
+			mode: tb.ModeMarkdownV2,
+			feed: []string{`\*not bold\* \\`},
+		},
+		{
+			name:  "markdown underline before italic",
+			mode:  tb.ModeMarkdownV2,
+			feed:  []string{"__under"},
+			open:  "__",
+			close: "__",
+		},
+		{
+			name:  "markdown code ignores markers",
+			mode:  tb.ModeMarkdownV2,
+			feed:  []string{"```\n*a_b", "~c"},
+			open:  "```",
+			close: "```",
+		},
+		{
+			name: "markdown code closed",
+			mode: tb.ModeMarkdownV2,
+			feed: []string{"`a*b` c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newEntityTracker(tt.mode)
+			for _, s := range tt.feed {
+				tr.feed(s)
+			}
+			if got := tr.open(); got != tt.open {
+				t.Errorf("open() = %q, want %q", got, tt.open)
+			}
+			if got := tr.close(); got != tt.close {
+				t.Errorf("close() = %q, want %q", got, tt.close)
+			}
+		})
+	}
+}
+
+func TestOpenMarkup(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		mode tb.ParseMode
+		want int
+	}{
+		{name: "complete html", s: "<b>x</b> &amp;", mode: tb.ModeHTML, want: -1},
+		{name: "inside tag", s: `x <a href="u v`, mode: tb.ModeHTML, want: 2},
+		{name: "inside tag after entity", s: `&amp; <a title="a&b `, mode: tb.ModeHTML, want: 6},
+		{name: "inside entity", s: "a &am", mode: tb.ModeHTML, want: 2},
+		{name: "bare ampersand", s: "a & ", mode: tb.ModeHTML, want: -1},
+		{name: "plain mode ignores markup", s: "<a", mode: tb.ModeDefault, want: -1},
+		{name: "escape", s: `a\`, mode: tb.ModeMarkdownV2, want: 1},
+		{name: "escaped backslash", s: `a\\`, mode: tb.ModeMarkdownV2, want: -1},
+		{name: "escaped backslash then escape", s: `a\\\`, mode: tb.ModeMarkdownV2, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := openMarkup(tt.s, tt.mode); got != tt.want {
+				t.Errorf("openMarkup(%q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package threads
+
+import (
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/st-kuptsov/mail2tg/config"
+	"github.com/st-kuptsov/mail2tg/internal/email"
+	"github.com/st-kuptsov/mail2tg/internal/state"
+	"github.com/st-kuptsov/mail2tg/internal/telegram"
+	"go.uber.org/zap"
+)
+
+var (
+	// subjectPrefix — префиксы ответа и пересылки, в том числе вида Re[2]: и RE(3):
+	subjectPrefix = regexp.MustCompile(`(?i)^\s*(re|fwd?)\s*(\[\d+\]|\(\d+\))?\s*:\s*`)
+	// messageIDs выделяет идентификаторы писем из Message-ID, In-Reply-To и References
+	messageIDs = regexp.MustCompile(`<[^<>\s]+>`)
+)
+
+var (
+	threads *state.Index
+	logger  *zap.SugaredLogger
+)
+
+// Open открывает хранилище переписок и начинает запоминать первые сообщения о них
+func Open(path string, cfg config.ThreadsConfig, l *zap.SugaredLogger) error {
+	idx, err := state.OpenIndex(path, retention(cfg))
+	if err != nil {
+		return err
+	}
+	threads, logger = idx, l
+	telegram.OnSent(onSent)
+	return nil
+}
+
+// Track возвращает сообщение, ответом на которое нужно отправить письмо msg получателю
+// dest, и запоминает ключи переписки письма, чтобы связать их с сообщением после отправки.
+// ref — ссылка отправляемого сообщения (Message.Ref). 0 — письмо начинает новую переписку.
+func Track(cfg config.ThreadsConfig, msg email.Decoded, dest, ref string) int {
+	if threads == nil || !cfg.Enabled || ref == "" {
+		return 0
+	}
+	threads.SetTTL(retention(cfg))
+
+	ancestors, own := threadKeys(cfg, msg, dest)
+	root := 0
+	for _, key := range ancestors {
+		if id, ok := lookup(key); ok {
+			root = id
+			break
+		}
+	}
+
+	data, err := json.Marshal(append(ancestors, own...))
+	if err == nil {
+		err = threads.Put(pendingKey(ref), string(data))
+	}
+	if err != nil {
+		logger.Warnw("cannot save email thread", "channel", dest, "error", err)
+	}
+	return root
+}
+
+// onSent связывает ключи переписки с первым сообщением о ней
+func onSent(s telegram.SentMessage) {
+	data, ok := threads.Get(pendingKey(s.Ref))
+	if !ok {
+		return
+	}
+	var keys []string
+	if err := json.Unmarshal([]byte(data), &keys); err != nil {
+		return
+	}
+
+	root := s.MessageID
+	if s.ReplyTo != 0 {
+		root = s.ReplyTo
+	}
+	for _, key := range keys {
+		// Переписка остаётся привязанной к первому сообщению, но её срок продлевается
+		id, ok := lookup(key)
+		if !ok {
+			id = root
+		}
+		if err := threads.Put(key, strconv.Itoa(id)); err != nil {
+			logger.Warnw("cannot save email thread", "chat", s.ChatID, "error", err)
+			return
+		}
+	}
+	if err := threads.Delete(pendingKey(s.Ref)); err != nil {
+		logger.Warnw("cannot save email thread", "chat", s.ChatID, "error", err)
+	}
+}
+
+// threadKeys возвращает ключи переписки письма для получателя dest:
+// ancestors — по которым ищется начало переписки (In-Reply-To, References и тема),
+// own — Message-ID самого письма, по которому его найдут следующие письма
+func threadKeys(cfg config.ThreadsConfig, msg email.Decoded, dest string) (ancestors, own []string) {
+	// Ближайшие предки проверяются первыми
+	ids := messageIDs.FindAllString(msg.Header.Get("In-Reply-To"), -1)
+	refs := messageIDs.FindAllString(msg.Header.Get("References"), -1)
+	slices.Reverse(refs)
+	for _, id := range append(ids, refs...) {
+		if key := dest + "|id:" + id; !slices.Contains(ancestors, key) {
+			ancestors = append(ancestors, key)
+		}
+	}
+	if cfg.BySubject {
+		if subject := normalizeSubject(msg.Subject); subject != "" {
+			ancestors = append(ancestors, dest+"|subject:"+subject)
+		}
+	}
+	if id := messageIDs.FindString(msg.Header.Get("Message-ID")); id != "" {
+		own = append(own, dest+"|id:"+id)
+	}
+	return ancestors, own
+}
+
+// normalizeSubject убирает из темы префиксы Re:/Fwd:, лишние пробелы и регистр
+func normalizeSubject(subject string) string {
+	for {
+		s := subjectPrefix.ReplaceAllString(subject, "")
+		if s == subject {
+			break
+		}
+		subject = s
+	}
+	return strings.ToLower(strings.Join(strings.Fields(subject), " "))
+}
+
+func lookup(key string) (int, bool) {
+	v, ok := threads.Get(key)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(v)
+	return id, err == nil
+}
+
+func retention(cfg config.ThreadsConfig) time.Duration {
+	return time.Duration(cfg.RetentionHours) * time.Hour
+}
+
+func pendingKey(ref string) string {
+	return "pending:" + ref
+}
@@ -45,6 +45,23 @@ var (
 		},
 	)
 
+	// MailSuppressed - количество подавленных повторов писем
+	MailSuppressed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mail2tg_mailbox_suppressed_duplicates_total",
+			Help: "Number of duplicate emails suppressed by dedup",
+		},
+	)
+
+	// ImapConnections - количество открытых постоянных соединений с IMAP-сервером
+	ImapConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mail2tg_imap_connections",
+			Help: "Open pooled IMAP connections",
+		},
+		[]string{"account"},
+	)
+
 	// MailProcessingDuration - время обработки почты в секундах
 	MailProcessingDuration = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
@@ -87,6 +104,30 @@ var (
 		},
 		[]string{"channel_id"},
 	)
+
+	// TgQueueDepth - количество сообщений в очереди на отправку в Telegram
+	TgQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mail2tg_telegram_queue_depth",
+			Help: "Messages waiting in the Telegram outbox",
+		},
+	)
+
+	// TgDeadLetters - количество недоставленных сообщений в хранилище
+	TgDeadLetters = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mail2tg_telegram_dead_letters",
+			Help: "Messages in the Telegram dead letter store",
+		},
+	)
+
+	// TgQueueOldestAge - возраст самого старого сообщения в очереди в секундах
+	TgQueueOldestAge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mail2tg_telegram_queue_oldest_age_seconds",
+			Help: "Age of the oldest message in the Telegram outbox",
+		},
+	)
 )
 
 // InitMetrics регистрирует все метрики Prometheus
@@ -96,9 +137,14 @@ func InitMetrics() {
 		MailChecks,
 		MailReceived,
 		MailErrors,
+		MailSuppressed,
 		MailProcessingDuration,
+		ImapConnections,
 		TgMessagesSent,
 		TgErrors,
 		TgSendDuration,
+		TgQueueDepth,
+		TgQueueOldestAge,
+		TgDeadLetters,
 	)
 }